@@ -0,0 +1,154 @@
+package bosh
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Provision parameter setting availability zones of all instance groups,
+// e.g. {"azs": ["z1", "z2", "z3", "z4"]}
+const azsParameter = "azs"
+
+// Returns AZs given in provision parameters or nil if there are none
+func azsFromParameters(parameters map[string]interface{}) ([]string, error) {
+	value, found := parameters[azsParameter]
+	if !found {
+		return nil, nil
+	}
+
+	list, ok := value.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, &ParameterError{Parameter: azsParameter, Message: "must be a non-empty list of AZ names"}
+	}
+	azs := []string{}
+	seen := make(map[string]struct{})
+	for _, element := range list {
+		az, ok := element.(string)
+		if !ok || az == "" {
+			return nil, &ParameterError{Parameter: azsParameter, Message: "must be a non-empty list of AZ names"}
+		}
+		if _, found := seen[az]; found {
+			return nil, &ParameterError{Parameter: azsParameter, Message: fmt.Sprintf("AZ %s given more than once", az)}
+		}
+		seen[az] = struct{}{}
+		azs = append(azs, az)
+	}
+	return azs, nil
+}
+
+// Sets AZs of all instance groups to the ones given in provision parameters,
+// or else in plan config, or else for the director. AZs of the manifest are
+// kept if none are given.
+func (m *Manifest) configureAZs(details *Details, planConfig PlanConfig, parameters map[string]interface{}) error {
+	azs := details.AZs
+	if len(planConfig.AZs) > 0 {
+		azs = planConfig.AZs
+		m.azsConfigured = true
+	}
+	parameterAZs, err := azsFromParameters(parameters)
+	if err != nil {
+		return err
+	}
+	if parameterAZs != nil {
+		azs = parameterAZs
+		m.azsFromParameters = true
+		m.azsConfigured = true
+	}
+	if len(azs) == 0 {
+		return nil
+	}
+
+	for i := range m.InstanceGroups {
+		m.InstanceGroups[i].AZs = append([]string{}, azs...)
+	}
+	return nil
+}
+
+// ValidateAZs checks that AZs of all instance groups of the manifest as
+// sent to the director, i.e. after ops files are applied, are defined in
+// cloud config of the director
+func (m *Manifest) ValidateAZs(cloudConfig *CloudConfig) error {
+	if len(cloudConfig.AZs) == 0 {
+		log.Debugf("No AZs in cloud config, not validating AZs of deployment %s", m.Name)
+		return nil
+	}
+
+	available := make(map[string]struct{})
+	for _, name := range cloudConfig.AZNames() {
+		available[name] = struct{}{}
+	}
+	for _, instanceGroup := range m.deployed().InstanceGroups {
+		for _, az := range instanceGroup.AZs {
+			if _, found := available[az]; found {
+				continue
+			}
+			message := fmt.Sprintf("AZ %s of instance group %s is not defined in cloud config, available AZs are %s",
+				az, instanceGroup.Name, strings.Join(cloudConfig.AZNames(), ", "))
+			if m.hasParameterAZs(instanceGroup.Name) {
+				return &ParameterError{Parameter: azsParameter, Message: message}
+			}
+			return errors.New(message)
+		}
+	}
+	return nil
+}
+
+// Returns true if the instance group is deployed in the AZs given in
+// provision parameters, i.e. ops files did not change them
+func (m *Manifest) hasParameterAZs(instanceGroupName string) bool {
+	if !m.azsFromParameters {
+		return false
+	}
+	instanceGroup := m.InstanceGroup(instanceGroupName)
+	deployed := m.deployed().InstanceGroup(instanceGroupName)
+	return instanceGroup != nil && deployed != nil && reflect.DeepEqual(instanceGroup.AZs, deployed.AZs)
+}
+
+// PeerPlacement returns the number of peers BOSH places in each AZ of the
+// peer instance group of the manifest as sent to the director. BOSH spreads
+// instances evenly over the AZs, AZs listed first get the remaining ones.
+func (m *Manifest) PeerPlacement() map[string]uint {
+	placement := make(map[string]uint)
+	peerGroup := m.deployed().InstanceGroup(peerJobName)
+	if peerGroup == nil || len(peerGroup.AZs) == 0 {
+		return placement
+	}
+
+	azs := uint(len(peerGroup.AZs))
+	for i, az := range peerGroup.AZs {
+		placement[az] = peerGroup.Instances / azs
+		if uint(i) < peerGroup.Instances%azs {
+			placement[az]++
+		}
+	}
+	return placement
+}
+
+// CheckAZFaultTolerance returns an error if an AZ hosts more than f
+// validating peers of the manifest as sent to the director so that an
+// outage of the AZ stops pbft consensus
+func (m *Manifest) CheckAZFaultTolerance() error {
+	deployed := m.deployed()
+	peer := deployed.JobProperties(peerJobName, peerJobName)
+	if peer == nil || peer.Peer.Consensus.Plugin != ConsensusPBFT || peer.Peer.Consensus.PBFT == nil {
+		return nil
+	}
+	f := peer.Peer.Consensus.PBFT.F
+
+	placement := m.PeerPlacement()
+	azs := []string{}
+	for az := range placement {
+		azs = append(azs, az)
+	}
+	sort.Strings(azs)
+	for _, az := range azs {
+		if placement[az] > f {
+			return errors.New(fmt.Sprintf("AZ %s hosts %d of %d peers but pbft only tolerates %d failing, use more AZs or peers",
+				az, placement[az], deployed.InstanceGroup(peerJobName).Instances, f))
+		}
+	}
+	return nil
+}
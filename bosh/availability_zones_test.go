@@ -0,0 +1,104 @@
+package bosh_test
+
+import (
+	"testing"
+
+	"github.com/predix/fabric-service-broker/bosh"
+
+	. "gopkg.in/go-playground/assert.v1"
+)
+
+var cloudConfig = &bosh.CloudConfig{AZs: []bosh.AZ{{Name: "z1"}, {Name: "z2"}, {Name: "z3"}, {Name: "z4"}}}
+
+func TestNewManifest_AZs(t *testing.T) {
+	details := *boshDetails
+	details.AZs = []string{"z1", "z2", "z3"}
+	details.Plans = bosh.PlanConfigs{"plan-2": bosh.PlanConfig{AZs: []string{"z1", "z2", "z3", "z4"}}}
+	context := manifestContextWithDetails(deploymentName, networkName, true, &details)
+
+	manifest, err := bosh.NewManifest(context, nil)
+	Equal(t, err, nil)
+	Equal(t, manifest.InstanceGroup("peer").AZs, []string{"z1", "z2", "z3"})
+	Equal(t, manifest.InstanceGroup("membersrvc").AZs, []string{"z1", "z2", "z3"})
+	Equal(t, manifest.PeerPlacement(), map[string]uint{"z1": 2, "z2": 1, "z3": 1})
+	NotEqual(t, manifest.CheckAZFaultTolerance(), nil)
+	Equal(t, manifest.ValidateAZs(cloudConfig), nil)
+
+	context.PlanId = "plan-2"
+	manifest, err = bosh.NewManifest(context, nil)
+	Equal(t, err, nil)
+	Equal(t, manifest.PeerPlacement(), map[string]uint{"z1": 1, "z2": 1, "z3": 1, "z4": 1})
+	Equal(t, manifest.CheckAZFaultTolerance(), nil)
+
+	context.Parameters = map[string]interface{}{"azs": []interface{}{"z2", "z5"}}
+	manifest, err = bosh.NewManifest(context, nil)
+	Equal(t, err, nil)
+	Equal(t, manifest.InstanceGroup("peer").AZs, []string{"z2", "z5"})
+	err = manifest.ValidateAZs(cloudConfig)
+	NotEqual(t, err, nil)
+	Equal(t, bosh.IsParameterError(err), true)
+}
+
+func TestNewManifest_InvalidAZParameters(t *testing.T) {
+	context := manifestContext(deploymentName, networkName, false)
+	for _, azs := range []interface{}{"z1", []interface{}{}, []interface{}{"z1", 2}, []interface{}{"z1", "z1"}} {
+		context.Parameters = map[string]interface{}{"azs": azs}
+		_, err := bosh.NewManifest(context, nil)
+		NotEqual(t, err, nil)
+		Equal(t, bosh.IsParameterError(err), true)
+	}
+}
+
+func TestNewManifest_RequireAZFaultTolerance(t *testing.T) {
+	details := *boshDetails
+	details.Plans = bosh.PlanConfigs{"plan-1": bosh.PlanConfig{RequireAZFaultTolerance: true}}
+	context := manifestContextWithDetails(deploymentName, networkName, false, &details)
+
+	// Built-in manifest places 2 of 4 peers in each of z1 and z2
+	_, err := bosh.NewManifest(context, nil)
+	NotEqual(t, err, nil)
+	Equal(t, err.Error(), "AZ z1 hosts 2 of 4 peers but pbft only tolerates 1 failing, use more AZs or peers")
+	Equal(t, bosh.IsParameterError(err), false)
+
+	context.Parameters = map[string]interface{}{"azs": []interface{}{"z1", "z2", "z3"}}
+	_, err = bosh.NewManifest(context, nil)
+	Equal(t, bosh.IsParameterError(err), true)
+
+	context.Parameters = map[string]interface{}{"azs": []interface{}{"z1", "z2", "z3", "z4"}}
+	_, err = bosh.NewManifest(context, nil)
+	Equal(t, err, nil)
+
+	// Noops does not tolerate failures anyway
+	details.Plans["plan-1"] = bosh.PlanConfig{RequireAZFaultTolerance: true, Consensus: &bosh.ConsensusProperties{Plugin: bosh.ConsensusNoops}}
+	context.Parameters = nil
+	_, err = bosh.NewManifest(context, nil)
+	Equal(t, err, nil)
+}
+
+func TestNewManifest_AZsAfterOps(t *testing.T) {
+	details := *boshDetails
+	details.Plans = bosh.PlanConfigs{"plan-1": bosh.PlanConfig{RequireAZFaultTolerance: true}}
+	details.Ops = []bosh.Operation{{Type: bosh.OpReplace, Path: "/instance_groups/name=peer/azs", Value: []interface{}{"z1", "z2", "z3", "z4"}}}
+	context := manifestContextWithDetails(deploymentName, networkName, false, &details)
+
+	// Ops spread the peers of the built-in manifest over 4 AZs
+	manifest, err := bosh.NewManifest(context, nil)
+	Equal(t, err, nil)
+	Equal(t, manifest.PeerPlacement(), map[string]uint{"z1": 1, "z2": 1, "z3": 1, "z4": 1})
+	Equal(t, manifest.ValidateAZs(cloudConfig), nil)
+
+	// Ops place peers in an AZ that is not in cloud config
+	details.Ops = []bosh.Operation{{Type: bosh.OpReplace, Path: "/instance_groups/name=peer/azs", Value: []interface{}{"z1", "z2", "z3", "z5"}}}
+	manifest, err = bosh.NewManifest(context, nil)
+	Equal(t, err, nil)
+	err = manifest.ValidateAZs(cloudConfig)
+	NotEqual(t, err, nil)
+	Equal(t, bosh.IsParameterError(err), false)
+
+	// Ops put the peers back in 2 AZs although parameters spread them
+	details.Ops = []bosh.Operation{{Type: bosh.OpReplace, Path: "/instance_groups/name=peer/azs", Value: []interface{}{"z1", "z2"}}}
+	context.Parameters = map[string]interface{}{"azs": []interface{}{"z1", "z2", "z3", "z4"}}
+	_, err = bosh.NewManifest(context, nil)
+	NotEqual(t, err, nil)
+	Equal(t, err.Error(), "AZ z1 hosts 2 of 4 peers but pbft only tolerates 1 failing, use more AZs or peers")
+}
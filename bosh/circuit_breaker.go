@@ -0,0 +1,94 @@
+package bosh
+
+import (
+	"sync"
+	"time"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// CircuitBreaker stops calls to BOSH director after FailureThreshold
+// consecutive failures. Once ResetTimeout has passed a single trial call is
+// let through; its success closes the breaker again while a failure keeps it
+// open for another ResetTimeout.
+type CircuitBreaker struct {
+	failureThreshold int
+	resetTimeout     time.Duration
+
+	lock     sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+}
+
+// NewCircuitBreaker creates a circuit breaker. A failureThreshold of 0
+// disables the breaker.
+func NewCircuitBreaker(failureThreshold int, resetTimeout time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		failureThreshold: failureThreshold,
+		resetTimeout:     resetTimeout,
+	}
+}
+
+// Allow returns true if a call may be made to BOSH director
+func (b *CircuitBreaker) Allow() bool {
+	if b == nil || b.failureThreshold <= 0 {
+		return true
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.resetTimeout {
+			return false
+		}
+		log.Info("Circuit breaker for BOSH director is half open, allowing trial call")
+		b.state = breakerHalfOpen
+		return true
+	case breakerHalfOpen:
+		// Trial call is in flight
+		return false
+	}
+	return true
+}
+
+func (b *CircuitBreaker) RecordSuccess() {
+	if b == nil || b.failureThreshold <= 0 {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.state != breakerClosed {
+		log.Info("Circuit breaker for BOSH director closed")
+	}
+	b.state = breakerClosed
+	b.failures = 0
+}
+
+func (b *CircuitBreaker) RecordFailure() {
+	if b == nil || b.failureThreshold <= 0 {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.failureThreshold {
+		if b.state != breakerOpen {
+			log.Errorf("Circuit breaker for BOSH director opened after %d failures", b.failures)
+		}
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
@@ -18,16 +18,43 @@ import (
 
 var log = logging.MustGetLogger("bosh")
 
+// VM details are fetched while handlers hold the broker lock, so a busy
+// director must not block other requests for long
+const (
+	vmDetailsPollInterval = 500 * time.Millisecond
+	vmDetailsTimeout      = 5 * time.Second
+)
+
 type Client interface {
+	GetInfo() (*Info, error)
+	GetCloudConfig() (*CloudConfig, error)
 	CreateDeployment(manifest Manifest) (*Task, error)
 	DeleteDeployment(deploymentName string) (*Task, error)
 	GetTask(taskId string) (*Task, error)
-	GetVmIps(deploymentName string) (map[string][]string, error)
+	GetInFlightTasks() ([]Task, error)
+	GetDeploymentTasks(deploymentName string) ([]Task, error)
+	GetTaskOutput(taskId, outputType string) ([]byte, error)
+	GetTaskEvents(taskId string) (TaskEvents, error)
+	CancelTask(taskId string) error
+	GetInstanceDetails(deploymentName string) (Instances, error)
+	GetDeploymentManifest(deploymentName string) (string, error)
+	ChangeJobState(deploymentName, jobName, instanceId string, state JobState) (*Task, error)
+	RunErrand(deploymentName, errandName string) (*Task, error)
+	GetErrandResult(taskId string) (*ErrandResult, error)
+}
+
+// Info describes the director as returned by /info
+type Info struct {
+	Name    string `json:"name"`
+	UUID    string `json:"uuid"`
+	Version string `json:"version"`
 }
 
 type boshHttpClient struct {
-	httpClient  *http.Client
-	boshDetails *Details
+	httpClient     *http.Client
+	boshDetails    *Details
+	retryPolicy    RetryPolicy
+	circuitBreaker *CircuitBreaker
 }
 
 func newHttpClient(skipTLSVerification bool) *http.Client {
@@ -47,17 +74,99 @@ func newHttpClient(skipTLSVerification bool) *http.Client {
 	}
 }
 
-func NewBoshHttpClient(boshDetails *Details) Client {
+func NewBoshHttpClient(boshDetails *Details, retryPolicy RetryPolicy, circuitBreaker *CircuitBreaker) Client {
 	return &boshHttpClient{
-		boshDetails: boshDetails,
-		httpClient:  newHttpClient(true),
+		boshDetails:    boshDetails,
+		httpClient:     newHttpClient(true),
+		retryPolicy:    retryPolicy,
+		circuitBreaker: circuitBreaker,
+	}
+}
+
+// do sends a single request to BOSH director guarded by the circuit breaker.
+// Redirects are not followed, response for those is returned as is.
+func (c *boshHttpClient) do(request *http.Request) (*http.Response, error) {
+	if !c.circuitBreaker.Allow() {
+		log.Errorf("Circuit breaker open, not calling BOSH director for %s %s", request.Method, request.URL.Path)
+		return nil, errors.New(sberrors.ErrBoshUnavailable)
+	}
+
+	resp, err := c.httpClient.Do(request)
+	if err != nil && !strings.Contains(err.Error(), "No redirects") {
+		log.Error("Error in connecting to Bosh", err)
+		c.circuitBreaker.RecordFailure()
+		return nil, errors.New(sberrors.ErrBoshConnect)
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		c.circuitBreaker.RecordFailure()
+	} else {
+		c.circuitBreaker.RecordSuccess()
+	}
+	return resp, nil
+}
+
+// doIdempotent sends a request without body and retries it as per the retry
+// policy on connection errors and 5xx responses. It should only be used for
+// requests which can safely be repeated.
+func (c *boshHttpClient) doIdempotent(method, url string) (*http.Response, error) {
+	attempts := c.retryPolicy.attempts()
+	for attempt := 1; ; attempt++ {
+		request, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			log.Error("Error in creating http request", err)
+			return nil, errors.New(sberrors.ErrHttpRequest)
+		}
+
+		resp, err := c.do(request)
+		if err != nil && err.Error() == sberrors.ErrBoshUnavailable {
+			return nil, err
+		}
+		if err == nil && resp.StatusCode < http.StatusInternalServerError {
+			return resp, nil
+		}
+		if attempt >= attempts {
+			return resp, err
+		}
+
+		if err == nil {
+			log.Errorf("Status code %d from BOSH for %s %s", resp.StatusCode, method, request.URL.Path)
+			resp.Body.Close()
+		}
+		backoff := c.retryPolicy.Backoff(attempt)
+		log.Infof("Retrying %s %s in %s, attempt %d of %d", method, request.URL.Path, backoff, attempt+1, attempts)
+		time.Sleep(backoff)
 	}
 }
 
+func (c *boshHttpClient) GetInfo() (*Info, error) {
+	log.Debug("In GetInfo")
+	url := fmt.Sprintf("%s/info", c.boshDetails.BoshDirectorUrl)
+	resp, err := c.doIdempotent("GET", url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, newDirectorError(resp)
+	}
+
+	info := Info{}
+	err = json.NewDecoder(resp.Body).Decode(&info)
+	if err != nil {
+		log.Error("Error in decoding response from Bosh", err)
+		return nil, err
+	}
+	return &info, nil
+}
+
 func (c *boshHttpClient) CreateDeployment(manifest Manifest) (*Task, error) {
 	log.Debug("In CreateDeployment")
-	body := manifest.String()
-	log.Debugf("Manifest for deployment:%s", body)
+	body, err := manifest.String()
+	if err != nil {
+		return nil, &ManifestError{Message: err.Error()}
+	}
+	// Manifest is not logged as it contains membership service secrets
+	log.Debugf("Manifest for deployment:%s has %d bytes", manifest.Name, len(body))
 
 	url := fmt.Sprintf("%s%s", c.boshDetails.BoshDirectorUrl, "/deployments")
 	request, err := http.NewRequest("POST", url, bytes.NewReader([]byte(body)))
@@ -68,11 +177,11 @@ func (c *boshHttpClient) CreateDeployment(manifest Manifest) (*Task, error) {
 	request.Header.Set("Content-Type", "text/yaml")
 	log.Debugf("Http request for BOSH director created")
 
-	resp, err := c.httpClient.Do(request)
-	if err != nil && !strings.Contains(err.Error(), "No redirects") {
-		log.Error("Error in connecting to Bosh", err)
-		return nil, errors.New(sberrors.ErrBoshConnect)
+	resp, err := c.do(request)
+	if err != nil {
+		return nil, err
 	}
+	defer resp.Body.Close()
 
 	taskId, err := getTaskId(resp)
 	if err != nil {
@@ -94,11 +203,11 @@ func (c *boshHttpClient) DeleteDeployment(deploymentName string) (*Task, error)
 		return nil, errors.New(sberrors.ErrHttpRequest)
 	}
 
-	resp, err := c.httpClient.Do(delRequest)
-	if err != nil && !strings.Contains(err.Error(), "No redirects") {
-		log.Error("Error in connecting to Bosh", err)
-		return nil, errors.New(sberrors.ErrBoshConnect)
+	resp, err := c.do(delRequest)
+	if err != nil {
+		return nil, err
 	}
+	defer resp.Body.Close()
 
 	taskId, err := getTaskId(resp)
 	if err != nil {
@@ -112,18 +221,14 @@ func (c *boshHttpClient) DeleteDeployment(deploymentName string) (*Task, error)
 func (c *boshHttpClient) GetTask(taskId string) (*Task, error) {
 	log.Debug("In GetTask")
 	url := fmt.Sprintf("%s%s%s", c.boshDetails.BoshDirectorUrl, "/tasks/", taskId)
-	resp, err := c.httpClient.Get(url)
-	if err != nil && !strings.Contains(err.Error(), "No redirects") {
-		log.Error("Error in connecting to Bosh", err)
+	resp, err := c.doIdempotent("GET", url)
+	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 	log.Debug("Received response from Bosh")
-	if resp.StatusCode == http.StatusNotFound {
-		return nil, errors.New("Not found")
-	}
 	if resp.StatusCode != http.StatusOK {
-		log.Errorf("Non OK status code from BOSH: %d", resp.StatusCode)
-		return nil, errors.New(fmt.Sprintf("Non OK status code from BOSH: %d", resp.StatusCode))
+		return nil, newDirectorError(resp)
 	}
 
 	task := Task{}
@@ -136,58 +241,135 @@ func (c *boshHttpClient) GetTask(taskId string) (*Task, error) {
 	return &task, nil
 }
 
-func parseVMIpsFromResponse(response *http.Response) (map[string][]string, error) {
-	data, err := ioutil.ReadAll(response.Body)
+// GetInFlightTasks returns all tasks of the director that are queued, being
+// processed or being cancelled
+func (c *boshHttpClient) GetInFlightTasks() ([]Task, error) {
+	log.Debug("In GetInFlightTasks")
+	states := []string{string(BoshStateQueued), string(BoshStateProcessing), string(BoshStateCancelling)}
+	url := fmt.Sprintf("%s/tasks?state=%s&verbose=2", c.boshDetails.BoshDirectorUrl, strings.Join(states, ","))
+	resp, err := c.doIdempotent("GET", url)
 	if err != nil {
-		log.Error("Error reading from response", err)
 		return nil, err
 	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, newDirectorError(resp)
+	}
 
-	vmDetails := strings.Split(string(data), "\n")
-	vmIpsMap := make(map[string][]string)
-	for _, vmDetail := range vmDetails {
-		if strings.Trim(vmDetail, " ") == "" {
-			log.Debug("Skipping empty line")
-			continue
-		}
-		vmDetailMap := make(map[string]interface{})
-		err := json.Unmarshal([]byte(vmDetail), &vmDetailMap)
+	tasks := []Task{}
+	err = json.NewDecoder(resp.Body).Decode(&tasks)
+	if err != nil {
+		log.Error("Error in decoding response from Bosh", err)
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// GetDeploymentTasks returns recent tasks of the deployment in any state,
+// newest first
+func (c *boshHttpClient) GetDeploymentTasks(deploymentName string) ([]Task, error) {
+	log.Debug("In GetDeploymentTasks")
+	url := fmt.Sprintf("%s/tasks?deployment=%s&verbose=2", c.boshDetails.BoshDirectorUrl, deploymentName)
+	resp, err := c.doIdempotent("GET", url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, newDirectorError(resp)
+	}
+
+	tasks := []Task{}
+	err = json.NewDecoder(resp.Body).Decode(&tasks)
+	if err != nil {
+		log.Error("Error in decoding response from Bosh", err)
+		return nil, err
+	}
+	return tasks, nil
+}
+
+func (c *boshHttpClient) CancelTask(taskId string) error {
+	log.Debug("In CancelTask")
+	url := fmt.Sprintf("%s%s%s", c.boshDetails.BoshDirectorUrl, "/tasks/", taskId)
+
+	resp, err := c.doIdempotent("DELETE", url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		log.Errorf("Unable to cancel task:%s", taskId)
+		return newDirectorError(resp)
+	}
+
+	log.Infof("Successfully requested cancellation of task:%s", taskId)
+	return nil
+}
+
+// WaitForTask polls the task every pollInterval until it reaches a terminal
+// state or timeout expires
+func WaitForTask(client Client, taskId string, pollInterval, timeout time.Duration) (*Task, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		task, err := client.GetTask(taskId)
 		if err != nil {
-			log.Error("Error in unmarshaling vm details", err)
-			continue
+			return nil, err
 		}
-		jobName := vmDetailMap["job_name"].(string)
-		log.Debugf("Parsing job %s", jobName)
-		vmIps := vmDetailMap["ips"].([]interface{})
-		if len(vmIps) < 1 {
-			log.Errorf("No IPs for job %s", jobName)
-			continue
+		if task.State.IsTerminal() {
+			return task, nil
 		}
-		vmIp := vmIps[0].(string)
-		log.Debugf("IP for job:%s is %s", jobName, vmIp)
-
-		jobIps, exists := vmIpsMap[jobName]
-		if !exists {
-			log.Debugf("No entry for %s in map...making one", jobName)
-			jobIps = make([]string, 0)
+		if time.Now().After(deadline) {
+			return nil, &TaskTimeoutError{TaskId: taskId, State: task.State}
 		}
-		jobIps = append(jobIps, vmIp)
-		log.Debugf("IPs for job:%s is %s", jobName, jobIps)
-		vmIpsMap[jobName] = jobIps
+		log.Debugf("Task:%s is in state %s, waiting", taskId, task.State)
+		time.Sleep(pollInterval)
+	}
+}
+
+func (c *boshHttpClient) GetTaskOutput(taskId, outputType string) ([]byte, error) {
+	log.Debugf("In GetTaskOutput for task:%s type:%s", taskId, outputType)
+	url := fmt.Sprintf("%s/tasks/%s/output?type=%s", c.boshDetails.BoshDirectorUrl, taskId, outputType)
+	resp, err := c.doIdempotent("GET", url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent {
+		log.Debugf("No %s output available yet for task:%s", outputType, taskId)
+		return []byte{}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newDirectorError(resp)
 	}
 
-	return vmIpsMap, nil
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Error("Error reading from response", err)
+		return nil, err
+	}
+	return data, nil
 }
 
-func (c *boshHttpClient) GetVmIps(deploymentName string) (map[string][]string, error) {
-	log.Debug("In GetVMIps")
+func (c *boshHttpClient) GetTaskEvents(taskId string) (TaskEvents, error) {
+	log.Debug("In GetTaskEvents")
+	data, err := c.GetTaskOutput(taskId, TaskOutputEvent)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTaskEvents(data), nil
+}
+
+func (c *boshHttpClient) GetInstanceDetails(deploymentName string) (Instances, error) {
+	log.Debug("In GetInstanceDetails")
 
 	url := fmt.Sprintf("%s/deployments/%s/vms?format=full", c.boshDetails.BoshDirectorUrl, deploymentName)
-	resp, err := c.httpClient.Get(url)
-	if err != nil && !strings.Contains(err.Error(), "No redirects") {
-		log.Error("Error in connecting to Bosh", err)
+	resp, err := c.doIdempotent("GET", url)
+	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 	log.Debug("Received response from Bosh")
 
 	taskId, err := getTaskId(resp)
@@ -196,33 +378,128 @@ func (c *boshHttpClient) GetVmIps(deploymentName string) (map[string][]string, e
 	}
 	log.Debugf("Initiated get vm details operation. Task ID:%s", taskId)
 
-	taskOutputUrl := fmt.Sprintf("%s/tasks/%s/output?type=result", c.boshDetails.BoshDirectorUrl, taskId)
-	attempts := 1
-	log.Debug("Wait before querying the task output")
-	time.Sleep(500 * time.Millisecond)
-	for attempts <= 3 {
-		log.Debugf("Attempt number: %d to get VM details", attempts)
-		taskOutputResponse, err := c.httpClient.Get(taskOutputUrl)
-		if err != nil {
-			log.Error("Error in connecting to Bosh", err)
-			return nil, err
-		}
-		if taskOutputResponse.StatusCode == http.StatusOK {
-			return parseVMIpsFromResponse(taskOutputResponse)
-		}
-		if taskOutputResponse.StatusCode != http.StatusNoContent {
-			log.Errorf("Error in getting deployment details. Status code: %d.", taskOutputResponse.StatusCode)
-			return nil, errors.New("Error in getting deployment details")
-		}
+	task, err := WaitForTask(c, taskId, vmDetailsPollInterval, vmDetailsTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if !task.State.IsSuccess() {
+		log.Errorf("Get vm details task:%s ended in state %s", taskId, task.State)
+		return nil, errors.New(fmt.Sprintf("Error in getting deployment details: %s", task.Result))
+	}
 
-		time.Sleep(time.Duration(attempts) * time.Second)
-		attempts++
+	data, err := c.GetTaskOutput(taskId, TaskOutputResult)
+	if err != nil {
+		return nil, err
 	}
-	log.Errorf("Could not get deployment details after %d attemts", attempts)
-	return nil, errors.New("Max retries exceeded in getting deployment details from Bosh")
+	return ParseInstances(data), nil
+}
+
+func (c *boshHttpClient) GetDeploymentManifest(deploymentName string) (string, error) {
+	log.Debug("In GetDeploymentManifest")
+	url := fmt.Sprintf("%s/deployments/%s", c.boshDetails.BoshDirectorUrl, deploymentName)
+	resp, err := c.doIdempotent("GET", url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", newDirectorError(resp)
+	}
+
+	deployment := struct {
+		Manifest string `json:"manifest"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&deployment)
+	if err != nil {
+		log.Error("Error in decoding response from Bosh", err)
+		return "", err
+	}
+	return deployment.Manifest, nil
+}
+
+// ChangeJobState changes state of jobs in a deployment. Empty jobName applies
+// the change to all jobs and empty instanceId to all instances of the job.
+// instanceId can be either index or id of the instance.
+func (c *boshHttpClient) ChangeJobState(deploymentName, jobName, instanceId string, state JobState) (*Task, error) {
+	log.Debugf("In ChangeJobState for deployment:%s job:%s instance:%s state:%s", deploymentName, jobName, instanceId, state)
+
+	manifest, err := c.GetDeploymentManifest(deploymentName)
+	if err != nil {
+		return nil, err
+	}
+
+	if jobName == "" {
+		jobName = "*"
+	}
+	path := fmt.Sprintf("/deployments/%s/jobs/%s", deploymentName, jobName)
+	if instanceId != "" {
+		path = fmt.Sprintf("%s/%s", path, instanceId)
+	}
+	url := fmt.Sprintf("%s%s?state=%s", c.boshDetails.BoshDirectorUrl, path, state)
+
+	request, err := http.NewRequest("PUT", url, bytes.NewReader([]byte(manifest)))
+	if err != nil {
+		log.Error("Error in creating http request", err)
+		return nil, errors.New(sberrors.ErrHttpRequest)
+	}
+	request.Header.Set("Content-Type", "text/yaml")
+
+	resp, err := c.do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	taskId, err := getTaskId(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Successfully initiated state change to %s for %s. Task Id is: %s", state, path, taskId)
+	return c.GetTask(taskId)
+}
+
+func (c *boshHttpClient) RunErrand(deploymentName, errandName string) (*Task, error) {
+	log.Debug("In RunErrand")
+	url := fmt.Sprintf("%s/deployments/%s/errands/%s/runs", c.boshDetails.BoshDirectorUrl, deploymentName, errandName)
+
+	request, err := http.NewRequest("POST", url, bytes.NewReader([]byte(`{"keep-alive":false}`)))
+	if err != nil {
+		log.Error("Error in creating http request", err)
+		return nil, errors.New(sberrors.ErrHttpRequest)
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	taskId, err := getTaskId(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Successfully initiated errand %s for deployment:%s. Task Id is: %s", errandName, deploymentName, taskId)
+	return c.GetTask(taskId)
+}
+
+func (c *boshHttpClient) GetErrandResult(taskId string) (*ErrandResult, error) {
+	log.Debug("In GetErrandResult")
+	data, err := c.GetTaskOutput(taskId, TaskOutputResult)
+	if err != nil {
+		return nil, err
+	}
+	return ParseErrandResult(data)
 }
 
 func getTaskId(resp *http.Response) (string, error) {
+	if resp.StatusCode >= http.StatusBadRequest {
+		return "", newDirectorError(resp)
+	}
+
 	taskUrl := resp.Header.Get("Location")
 	if taskUrl == "" {
 		log.Error("Invalid response from Bosh")
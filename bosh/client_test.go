@@ -0,0 +1,217 @@
+package bosh_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/predix/fabric-service-broker/bosh"
+	sberrors "github.com/predix/fabric-service-broker/errors"
+
+	. "gopkg.in/go-playground/assert.v1"
+)
+
+var testRetryPolicy = bosh.RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     5 * time.Millisecond,
+	Multiplier:     2,
+}
+
+func newTestClient(handler http.HandlerFunc, circuitBreaker *bosh.CircuitBreaker) (bosh.Client, *httptest.Server) {
+	server := httptest.NewServer(handler)
+	details := bosh.NewDetails(boshStemcell, boshUuid, vmType, networkNames, server.URL, peerDataDir, dockerDataDir)
+	return bosh.NewBoshHttpClient(details, testRetryPolicy, circuitBreaker), server
+}
+
+func TestGetTask_RetriesOnServerError(t *testing.T) {
+	calls := 0
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		fmt.Fprint(w, `{"id": 5, "state": "done"}`)
+	}, nil)
+	defer server.Close()
+
+	task, err := client.GetTask("5")
+	Equal(t, err, nil)
+	Equal(t, task.Id, 5)
+	Equal(t, task.State, bosh.BoshStateDone)
+	Equal(t, calls, 3)
+}
+
+func TestGetTask_GivesUpAfterMaxAttempts(t *testing.T) {
+	calls := 0
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}, nil)
+	defer server.Close()
+
+	_, err := client.GetTask("5")
+	NotEqual(t, err, nil)
+	Equal(t, calls, 3)
+}
+
+func TestGetTask_CircuitBreakerOpen(t *testing.T) {
+	calls := 0
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}, bosh.NewCircuitBreaker(2, time.Minute))
+	defer server.Close()
+
+	_, err := client.GetTask("5")
+	NotEqual(t, err, nil)
+	Equal(t, err.Error(), sberrors.ErrBoshUnavailable)
+	Equal(t, calls, 2)
+
+	_, err = client.GetTask("5")
+	Equal(t, err.Error(), sberrors.ErrBoshUnavailable)
+	Equal(t, calls, 2)
+}
+
+func TestDeleteDeployment_Locked(t *testing.T) {
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"code":100,"description":"Failed to acquire lock for lock:deployment:mydeployment uid: 1234"}`)
+	}, nil)
+	defer server.Close()
+
+	_, err := client.DeleteDeployment(deploymentName)
+	NotEqual(t, err, nil)
+	Equal(t, bosh.IsLockError(err), true)
+	Equal(t, bosh.IsNotFoundError(err), false)
+
+	directorError := err.(*bosh.DirectorError)
+	Equal(t, directorError.StatusCode, http.StatusBadRequest)
+	Equal(t, directorError.Code, 100)
+}
+
+func TestDirectorError_IsLockError(t *testing.T) {
+	// Matched on code regardless of wording
+	Equal(t, (&bosh.DirectorError{StatusCode: http.StatusBadRequest, Code: 100001, Description: "Deployment busy"}).IsLockError(), true)
+	Equal(t, (&bosh.DirectorError{StatusCode: http.StatusConflict}).IsLockError(), true)
+	// Description only counts for errors without a specific code
+	Equal(t, (&bosh.DirectorError{StatusCode: http.StatusBadRequest, Description: "Deployment is locked"}).IsLockError(), true)
+	Equal(t, (&bosh.DirectorError{StatusCode: http.StatusBadRequest, Code: 190014, Description: "Release is locked"}).IsLockError(), false)
+}
+
+func TestDeleteDeployment_NotFound(t *testing.T) {
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"code":70000,"description":"Deployment 'mydeployment' doesn't exist"}`)
+	}, nil)
+	defer server.Close()
+
+	_, err := client.DeleteDeployment(deploymentName)
+	NotEqual(t, err, nil)
+	Equal(t, bosh.IsNotFoundError(err), true)
+	Equal(t, bosh.IsLockError(err), false)
+}
+
+func TestDeleteDeployment_UnparsableError(t *testing.T) {
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, "Not authorized")
+	}, nil)
+	defer server.Close()
+
+	_, err := client.DeleteDeployment(deploymentName)
+	NotEqual(t, err, nil)
+	directorError := err.(*bosh.DirectorError)
+	Equal(t, directorError.StatusCode, http.StatusUnauthorized)
+	Equal(t, directorError.Description, "Not authorized")
+}
+
+func TestGetInFlightTasks(t *testing.T) {
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		Equal(t, r.URL.Path, "/tasks")
+		Equal(t, r.URL.Query().Get("state"), "queued,processing,cancelling")
+		fmt.Fprint(w, `[{"id": 7, "state": "processing", "deployment": "fabric-1"}, {"id": 8, "state": "queued", "deployment": "cf"}]`)
+	}, nil)
+	defer server.Close()
+
+	tasks, err := client.GetInFlightTasks()
+	Equal(t, err, nil)
+	Equal(t, len(tasks), 2)
+	Equal(t, tasks[0].Deployment, "fabric-1")
+	Equal(t, tasks[1].State, bosh.BoshStateQueued)
+}
+
+func TestGetDeploymentTasks(t *testing.T) {
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		Equal(t, r.URL.Path, "/tasks")
+		Equal(t, r.URL.Query().Get("deployment"), "fabric-1")
+		fmt.Fprint(w, `[{"id": 9, "state": "done", "deployment": "fabric-1"}, {"id": 7, "state": "error", "deployment": "fabric-1"}]`)
+	}, nil)
+	defer server.Close()
+
+	tasks, err := client.GetDeploymentTasks("fabric-1")
+	Equal(t, err, nil)
+	Equal(t, len(tasks), 2)
+	Equal(t, tasks[0].Id, 9)
+	Equal(t, tasks[1].State, bosh.BoshStateError)
+}
+
+func TestGetInfo(t *testing.T) {
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		Equal(t, r.URL.Path, "/info")
+		fmt.Fprint(w, `{"name": "bosh-lite", "uuid": "uuid-1", "version": "260.0.0"}`)
+	}, nil)
+	defer server.Close()
+
+	info, err := client.GetInfo()
+	Equal(t, err, nil)
+	Equal(t, info.Name, "bosh-lite")
+}
+
+func TestGetCloudConfig(t *testing.T) {
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		Equal(t, r.URL.Path, "/configs")
+		Equal(t, r.URL.Query().Get("type"), "cloud")
+		fmt.Fprint(w, `[{"id": "1", "name": "default", "type": "cloud", "content": "azs:\n- name: z1\n- name: z2\n"},
+			{"id": "2", "name": "extra", "type": "cloud", "content": "azs:\n- name: z3\n"}]`)
+	}, nil)
+	defer server.Close()
+
+	cloudConfig, err := client.GetCloudConfig()
+	Equal(t, err, nil)
+	Equal(t, cloudConfig.AZNames(), []string{"z1", "z2", "z3"})
+}
+
+func TestGetCloudConfig_LegacyDirector(t *testing.T) {
+	client, server := newTestClient(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/configs" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		Equal(t, r.URL.Path, "/cloud_configs")
+		fmt.Fprint(w, `[{"properties": "azs:\n- name: z1\n", "created_at": "2016-10-01 10:00:00 UTC"}]`)
+	}, nil)
+	defer server.Close()
+
+	cloudConfig, err := client.GetCloudConfig()
+	Equal(t, err, nil)
+	Equal(t, cloudConfig.AZNames(), []string{"z1"})
+}
+
+// Director whose tasks never finish
+type busyClient struct {
+	bosh.Client
+}
+
+func (busyClient) GetTask(taskId string) (*bosh.Task, error) {
+	return &bosh.Task{State: bosh.BoshStateProcessing}, nil
+}
+
+func TestWaitForTask_Timeout(t *testing.T) {
+	_, err := bosh.WaitForTask(busyClient{}, "7", time.Millisecond, 5*time.Millisecond)
+	Equal(t, bosh.IsTaskTimeoutError(err), true)
+	Equal(t, err.Error(), "Timed out waiting for task:7, last state processing")
+}
@@ -0,0 +1,101 @@
+package bosh
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"gopkg.in/yaml.v2"
+)
+
+// CloudConfig holds the parts of cloud config of the director that
+// manifests are checked against
+type CloudConfig struct {
+	AZs []AZ `yaml:"azs"`
+}
+
+type AZ struct {
+	Name string `yaml:"name"`
+}
+
+// AZNames returns names of availability zones defined in cloud config
+func (c *CloudConfig) AZNames() []string {
+	names := []string{}
+	for _, az := range c.AZs {
+		names = append(names, az.Name)
+	}
+	return names
+}
+
+// Merges cloud configs given as YAML documents. Directors can have several
+// named cloud configs which apply together.
+func parseCloudConfigs(documents []string) (*CloudConfig, error) {
+	cloudConfig := CloudConfig{}
+	for _, document := range documents {
+		part := CloudConfig{}
+		err := yaml.Unmarshal([]byte(document), &part)
+		if err != nil {
+			log.Error("Error unmarshalling cloud config", err)
+			return nil, err
+		}
+		cloudConfig.AZs = append(cloudConfig.AZs, part.AZs...)
+	}
+	return &cloudConfig, nil
+}
+
+func (c *boshHttpClient) GetCloudConfig() (*CloudConfig, error) {
+	log.Debug("In GetCloudConfig")
+	url := fmt.Sprintf("%s/configs?type=cloud&latest=true", c.boshDetails.BoshDirectorUrl)
+	resp, err := c.doIdempotent("GET", url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		// Directors before generic configs only have a single cloud config
+		return c.getLegacyCloudConfig()
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newDirectorError(resp)
+	}
+
+	configs := []struct {
+		Content string `json:"content"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&configs)
+	if err != nil {
+		log.Error("Error in decoding response from Bosh", err)
+		return nil, err
+	}
+	documents := []string{}
+	for _, config := range configs {
+		documents = append(documents, config.Content)
+	}
+	return parseCloudConfigs(documents)
+}
+
+func (c *boshHttpClient) getLegacyCloudConfig() (*CloudConfig, error) {
+	url := fmt.Sprintf("%s/cloud_configs?limit=1", c.boshDetails.BoshDirectorUrl)
+	resp, err := c.doIdempotent("GET", url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, newDirectorError(resp)
+	}
+
+	configs := []struct {
+		Properties string `json:"properties"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&configs)
+	if err != nil {
+		log.Error("Error in decoding response from Bosh", err)
+		return nil, err
+	}
+	documents := []string{}
+	for _, config := range configs {
+		documents = append(documents, config.Properties)
+	}
+	return parseCloudConfigs(documents)
+}
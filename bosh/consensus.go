@@ -0,0 +1,206 @@
+package bosh
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	ConsensusPBFT  = "pbft"
+	ConsensusNoops = "noops"
+
+	// Provision parameter overriding consensus settings of plan, e.g.
+	// {"consensus": {"pbft": {"batch_size": 100}}}
+	consensusParameter = "consensus"
+)
+
+// ConsensusProperties configure the consensus plugin of validating peers.
+// Noops does no consensus at all and is only meant for development networks.
+type ConsensusProperties struct {
+	Plugin string        `yaml:"plugin"`
+	PBFT   *PBFTSettings `yaml:"pbft,omitempty"`
+}
+
+// PBFTSettings tune pbft consensus. N is the number of validating peers and
+// f the number of faulty peers tolerated, N must be at least 3f+1. Both
+// default to the values for the number of peers in the deployment. Other
+// settings keep the defaults of fabric-release if not set.
+type PBFTSettings struct {
+	N                 uint   `yaml:"n,omitempty"`
+	F                 uint   `yaml:"f,omitempty"`
+	BatchSize         uint   `yaml:"batch_size,omitempty"`
+	BatchTimeout      string `yaml:"batch_timeout,omitempty"`
+	RequestTimeout    string `yaml:"request_timeout,omitempty"`
+	ViewChangeTimeout string `yaml:"view_change_timeout,omitempty"`
+}
+
+// Validate checks that settings are consistent with the number of peers
+func (c *ConsensusProperties) Validate(peers uint) error {
+	switch c.Plugin {
+	case ConsensusNoops:
+		if c.PBFT != nil {
+			return errors.New("Settings for pbft cannot be given for noops consensus")
+		}
+		return nil
+	case ConsensusPBFT:
+	default:
+		return errors.New(fmt.Sprintf("Unknown consensus plugin %s, must be %s or %s", c.Plugin, ConsensusPBFT, ConsensusNoops))
+	}
+
+	if c.PBFT == nil {
+		return nil
+	}
+	if c.PBFT.N != 0 && c.PBFT.N != peers {
+		return errors.New(fmt.Sprintf("pbft N is %d but deployment has %d peers", c.PBFT.N, peers))
+	}
+	n, f := c.PBFT.withDefaults(peers)
+	if n < 3*f+1 {
+		return errors.New(fmt.Sprintf("pbft needs N >= 3f+1 but N is %d and f is %d", n, f))
+	}
+	for name, timeout := range map[string]string{
+		"batch_timeout":       c.PBFT.BatchTimeout,
+		"request_timeout":     c.PBFT.RequestTimeout,
+		"view_change_timeout": c.PBFT.ViewChangeTimeout,
+	} {
+		if timeout == "" {
+			continue
+		}
+		_, err := time.ParseDuration(timeout)
+		if err != nil {
+			return errors.New(fmt.Sprintf("pbft %s %s is not a duration, e.g. 2s", name, timeout))
+		}
+	}
+	return nil
+}
+
+// Sets plugin and settings given in other
+func (c *ConsensusProperties) override(other *ConsensusProperties) {
+	if other == nil {
+		return
+	}
+	if other.Plugin != "" {
+		c.Plugin = other.Plugin
+	}
+	if other.Plugin == ConsensusNoops {
+		// Settings of pbft given before do not apply anymore
+		c.PBFT = nil
+	}
+	if other.PBFT == nil {
+		return
+	}
+	if c.PBFT == nil {
+		c.PBFT = &PBFTSettings{}
+	}
+	if other.PBFT.N != 0 {
+		c.PBFT.N = other.PBFT.N
+	}
+	if other.PBFT.F != 0 {
+		c.PBFT.F = other.PBFT.F
+	}
+	if other.PBFT.BatchSize != 0 {
+		c.PBFT.BatchSize = other.PBFT.BatchSize
+	}
+	if other.PBFT.BatchTimeout != "" {
+		c.PBFT.BatchTimeout = other.PBFT.BatchTimeout
+	}
+	if other.PBFT.RequestTimeout != "" {
+		c.PBFT.RequestTimeout = other.PBFT.RequestTimeout
+	}
+	if other.PBFT.ViewChangeTimeout != "" {
+		c.PBFT.ViewChangeTimeout = other.PBFT.ViewChangeTimeout
+	}
+}
+
+// Sets N and f of pbft to the values for the number of peers unless given
+func (c *ConsensusProperties) setDefaults(peers uint) {
+	if c.Plugin != ConsensusPBFT {
+		return
+	}
+	if c.PBFT == nil {
+		c.PBFT = &PBFTSettings{}
+	}
+	c.PBFT.N, c.PBFT.F = c.PBFT.withDefaults(peers)
+}
+
+// Returns N and f, defaulting to the values for the number of peers
+func (s *PBFTSettings) withDefaults(peers uint) (uint, uint) {
+	n, f := s.N, s.F
+	if n == 0 {
+		n = peers
+	}
+	if f == 0 && n > 0 {
+		f = (n - 1) / 3
+	}
+	return n, f
+}
+
+// Returns consensus settings given in provision parameters or nil if there
+// are none
+func consensusFromParameters(parameters map[string]interface{}) (*ConsensusProperties, error) {
+	value, found := parameters[consensusParameter]
+	if !found {
+		return nil, nil
+	}
+
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return nil, &ParameterError{Parameter: consensusParameter, Message: err.Error()}
+	}
+	consensus := ConsensusProperties{}
+	err = yaml.Unmarshal(data, &consensus)
+	if err != nil {
+		return nil, &ParameterError{Parameter: consensusParameter, Message: err.Error()}
+	}
+	return &consensus, nil
+}
+
+// Applies consensus settings of plan and then of provision parameters to the
+// peer job. Only settings given are written, N and f are left for
+// setConsensusDefaults as ops files may change the number of peers.
+func (m *Manifest) configureConsensus(planConsensus *ConsensusProperties, parameters map[string]interface{}) error {
+	peer := m.JobProperties(peerJobName, peerJobName)
+	if peer == nil {
+		return nil
+	}
+
+	consensus := &peer.Peer.Consensus
+	consensus.override(planConsensus)
+	parameterConsensus, err := consensusFromParameters(parameters)
+	if err != nil {
+		return err
+	}
+	consensus.override(parameterConsensus)
+	if consensus.Plugin == "" && consensus.PBFT == nil {
+		// Left to defaults of fabric-release
+		return nil
+	}
+	if len(m.ops) > 0 {
+		// Validated on the manifest after applying ops files
+		return nil
+	}
+
+	err = consensus.Validate(m.InstanceGroup(peerJobName).Instances)
+	if err != nil && parameterConsensus != nil {
+		return &ParameterError{Parameter: consensusParameter, Message: err.Error()}
+	}
+	return err
+}
+
+// Sets N and f of pbft not given to the values for the number of peers of
+// the manifest as sent to the director, i.e. after ops files are applied
+func (m *Manifest) setConsensusDefaults() {
+	deployed := m.deployed()
+	peers := deployed.InstanceGroup(peerJobName)
+	if peers == nil {
+		return
+	}
+	for _, manifest := range []*Manifest{m, deployed} {
+		peer := manifest.JobProperties(peerJobName, peerJobName)
+		if peer != nil {
+			peer.Peer.Consensus.setDefaults(peers.Instances)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package bosh_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/predix/fabric-service-broker/bosh"
+
+	. "gopkg.in/go-playground/assert.v1"
+)
+
+func TestConsensusProperties_Validate(t *testing.T) {
+	consensus := bosh.ConsensusProperties{Plugin: bosh.ConsensusPBFT, PBFT: &bosh.PBFTSettings{N: 4, F: 1, BatchTimeout: "2s"}}
+	Equal(t, consensus.Validate(4), nil)
+	NotEqual(t, consensus.Validate(5), nil)
+
+	consensus.PBFT.F = 2
+	Equal(t, consensus.Validate(4).Error(), "pbft needs N >= 3f+1 but N is 4 and f is 2")
+
+	consensus.PBFT.F = 1
+	consensus.PBFT.RequestTimeout = "2 seconds"
+	NotEqual(t, consensus.Validate(4), nil)
+
+	noops := bosh.ConsensusProperties{Plugin: bosh.ConsensusNoops}
+	Equal(t, noops.Validate(1), nil)
+	noops.PBFT = &bosh.PBFTSettings{BatchSize: 10}
+	NotEqual(t, noops.Validate(1), nil)
+
+	unknown := bosh.ConsensusProperties{Plugin: "raft"}
+	NotEqual(t, unknown.Validate(4), nil)
+}
+
+func TestNewManifest_PlanConsensus(t *testing.T) {
+	details := *boshDetails
+	details.Plans = bosh.PlanConfigs{
+		"plan-1": bosh.PlanConfig{Peers: 1, Consensus: &bosh.ConsensusProperties{Plugin: bosh.ConsensusNoops}},
+		"plan-2": bosh.PlanConfig{Peers: 7, Consensus: &bosh.ConsensusProperties{PBFT: &bosh.PBFTSettings{BatchSize: 500, BatchTimeout: "1s"}}},
+	}
+
+	context := manifestContextWithDetails(deploymentName, networkName, false, &details)
+	manifest, err := bosh.NewManifest(context, nil)
+	Equal(t, err, nil)
+	Equal(t, manifest.InstanceGroup("peer").Instances, uint(1))
+	Equal(t, manifest.JobProperties("peer", "peer").Peer.Consensus, bosh.ConsensusProperties{Plugin: bosh.ConsensusNoops})
+	Equal(t, strings.Contains(manifestYaml(t, manifest), "consensus:\n          plugin: noops\n"), true)
+
+	context.PlanId = "plan-2"
+	manifest, err = bosh.NewManifest(context, nil)
+	Equal(t, err, nil)
+	Equal(t, manifest.InstanceGroup("peer").Instances, uint(7))
+	Equal(t, *manifest.JobProperties("peer", "peer").Peer.Consensus.PBFT, bosh.PBFTSettings{N: 7, F: 2, BatchSize: 500, BatchTimeout: "1s"})
+}
+
+func TestNewManifest_ConsensusParameters(t *testing.T) {
+	details := *boshDetails
+	details.Plans = bosh.PlanConfigs{
+		"plan-1": bosh.PlanConfig{Consensus: &bosh.ConsensusProperties{PBFT: &bosh.PBFTSettings{BatchSize: 500}}},
+	}
+	context := manifestContextWithDetails(deploymentName, networkName, false, &details)
+
+	// Parameters override plan settings
+	context.Parameters = map[string]interface{}{
+		"consensus": map[string]interface{}{"pbft": map[string]interface{}{"batch_size": float64(100), "f": float64(1)}},
+	}
+	manifest, err := bosh.NewManifest(context, nil)
+	Equal(t, err, nil)
+	Equal(t, *manifest.JobProperties("peer", "peer").Peer.Consensus.PBFT, bosh.PBFTSettings{N: 4, F: 1, BatchSize: 100})
+
+	// Switching to noops drops pbft settings of plan
+	context.Parameters = map[string]interface{}{"consensus": map[string]interface{}{"plugin": "noops"}}
+	manifest, err = bosh.NewManifest(context, nil)
+	Equal(t, err, nil)
+	Equal(t, manifest.JobProperties("peer", "peer").Peer.Consensus, bosh.ConsensusProperties{Plugin: bosh.ConsensusNoops})
+
+	context.Parameters = map[string]interface{}{
+		"consensus": map[string]interface{}{"pbft": map[string]interface{}{"f": float64(2)}},
+	}
+	_, err = bosh.NewManifest(context, nil)
+	NotEqual(t, err, nil)
+	Equal(t, bosh.IsParameterError(err), true)
+	Equal(t, err.Error(), "Invalid parameter consensus: pbft needs N >= 3f+1 but N is 4 and f is 2")
+
+	context.Parameters = map[string]interface{}{"consensus": "pbft"}
+	_, err = bosh.NewManifest(context, nil)
+	Equal(t, bosh.IsParameterError(err), true)
+}
+
+func TestNewManifest_InvalidPlanConsensus(t *testing.T) {
+	details := *boshDetails
+	details.Plans = bosh.PlanConfigs{
+		"plan-1": bosh.PlanConfig{Consensus: &bosh.ConsensusProperties{PBFT: &bosh.PBFTSettings{N: 7}}},
+	}
+
+	_, err := bosh.NewManifest(manifestContextWithDetails(deploymentName, networkName, false, &details), nil)
+	NotEqual(t, err, nil)
+	Equal(t, bosh.IsParameterError(err), false)
+}
@@ -3,9 +3,15 @@ package bosh
 import (
 	"errors"
 	"strings"
+
+	"github.com/predix/fabric-service-broker/credhub"
 )
 
+// Name of director used when only a single director is configured
+const DefaultDirectorName = "default"
+
 type Details struct {
+	Name            string
 	StemcellName    string
 	DirectorUUID    string
 	NetworkNames    []string
@@ -14,9 +20,77 @@ type Details struct {
 
 	PeerDataDir   string
 	DockerDataDir string
+
+	// Config server of the director. Secrets are embedded in manifests if
+	// not set.
+	Credhub credhub.Details
+
+	Plans PlanConfigs
+	// Templates replacing built-in manifests for plans
+	ManifestTemplates ManifestTemplates
+	// Operations applied to manifests of all plans
+	Ops []Operation
+	// AZs of all instance groups. Manifests keep their AZs if not set.
+	AZs []string
+	// Render manifests in the deprecated schema with jobs and templates
+	// instead of instance groups
+	LegacyManifest bool
+	// Enable use_dns_addresses feature in built-in manifests
+	UseDNSAddresses bool
+
+	// Maximum number of service instances deployed by this director. 0 means
+	// limited by number of networks only.
+	MaxInstances int
+	// Labels matched against director labels of a plan for placement
+	Labels []string
+
+	// Maximum number of BOSH tasks for deployments of this broker that can
+	// be in flight at a time. Provisions beyond that are queued. 0 means
+	// no limit.
+	MaxInFlightTasks int
+}
+
+// PlanConfig returns settings of given plan or empty settings if the plan
+// has not been configured
+func (b *Details) PlanConfig(planId string) PlanConfig {
+	return b.Plans[planId]
+}
+
+// UseConfigServer returns true if secrets are generated by config server
+// of the director
+func (b *Details) UseConfigServer() bool {
+	return b.Credhub.Url != ""
+}
+
+// HasLabels returns true if the director has all given labels
+func (b *Details) HasLabels(labels []string) bool {
+	for _, label := range labels {
+		found := false
+		for _, directorLabel := range b.Labels {
+			if directorLabel == label {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Capacity returns the number of service instances the director can hold
+func (b *Details) Capacity() int {
+	if b.MaxInstances > 0 && b.MaxInstances < len(b.NetworkNames) {
+		return b.MaxInstances
+	}
+	return len(b.NetworkNames)
 }
 
 func (b *Details) Validate() error {
+	if b.Name == "" {
+		return errors.New("Name cannot be empty")
+	}
 	if b.StemcellName == "" {
 		return errors.New("StemcellName cannot be empty")
 	}
@@ -40,11 +114,21 @@ func (b *Details) Validate() error {
 	if b.DockerDataDir == "" {
 		return errors.New("DockerDataDir cannot be empty")
 	}
+	if b.UseConfigServer() && (b.Credhub.UaaUrl == "" || b.Credhub.ClientId == "") {
+		return errors.New("Credhub UaaUrl and ClientId must be set along with Url")
+	}
+	if b.MaxInstances < 0 {
+		return errors.New("MaxInstances cannot be negative")
+	}
+	if b.MaxInFlightTasks < 0 {
+		return errors.New("MaxInFlightTasks cannot be negative")
+	}
 	return nil
 }
 
 func NewDetails(stemcellName, uuid, vmType, networkNames, boshDirectorUrl, peerDataDir, dockerDataDir string) *Details {
 	return &Details{
+		Name:            DefaultDirectorName,
 		StemcellName:    stemcellName,
 		DirectorUUID:    uuid,
 		Vmtype:          vmType,
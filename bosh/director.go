@@ -0,0 +1,135 @@
+package bosh
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/predix/fabric-service-broker/credhub"
+	"gopkg.in/yaml.v2"
+)
+
+// Director groups settings of a BOSH director with the client for it
+type Director struct {
+	Details *Details
+	Client  Client
+	// Nil if the director does not use a config server
+	Credhub credhub.Client
+}
+
+// DirectorConfig holds operator provided settings of one of the directors
+// the broker deploys to. Settings that are not given are taken from the
+// command line flags.
+type DirectorConfig struct {
+	Name         string   `yaml:"name"`
+	Url          string   `yaml:"url"`
+	UUID         string   `yaml:"uuid"`
+	NetworkNames []string `yaml:"networks"`
+	StemcellName string   `yaml:"stemcell"`
+	VmType       string   `yaml:"vm_type"`
+	MaxInstances int      `yaml:"max_instances"`
+	Labels       []string `yaml:"labels"`
+	AZs          []string `yaml:"azs"`
+
+	LegacyManifest bool `yaml:"legacy_manifest"`
+
+	CredhubUrl          string `yaml:"credhub_url"`
+	CredhubUaaUrl       string `yaml:"credhub_uaa_url"`
+	CredhubClientId     string `yaml:"credhub_client_id"`
+	CredhubClientSecret string `yaml:"credhub_client_secret"`
+	// PEM encoded, the CA certificate given on command line is used if empty
+	CredhubCACert string `yaml:"credhub_ca_cert"`
+}
+
+// DirectorsConfig lists the directors in order of preference along with
+// name of the strategy used to place new instances on them. The first
+// director also holds instances created before multiple directors were
+// configured.
+type DirectorsConfig struct {
+	Placement string           `yaml:"placement"`
+	Directors []DirectorConfig `yaml:"directors"`
+}
+
+// LoadDirectorsConfig reads directors from YAML file
+func LoadDirectorsConfig(path string) (*DirectorsConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Error("Error reading directors config file", err)
+		return nil, err
+	}
+
+	directorsConfig := DirectorsConfig{}
+	err = yaml.Unmarshal(data, &directorsConfig)
+	if err != nil {
+		log.Error("Error unmarshalling directors config file", err)
+		return nil, err
+	}
+
+	err = directorsConfig.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return &directorsConfig, nil
+}
+
+func (c *DirectorsConfig) Validate() error {
+	if len(c.Directors) == 0 {
+		return errors.New("At least one director must be configured")
+	}
+
+	names := make(map[string]struct{})
+	for _, director := range c.Directors {
+		if director.Name == "" {
+			return errors.New("Director name cannot be empty")
+		}
+		if _, found := names[director.Name]; found {
+			return errors.New(fmt.Sprintf("Director %s configured more than once", director.Name))
+		}
+		names[director.Name] = struct{}{}
+	}
+	return nil
+}
+
+// Details returns settings of the director using base for settings that
+// are not configured for the director
+func (d DirectorConfig) Details(base *Details) *Details {
+	details := *base
+	details.Name = d.Name
+	details.MaxInstances = d.MaxInstances
+	details.Labels = d.Labels
+	if d.Url != "" {
+		details.BoshDirectorUrl = d.Url
+	}
+	if d.UUID != "" {
+		details.DirectorUUID = d.UUID
+	}
+	if len(d.NetworkNames) > 0 {
+		details.NetworkNames = d.NetworkNames
+	}
+	if d.StemcellName != "" {
+		details.StemcellName = d.StemcellName
+	}
+	if d.VmType != "" {
+		details.Vmtype = d.VmType
+	}
+	if len(d.AZs) > 0 {
+		details.AZs = d.AZs
+	}
+	if d.LegacyManifest {
+		details.LegacyManifest = true
+	}
+	if d.CredhubUrl != "" {
+		details.Credhub = credhub.Details{
+			Url:                 d.CredhubUrl,
+			UaaUrl:              d.CredhubUaaUrl,
+			ClientId:            d.CredhubClientId,
+			ClientSecret:        d.CredhubClientSecret,
+			SkipTLSVerification: base.Credhub.SkipTLSVerification,
+			CACert:              base.Credhub.CACert,
+		}
+		if d.CredhubCACert != "" {
+			details.Credhub.CACert = d.CredhubCACert
+		}
+	}
+	return &details
+}
@@ -0,0 +1,133 @@
+package bosh
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	directorErrorCodeInternal           = 100
+	directorErrorCodeDeploymentLocked   = 100001
+	directorErrorCodeDeploymentNotFound = 70000
+	directorErrorCodeTaskNotFound       = 10001
+)
+
+// DirectorError is an error response returned by BOSH director
+type DirectorError struct {
+	StatusCode  int    `json:"-"`
+	Code        int    `json:"code"`
+	Description string `json:"description"`
+}
+
+func (e *DirectorError) Error() string {
+	return fmt.Sprintf("BOSH director responded with status %d, error code %d: %s", e.StatusCode, e.Code, e.Description)
+}
+
+// IsLockError returns true if the request was rejected because another task
+// holds the lock on the deployment. Directors that report the lock without a
+// specific error code are recognized by the description.
+func (e *DirectorError) IsLockError() bool {
+	if e.StatusCode == http.StatusConflict || e.Code == directorErrorCodeDeploymentLocked {
+		return true
+	}
+	if e.Code != 0 && e.Code != directorErrorCodeInternal {
+		return false
+	}
+	description := strings.ToLower(e.Description)
+	return strings.Contains(description, "failed to acquire lock") ||
+		strings.Contains(description, "is locked") ||
+		strings.Contains(description, "lock:deployment")
+}
+
+// IsNotFound returns true if the requested deployment or task does not exist
+func (e *DirectorError) IsNotFound() bool {
+	return e.StatusCode == http.StatusNotFound ||
+		e.Code == directorErrorCodeDeploymentNotFound ||
+		e.Code == directorErrorCodeTaskNotFound
+}
+
+// ParameterError is returned for invalid parameters given on provision
+type ParameterError struct {
+	Parameter string
+	Message   string
+}
+
+func (e *ParameterError) Error() string {
+	return fmt.Sprintf("Invalid parameter %s: %s", e.Parameter, e.Message)
+}
+
+// IsParameterError returns true if err is caused by invalid provision
+// parameters
+func IsParameterError(err error) bool {
+	_, ok := err.(*ParameterError)
+	return ok
+}
+
+// ManifestError is returned for manifests that BOSH director would reject,
+// e.g. because a template or ops file of the plan is broken
+type ManifestError struct {
+	Message string
+}
+
+func (e *ManifestError) Error() string {
+	return e.Message
+}
+
+// IsManifestError returns true if err is caused by an invalid manifest
+func IsManifestError(err error) bool {
+	_, ok := err.(*ManifestError)
+	return ok
+}
+
+// TaskTimeoutError is returned if a BOSH task did not finish in time
+type TaskTimeoutError struct {
+	TaskId string
+	State  TaskState
+}
+
+func (e *TaskTimeoutError) Error() string {
+	return fmt.Sprintf("Timed out waiting for task:%s, last state %s", e.TaskId, e.State)
+}
+
+// IsTaskTimeoutError returns true if err is caused by a BOSH task not
+// finishing in time
+func IsTaskTimeoutError(err error) bool {
+	_, ok := err.(*TaskTimeoutError)
+	return ok
+}
+
+// newDirectorError parses error response of BOSH director. Responses that
+// are not in the JSON format of director are kept as description.
+func newDirectorError(resp *http.Response) *DirectorError {
+	directorError := &DirectorError{StatusCode: resp.StatusCode}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Error("Error reading from response", err)
+		return directorError
+	}
+
+	err = json.Unmarshal(data, directorError)
+	if err != nil || directorError.Description == "" {
+		directorError.Description = strings.TrimSpace(string(data))
+	}
+	log.Errorf("Error response from BOSH: %s", directorError)
+	return directorError
+}
+
+// IsLockError returns true if err is an error from BOSH director about the
+// deployment being locked by another task
+func IsLockError(err error) bool {
+	directorError, ok := err.(*DirectorError)
+	return ok && directorError.IsLockError()
+}
+
+// IsNotFoundError returns true if err is an error from BOSH director about
+// the requested resource not existing
+func IsNotFoundError(err error) bool {
+	directorError, ok := err.(*DirectorError)
+	return ok && directorError.IsNotFound()
+}
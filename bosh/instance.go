@@ -0,0 +1,107 @@
+package bosh
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const (
+	ProcessStateRunning      = "running"
+	ProcessStateFailing      = "failing"
+	ProcessStateStopped      = "stopped"
+	ProcessStateUnresponsive = "unresponsive agent"
+)
+
+// JobState is the desired state of jobs requested through
+// /deployments/:name/jobs/:job. Detached is a hard stop which also deletes
+// the VMs while keeping their persistent disks.
+type JobState string
+
+const (
+	JobStateStarted  JobState = "started"
+	JobStateStopped  JobState = "stopped"
+	JobStateDetached JobState = "detached"
+	JobStateRestart  JobState = "restart"
+	JobStateRecreate JobState = "recreate"
+)
+
+type ProcessDetails struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+// InstanceDetails describes a single VM of a deployment as returned by
+// /deployments/:name/vms?format=full
+type InstanceDetails struct {
+	JobName            string           `json:"job_name"`
+	Index              *int             `json:"index"`
+	Id                 string           `json:"id"`
+	AZ                 string           `json:"az"`
+	IPs                []string         `json:"ips"`
+	DNS                []string         `json:"dns"`
+	VmCid              string           `json:"vm_cid"`
+	DiskCid            string           `json:"disk_cid"`
+	AgentId            string           `json:"agent_id"`
+	ProcessState       string           `json:"job_state"`
+	Processes          []ProcessDetails `json:"processes"`
+	ResurrectionPaused bool             `json:"resurrection_paused"`
+	Bootstrap          bool             `json:"bootstrap"`
+}
+
+// IP returns the first IP of the instance or empty string if instance has
+// no IPs
+func (i InstanceDetails) IP() string {
+	if len(i.IPs) == 0 {
+		return ""
+	}
+	return i.IPs[0]
+}
+
+type Instances []InstanceDetails
+
+// ByJob returns instances of given job
+func (instances Instances) ByJob(jobName string) Instances {
+	jobInstances := Instances{}
+	for _, instance := range instances {
+		if instance.JobName == jobName {
+			jobInstances = append(jobInstances, instance)
+		}
+	}
+	return jobInstances
+}
+
+// IPs returns first IP of every instance that has one
+func (instances Instances) IPs() []string {
+	ips := []string{}
+	for _, instance := range instances {
+		if instance.IP() != "" {
+			ips = append(ips, instance.IP())
+		}
+	}
+	return ips
+}
+
+// ParseInstances parses newline separated JSON VM details. VMs which are not
+// associated with any job (e.g. unresponsive agents) and lines that cannot be
+// parsed are skipped.
+func ParseInstances(data []byte) Instances {
+	instances := Instances{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		instance := InstanceDetails{}
+		err := json.Unmarshal([]byte(line), &instance)
+		if err != nil {
+			log.Error("Error in unmarshaling vm details", err)
+			continue
+		}
+		if instance.JobName == "" {
+			log.Errorf("Skipping VM %s without job name", instance.VmCid)
+			continue
+		}
+		log.Debugf("Parsed instance %s/%s with IPs %s", instance.JobName, instance.Id, instance.IPs)
+		instances = append(instances, instance)
+	}
+	return instances
+}
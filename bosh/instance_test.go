@@ -0,0 +1,41 @@
+package bosh_test
+
+import (
+	"testing"
+
+	"github.com/predix/fabric-service-broker/bosh"
+
+	. "gopkg.in/go-playground/assert.v1"
+)
+
+const vmsOutput = `
+{"vm_cid":"vm-1","disk_cid":"disk-1","ips":["10.0.0.2","10.0.1.2"],"dns":["0.peer.peer.fabric-x.bosh"],"agent_id":"agent-1","job_name":"peer","index":0,"job_state":"running","resurrection_paused":false,"az":"z1","id":"id-1","bootstrap":true,"processes":[{"name":"peer","state":"running"}]}
+{"vm_cid":"vm-2","ips":["10.0.0.3"],"job_name":"peer","index":1,"job_state":"failing","az":"z2","id":"id-2","resurrection_paused":true}
+{"vm_cid":"vm-3","ips":[],"job_name":"peer","index":2,"job_state":"running","az":"z1","id":"id-3"}
+{"vm_cid":"vm-4","ips":["10.0.0.5"],"job_name":null,"index":null,"job_state":"unresponsive agent"}
+{"vm_cid":"vm-5","ips":["10.0.0.6"],"job_name":"membersrvc","index":0,"job_state":"running","az":"z1","id":"id-5"}
+{not json
+`
+
+func TestParseInstances(t *testing.T) {
+	instances := bosh.ParseInstances([]byte(vmsOutput))
+	Equal(t, len(instances), 4)
+
+	first := instances[0]
+	Equal(t, first.JobName, "peer")
+	Equal(t, *first.Index, 0)
+	Equal(t, first.Id, "id-1")
+	Equal(t, first.AZ, "z1")
+	Equal(t, first.IPs, []string{"10.0.0.2", "10.0.1.2"})
+	Equal(t, first.DNS, []string{"0.peer.peer.fabric-x.bosh"})
+	Equal(t, first.VmCid, "vm-1")
+	Equal(t, first.ProcessState, bosh.ProcessStateRunning)
+	Equal(t, first.Processes[0].Name, "peer")
+	Equal(t, instances[1].ResurrectionPaused, true)
+
+	peers := instances.ByJob("peer")
+	Equal(t, len(peers), 3)
+	Equal(t, peers.IPs(), []string{"10.0.0.2", "10.0.0.3"})
+	Equal(t, len(instances.ByJob("membersrvc")), 1)
+	Equal(t, len(instances.ByJob("unknown")), 0)
+}
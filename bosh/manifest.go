@@ -1,11 +1,19 @@
 package bosh
 
 import (
+	"errors"
+	"fmt"
+	"sort"
 	"strings"
 
 	"gopkg.in/yaml.v2"
 )
 
+const (
+	peerJobName   = "peer"
+	dockerJobName = "docker"
+)
+
 const permissionlessManifest = `
 ---
 name: GIVE-ME-A-NAME
@@ -23,31 +31,32 @@ update:
   max_in_flight: 3
   serial: false
   update_watch_time: 5000-120000
-jobs:
-- instances: 4
+instance_groups:
+- name: peer
+  instances: 4
   azs: [z1, z2]
-  name: peer
   networks:
   - name: peer
   persistent_disk: 10000
   vm_type: small
   stemcell: default
-  templates:
+  jobs:
   - name: peer
     release: fabric-release
+    properties:
+      peer:
+        network:
+          id: GENERATED
+        consensus:
+          plugin: pbft
+        core:
+          data_path: /var/vcap/store/hyperledger/production
   - name: docker
     release: fabric-release
-properties:
-  peer:
-    network:
-      id: GENERATED
-    consensus:
-      plugin: pbft
-    core:
-      data_path: /var/vcap/store/hyperledger/production
-  docker:
-    store:
-      dir: /var/vcap/store/docker
+    properties:
+      docker:
+        store:
+          dir: /var/vcap/store/docker
 `
 
 const permissionedManifest = `
@@ -67,119 +76,179 @@ update:
   max_in_flight: 3
   serial: false
   update_watch_time: 5000-120000
-jobs:
-- instances: 1
+instance_groups:
+- name: membersrvc
+  instances: 1
   azs: [z1, z2]
-  name: membersrvc
   networks:
   - name: peer
   persistent_disk: 10000
   vm_type: small
   stemcell: default
-  templates:
+  jobs:
   - name: member_service
     release: fabric-release
-- instances: 4
+    provides:
+      membersrvc: {as: membersrvc}
+    properties:
+        membersrvc: &membersrvc
+          affiliations:
+            banks_and_institutions:
+              banks:
+                  - bank_a
+                  - bank_b
+                  - bank_c
+              institutions:
+                  - institution_a
+                  - institution_b
+          clients:
+          - name: admin
+            secret: ((membersrvc_admin_secret))
+            affiliation: institution_a 00001
+            affiliation_role:
+            metadata: '''{"registrar":{"roles":["client","peer","validator","auditor"],"delegateRoles":["client"]}}'''
+          - name: WebAppAdmin
+            secret: ((membersrvc_WebAppAdmin_secret))
+            affiliation: institution_a 00002
+            affiliation_role:
+            metadata: '''{"registrar":{"roles":["client"]}}'''
+          - name: lukas
+            secret: ((membersrvc_lukas_secret))
+            affiliation: bank_a
+            affiliation_role: 00001
+            metadata:
+          - name: system_chaincode_invoker
+            secret: ((membersrvc_system_chaincode_invoker_secret))
+            affiliation: institution_a
+            affiliation_role: 00002
+            metadata:
+          - name: diego
+            secret: ((membersrvc_diego_secret))
+            affiliation: institution_a
+            affiliation_role: 00003
+            metadata:
+          - name: binhn
+            secret: ((membersrvc_binhn_secret))
+            affiliation: institution_a
+            affiliation_role: 00005
+            metadata:
+          - name: jim
+            secret: ((membersrvc_jim_secret))
+            affiliation: bank_a
+            affiliation_role: 00004
+            metadata:
+          validators:
+          - name: vp0
+            secret: ((membersrvc_vp0_secret))
+          - name: vp1
+            secret: ((membersrvc_vp1_secret))
+          - name: vp2
+            secret: ((membersrvc_vp2_secret))
+          - name: vp3
+            secret: ((membersrvc_vp3_secret))
+          non_validators:
+          - name: nvp0
+            secret: ((membersrvc_nvp0_secret))
+            affiliation: bank_a
+            affiliation_role: 00006
+          - name: nvp1
+            secret: ((membersrvc_nvp1_secret))
+            affiliation: institution_a
+            affiliation_role: 00007
+          auditors:
+- name: peer
+  instances: 4
   azs: [z1, z2]
-  name: peer
   networks:
   - name: peer
   persistent_disk: 10000
   vm_type: small
   stemcell: default
-  templates:
+  jobs:
   - name: peer
     release: fabric-release
+    consumes:
+      membersrvc: {from: membersrvc}
+    properties:
+      # Peers enroll with the users of the membership service, which they
+      # read from their own properties
+      membersrvc: *membersrvc
+      peer:
+        network:
+          id: GENERATED
+        consensus:
+          plugin: pbft
+        security:
+          enabled: true
+        core:
+          data_path: /var/vcap/store/hyperledger/production
   - name: docker
     release: fabric-release
-properties:
-  peer:
-    network:
-      id: GENERATED
-    consensus:
-      plugin: pbft
-    security:
-      enabled: true
-    core:
-      data_path: /var/vcap/store/hyperledger/production
-  docker:
-    store:
-      dir: /var/vcap/store/docker
-  membersrvc:
-    affiliations:
-      banks_and_institutions:
-        banks:
-            - bank_a
-            - bank_b
-            - bank_c
-        institutions:
-            - institution_a
-            - institution_b
-    clients:
-    - name: admin
-      secret: Xurw3yU9zI0l
-      affiliation: institution_a 00001
-      affiliation_role:
-      metadata: '''{"registrar":{"roles":["client","peer","validator","auditor"],"delegateRoles":["client"]}}'''
-    - name: WebAppAdmin
-      secret: DJY27pEnl16d
-      affiliation: institution_a 00002
-      affiliation_role:
-      metadata: '''{"registrar":{"roles":["client"]}}'''
-    - name: lukas
-      secret: NPKYL39uKbkj
-      affiliation: bank_a
-      affiliation_role: 00001
-      metadata:
-    - name: system_chaincode_invoker
-      secret: DRJ20pEql15a
-      affiliation: institution_a
-      affiliation_role: 00002
-      metadata:
-    - name: diego
-      secret: DRJ23pEQl16a
-      affiliation: institution_a
-      affiliation_role: 00003
-      metadata:
-    - name: binhn
-      secret: 7avZQLwcUe9q
-      affiliation: institution_a
-      affiliation_role: 00005
-      metadata:
-    - name: jim
-      secret: 6avZQLwcUe9b
-      affiliation: bank_a
-      affiliation_role: 00004
-      metadata:
-    validators:
-    - name: vp0
-      secret: f3489fy98ghf
-    - name: vp1
-      secret: MwYpmSRjupbT
-    - name: vp2
-      secret: 5wgHK9qqYaPy
-    - name: vp3
-      secret: vQelbRvja7cJ
-    non_validators:
-    - name: nvp0
-      secret: iywrPBDEPl0K
-      affiliation: bank_a
-      affiliation_role: 00006
-    - name: nvp1
-      secret: DcYXuRSocuqd
-      affiliation: institution_a
-      affiliation_role: 00007
-    auditors:
+    properties:
+      docker:
+        store:
+          dir: /var/vcap/store/docker
+variables:
+- name: membersrvc_admin_secret
+  type: password
+- name: membersrvc_WebAppAdmin_secret
+  type: password
+- name: membersrvc_lukas_secret
+  type: password
+- name: membersrvc_system_chaincode_invoker_secret
+  type: password
+- name: membersrvc_diego_secret
+  type: password
+- name: membersrvc_binhn_secret
+  type: password
+- name: membersrvc_jim_secret
+  type: password
+- name: membersrvc_vp0_secret
+  type: password
+- name: membersrvc_vp1_secret
+  type: password
+- name: membersrvc_vp2_secret
+  type: password
+- name: membersrvc_vp3_secret
+  type: password
+- name: membersrvc_nvp0_secret
+  type: password
+- name: membersrvc_nvp1_secret
+  type: password
 `
 
+// Manifest is a BOSH deployment manifest in the v2 schema with instance
+// groups. String renders it in the legacy schema with jobs and templates if
+// the director is configured to use it.
 type Manifest struct {
-	Name         string     `yaml:"name"`
-	DirectorUuid string     `yaml:"director_uuid"`
-	Stemcells    Stemcells  `yaml:"stemcells"`
-	Releases     Releases   `yaml:"releases"`
-	Update       Update     `yaml:"update"`
-	Jobs         Jobs       `yaml:"jobs"`
-	Properties   Properties `yaml:"properties"`
+	Name           string            `yaml:"name"`
+	DirectorUuid   string            `yaml:"director_uuid"`
+	Features       Features          `yaml:"features,omitempty"`
+	Stemcells      Stemcells         `yaml:"stemcells"`
+	Releases       Releases          `yaml:"releases"`
+	Update         Update            `yaml:"update"`
+	InstanceGroups InstanceGroups    `yaml:"instance_groups"`
+	Addons         Addons            `yaml:"addons,omitempty"`
+	Variables      Variables         `yaml:"variables,omitempty"`
+	Tags           map[string]string `yaml:"tags,omitempty"`
+	// Global properties, only used by jobs without properties of their own
+	Properties *Properties `yaml:"properties,omitempty"`
+
+	// Render in legacy schema
+	legacy bool
+	// AZs were given in provision parameters
+	azsFromParameters bool
+	// AZs were given in plan config or provision parameters
+	azsConfigured bool
+	// Applied to the YAML document by String
+	ops []Operation
+	// Manifest as sent to the director, parsed after ops are applied. Nil
+	// if there are no ops.
+	withOps *Manifest
+}
+
+type Features struct {
+	UseDNSAddresses bool `yaml:"use_dns_addresses,omitempty"`
 }
 
 type Stemcells []Stemcell
@@ -205,17 +274,59 @@ type Update struct {
 	UpdateWatchTime string `yaml:"update_watch_time"`
 }
 
-type Jobs []Job
+// Variables are generated by config server of the director and referenced
+// in the manifest as ((name))
+type Variables []Variable
 
-type Job struct {
+type Variable struct {
+	Name    string                 `yaml:"name"`
+	Type    string                 `yaml:"type"`
+	Options map[string]interface{} `yaml:"options,omitempty"`
+}
+
+type InstanceGroups []InstanceGroup
+
+type InstanceGroup struct {
+	Name           string              `yaml:"name"`
 	Instances      uint                `yaml:"instances"`
 	AZs            []string            `yaml:"azs"`
-	Name           string              `yaml:"name"`
 	Networks       []map[string]string `yaml:"networks"`
 	PersistentDisk uint                `yaml:"persistent_disk"`
 	VmType         string              `yaml:"vm_type"`
 	Stemcell       string              `yaml:"stemcell"`
-	Templates      []map[string]string `yaml:"templates"`
+	Jobs           Jobs                `yaml:"jobs"`
+}
+
+type Jobs []Job
+
+// Job is a job of a release colocated on the VMs of an instance group
+type Job struct {
+	Name     string          `yaml:"name"`
+	Release  string          `yaml:"release"`
+	Consumes map[string]Link `yaml:"consumes,omitempty"`
+	Provides map[string]Link `yaml:"provides,omitempty"`
+	// Properties of the job. Global properties are used if not set.
+	Properties *Properties `yaml:"properties,omitempty"`
+}
+
+// Link configures a link provided or consumed by a job, e.g. peers consume
+// the link provided by membership service to find its address
+type Link struct {
+	As         string `yaml:"as,omitempty"`
+	From       string `yaml:"from,omitempty"`
+	Deployment string `yaml:"deployment,omitempty"`
+	Shared     bool   `yaml:"shared,omitempty"`
+}
+
+type Addons []Addon
+
+// Addon colocates jobs on VMs of the instance groups selected by include
+// and exclude rules
+type Addon struct {
+	Name    string                 `yaml:"name"`
+	Jobs    Jobs                   `yaml:"jobs"`
+	Include map[string]interface{} `yaml:"include,omitempty"`
+	Exclude map[string]interface{} `yaml:"exclude,omitempty"`
 }
 
 type SecuritySettings struct {
@@ -227,10 +338,12 @@ type CoreSettings struct {
 }
 
 type PeerProperties struct {
-	Network   map[string]string `yaml:"network"`
-	Consensus map[string]string `yaml:"consensus"`
-	Security  SecuritySettings  `yaml:"security,omitempty"`
-	Core      CoreSettings      `yaml:"core,omitempty"`
+	Network   map[string]string   `yaml:"network"`
+	Consensus ConsensusProperties `yaml:"consensus"`
+	Security  SecuritySettings    `yaml:"security,omitempty"`
+	Core      CoreSettings        `yaml:"core,omitempty"`
+
+	PeerSettings `yaml:",inline"`
 }
 
 type BlockchainUser struct {
@@ -254,20 +367,143 @@ type StoreSettings struct {
 }
 
 type DockerProperties struct {
-	Store StoreSettings `yaml:"store"`
+	Store           StoreSettings `yaml:"store"`
+	RegistryMirrors []string      `yaml:"registry_mirrors,omitempty"`
 }
 
 type Properties struct {
-	Peer          PeerProperties          `yaml:"peer"`
+	Peer          PeerProperties          `yaml:"peer,omitempty"`
 	Docker        DockerProperties        `yaml:"docker,omitempty"`
 	MemberService MemberServiceProperties `yaml:"membersrvc,omitempty"`
+	// Properties of other jobs, e.g. of addons
+	Other map[string]interface{} `yaml:",inline"`
+}
+
+// NewManifest generates manifest for a deployment. The manifest is rendered
+// from the template of the plan if there is one and from the built-in
+// manifest otherwise. If the director uses a config server, secrets of
+// membership service users are variables generated by the config server.
+// Otherwise users get the secret given in secrets and users without one get
+// a newly generated random secret. Manifest.Secrets returns the secrets used.
+func NewManifest(context ManifestContext, secrets Secrets) (*Manifest, error) {
+	details := context.Details
+
+	var manifest *Manifest
+	var err error
+	tmpl, found := details.ManifestTemplates[context.PlanId]
+	if found {
+		manifest, err = renderManifest(tmpl, context)
+	} else {
+		manifest, err = builtinManifest(context)
+		unused := unusedParameters(context.Parameters)
+		if len(unused) > 0 {
+			log.Warningf("Parameters %s are not used by the built-in manifest of plan %s", strings.Join(unused, ", "), context.PlanId)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range context.Tags {
+		if manifest.Tags == nil {
+			manifest.Tags = make(map[string]string)
+		}
+		manifest.Tags[name] = value
+	}
+
+	planConfig := details.PlanConfig(context.PlanId)
+	manifest.legacy = details.LegacyManifest
+	manifest.ops = append(append([]Operation{}, details.Ops...), planConfig.Ops...)
+	err = manifest.configureAZs(details, planConfig, context.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	err = manifest.configureConsensus(planConfig.Consensus, context.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	err = manifest.configurePeers(planConfig, context.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	if details.UseConfigServer() {
+		log.Debugf("Secrets of deployment %s are generated by config server", context.DeploymentName)
+	} else {
+		manifest.Variables = nil
+		// Jobs sharing a user get the same secret
+		generated := Secrets{}
+		for name, secret := range secrets {
+			generated[name] = secret
+		}
+		secrets = generated
+		for _, memberService := range manifest.memberServiceProperties() {
+			err = memberService.setSecrets(secrets)
+			if err != nil {
+				log.Error("Error generating secrets", err)
+				return nil, err
+			}
+		}
+	}
+
+	err = manifest.Validate()
+	if err != nil {
+		return nil, &ManifestError{Message: err.Error()}
+	}
+
+	err = manifest.validateWithOps()
+	if err != nil {
+		return nil, &ManifestError{Message: err.Error()}
+	}
+	manifest.setConsensusDefaults()
+	err = manifest.checkSecrets(details.UseConfigServer())
+	if err != nil {
+		return nil, &ManifestError{Message: err.Error()}
+	}
+	err = manifest.CheckAZFaultTolerance()
+	if err != nil {
+		if !planConfig.RequireAZFaultTolerance {
+			// Default AZs of built-in manifests never tolerate an outage,
+			// only warn about AZs chosen for the plan or instance
+			if manifest.azsConfigured {
+				log.Warningf("Deployment %s does not tolerate outage of an AZ, %s", context.DeploymentName, err)
+			}
+		} else if manifest.hasParameterAZs(peerJobName) {
+			return nil, &ParameterError{Parameter: azsParameter, Message: err.Error()}
+		} else {
+			return nil, err
+		}
+	}
+
+	return manifest, nil
 }
 
-func NewManifest(deploymentName, networkName string, permissioned bool, details *Details) (*Manifest, error) {
+// Provision parameters built-in manifests are configured with. Templates
+// can use any parameter.
+var builtinParameters = []string{azsParameter, consensusParameter, peerParameter}
+
+// Returns sorted names of top-level parameters built-in manifests ignore
+func unusedParameters(parameters map[string]interface{}) []string {
+	unused := []string{}
+	for name := range parameters {
+		used := false
+		for _, builtin := range builtinParameters {
+			used = used || name == builtin
+		}
+		if !used {
+			unused = append(unused, name)
+		}
+	}
+	sort.Strings(unused)
+	return unused
+}
+
+func builtinManifest(context ManifestContext) (*Manifest, error) {
+	details := context.Details
 	manifest := Manifest{}
 
 	rawManifest := permissionlessManifest
-	if permissioned {
+	if context.Permissioned {
 		rawManifest = permissionedManifest
 	}
 
@@ -277,28 +513,356 @@ func NewManifest(deploymentName, networkName string, permissioned bool, details
 		return nil, err
 	}
 
-	manifest.Name = deploymentName
-	manifest.Properties.Peer.Network["id"] = strings.ToLower(deploymentName)
-	manifest.Jobs[0].Networks[0]["name"] = networkName
-	manifest.Jobs[0].VmType = details.Vmtype
-	if permissioned {
-		manifest.Jobs[1].Networks[0]["name"] = networkName
-		manifest.Jobs[1].VmType = details.Vmtype
-	}
+	manifest.Name = context.DeploymentName
 	manifest.DirectorUuid = details.DirectorUUID
+	manifest.Features.UseDNSAddresses = details.UseDNSAddresses
 	manifest.Stemcells[0].Name = details.StemcellName
-	manifest.Properties.Peer.Core.DataPath = details.PeerDataDir
-	manifest.Properties.Docker.Store.Dir = details.DockerDataDir
+	for i := range manifest.InstanceGroups {
+		manifest.InstanceGroups[i].Networks[0]["name"] = context.NetworkName
+		manifest.InstanceGroups[i].VmType = details.Vmtype
+	}
+	if peers := details.PlanConfig(context.PlanId).Peers; peers > 0 {
+		manifest.InstanceGroup(peerJobName).Instances = peers
+	}
 
+	peer := manifest.JobProperties(peerJobName, peerJobName)
+	peer.Peer.Network["id"] = strings.ToLower(context.DeploymentName)
+	peer.Peer.Core.DataPath = details.PeerDataDir
+	manifest.JobProperties(peerJobName, dockerJobName).Docker.Store.Dir = details.DockerDataDir
 	return &manifest, nil
 }
 
-func (m *Manifest) String() string {
-	d, err := yaml.Marshal(m)
+// InstanceGroup returns instance group with given name or nil if there is
+// none
+func (m *Manifest) InstanceGroup(name string) *InstanceGroup {
+	for i := range m.InstanceGroups {
+		if m.InstanceGroups[i].Name == name {
+			return &m.InstanceGroups[i]
+		}
+	}
+	return nil
+}
+
+// Job returns job with given name or nil if there is none
+func (g *InstanceGroup) Job(name string) *Job {
+	for i := range g.Jobs {
+		if g.Jobs[i].Name == name {
+			return &g.Jobs[i]
+		}
+	}
+	return nil
+}
+
+// JobProperties returns the properties the job of instance group is
+// deployed with, i.e. its own properties or global properties if it has
+// none. Returns nil if there is no such job or no properties.
+func (m *Manifest) JobProperties(instanceGroupName, jobName string) *Properties {
+	instanceGroup := m.InstanceGroup(instanceGroupName)
+	if instanceGroup == nil {
+		return nil
+	}
+	job := instanceGroup.Job(jobName)
+	if job == nil {
+		return nil
+	}
+	if job.Properties != nil {
+		return job.Properties
+	}
+	return m.Properties
+}
+
+// Returns properties of all jobs and global properties that configure
+// membership service users
+func (m *Manifest) memberServiceProperties() []*MemberServiceProperties {
+	candidates := []*Properties{m.Properties}
+	for i := range m.InstanceGroups {
+		for j := range m.InstanceGroups[i].Jobs {
+			candidates = append(candidates, m.InstanceGroups[i].Jobs[j].Properties)
+		}
+	}
+
+	memberServices := []*MemberServiceProperties{}
+	for _, properties := range candidates {
+		if properties == nil {
+			continue
+		}
+		for _, users := range properties.MemberService.users() {
+			if len(users) > 0 {
+				memberServices = append(memberServices, &properties.MemberService)
+				break
+			}
+		}
+	}
+	return memberServices
+}
+
+// Validate checks that the manifest has the parts required by BOSH, that
+// instance groups reference declared stemcells and releases and that
+// consensus settings fit the number of peers
+func (m *Manifest) Validate() error {
+	if m.Name == "" {
+		return errors.New("Manifest name cannot be empty")
+	}
+	if m.DirectorUuid == "" {
+		return errors.New("Manifest director_uuid cannot be empty")
+	}
+	if len(m.Stemcells) == 0 {
+		return errors.New("Manifest must have at least one stemcell")
+	}
+	if len(m.Releases) == 0 {
+		return errors.New("Manifest must have at least one release")
+	}
+	if len(m.InstanceGroups) == 0 {
+		return errors.New("Manifest must have at least one instance group")
+	}
+
+	stemcellAliases := make(map[string]struct{})
+	for _, stemcell := range m.Stemcells {
+		if stemcell.Alias == "" || stemcell.Name == "" || stemcell.Version == "" {
+			return errors.New(fmt.Sprintf("Stemcell %s must have alias, name and version", stemcell.Alias))
+		}
+		if _, found := stemcellAliases[stemcell.Alias]; found {
+			return errors.New(fmt.Sprintf("Stemcell %s defined more than once", stemcell.Alias))
+		}
+		stemcellAliases[stemcell.Alias] = struct{}{}
+	}
+	releaseNames := make(map[string]struct{})
+	for _, release := range m.Releases {
+		if release.Name == "" || release.Version == "" {
+			return errors.New(fmt.Sprintf("Release %s must have name and version", release.Name))
+		}
+		releaseNames[release.Name] = struct{}{}
+	}
+
+	instanceGroupNames := make(map[string]struct{})
+	for _, instanceGroup := range m.InstanceGroups {
+		if instanceGroup.Name == "" {
+			return errors.New("Instance group name cannot be empty")
+		}
+		if _, found := instanceGroupNames[instanceGroup.Name]; found {
+			return errors.New(fmt.Sprintf("Instance group %s defined more than once", instanceGroup.Name))
+		}
+		instanceGroupNames[instanceGroup.Name] = struct{}{}
+		if instanceGroup.Instances == 0 {
+			return errors.New(fmt.Sprintf("Instance group %s must have at least one instance", instanceGroup.Name))
+		}
+		if len(instanceGroup.Networks) == 0 {
+			return errors.New(fmt.Sprintf("Instance group %s must have at least one network", instanceGroup.Name))
+		}
+		for _, network := range instanceGroup.Networks {
+			if network["name"] == "" {
+				return errors.New(fmt.Sprintf("Network of instance group %s must have a name", instanceGroup.Name))
+			}
+		}
+		if _, found := stemcellAliases[instanceGroup.Stemcell]; !found {
+			return errors.New(fmt.Sprintf("Instance group %s uses stemcell %s which is not defined", instanceGroup.Name, instanceGroup.Stemcell))
+		}
+		if len(instanceGroup.Jobs) == 0 {
+			return errors.New(fmt.Sprintf("Instance group %s must have at least one job", instanceGroup.Name))
+		}
+		err := validateJobs(instanceGroup.Name, instanceGroup.Jobs, releaseNames)
+		if err != nil {
+			return err
+		}
+	}
+	for _, addon := range m.Addons {
+		err := validateJobs("addon "+addon.Name, addon.Jobs, releaseNames)
+		if err != nil {
+			return err
+		}
+	}
+
+	peer := m.JobProperties(peerJobName, peerJobName)
+	// With ops files peers are counted on the manifest after applying them
+	if peer != nil && peer.Peer.Consensus.Plugin != "" && len(m.ops) == 0 {
+		err := peer.Peer.Consensus.Validate(m.InstanceGroup(peerJobName).Instances)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Invalid consensus settings of peers: %s", err))
+		}
+	}
+	return nil
+}
+
+// Checks that jobs of the named group have unique names and reference
+// declared releases
+func validateJobs(group string, jobs Jobs, releaseNames map[string]struct{}) error {
+	jobNames := make(map[string]struct{})
+	for _, job := range jobs {
+		if job.Name == "" {
+			return errors.New(fmt.Sprintf("Job name in %s cannot be empty", group))
+		}
+		if _, found := jobNames[job.Name]; found {
+			return errors.New(fmt.Sprintf("Job %s defined more than once in %s", job.Name, group))
+		}
+		jobNames[job.Name] = struct{}{}
+		if _, found := releaseNames[job.Release]; !found {
+			return errors.New(fmt.Sprintf("Job %s in %s uses release %s which is not defined", job.Name, group, job.Release))
+		}
+	}
+	return nil
+}
+
+// Returns the manifest as sent to the director, i.e. with ops applied
+func (m *Manifest) deployed() *Manifest {
+	if m.withOps != nil {
+		return m.withOps
+	}
+	return m
+}
+
+// Secrets returns secrets of membership service users in the manifest as
+// sent to the director, i.e. after ops files are applied. Secrets generated
+// by config server are not included.
+func (m *Manifest) Secrets() Secrets {
+	secrets := Secrets{}
+	for _, memberService := range m.deployed().memberServiceProperties() {
+		for _, users := range memberService.users() {
+			for _, user := range users {
+				if user.Secret != "" && !IsPlaceholder(user.Secret) {
+					secrets[user.Name] = user.Secret
+				}
+			}
+		}
+	}
+	return secrets
+}
+
+// Returns an error if a membership service user of the manifest as sent to
+// the director has different secrets in different jobs, or has no secret
+// although secrets are not generated by a config server
+func (m *Manifest) checkSecrets(configServer bool) error {
+	secrets := Secrets{}
+	for _, memberService := range m.deployed().memberServiceProperties() {
+		for _, users := range memberService.users() {
+			for _, user := range users {
+				if !configServer && (user.Secret == "" || IsPlaceholder(user.Secret)) {
+					return errors.New(fmt.Sprintf("Membership service user %s has no secret after applying ops files", user.Name))
+				}
+				if secret, found := secrets[user.Name]; found && secret != user.Secret {
+					return errors.New(fmt.Sprintf("Membership service user %s has different secrets in different jobs", user.Name))
+				}
+				secrets[user.Name] = user.Secret
+			}
+		}
+	}
+	return nil
+}
+
+// MemberServiceClientNames returns names of membership service client users
+// in the manifest as sent to the director. Clients configured for several
+// jobs are returned once.
+func (m *Manifest) MemberServiceClientNames() []string {
+	names := []string{}
+	found := make(map[string]struct{})
+	for _, memberService := range m.deployed().memberServiceProperties() {
+		for _, client := range memberService.Clients {
+			if _, ok := found[client.Name]; !ok {
+				found[client.Name] = struct{}{}
+				names = append(names, client.Name)
+			}
+		}
+	}
+	return names
+}
+
+// ParseManifest parses a manifest as returned by the director, in either
+// the v2 or the legacy schema
+func ParseManifest(document string) (*Manifest, error) {
+	manifest := &Manifest{}
+	err := yaml.Unmarshal([]byte(document), manifest)
+	if err != nil {
+		return nil, err
+	}
+	if len(manifest.InstanceGroups) > 0 {
+		return manifest, nil
+	}
+
+	legacy := legacyManifest{}
+	err = yaml.Unmarshal([]byte(document), &legacy)
+	if err != nil {
+		return nil, err
+	}
+	return legacy.manifest(), nil
+}
+
+func (p *MemberServiceProperties) users() [][]BlockchainUser {
+	return [][]BlockchainUser{p.Clients, p.Validators, p.NonValidators, p.Auditors}
+}
+
+// Sets secrets of users to the ones given in secrets. Users without one get
+// a generated secret unless a secret was set in the manifest template.
+// Generated secrets are added to secrets.
+func (p *MemberServiceProperties) setSecrets(secrets Secrets) error {
+	for _, users := range p.users() {
+		for i := range users {
+			secret, found := secrets[users[i].Name]
+			if !found && !IsPlaceholder(users[i].Secret) && users[i].Secret != "" {
+				// Secret set by operator in manifest template
+				continue
+			}
+			if !found {
+				var err error
+				secret, err = GenerateSecret()
+				if err != nil {
+					return err
+				}
+				secrets[users[i].Name] = secret
+			}
+			users[i].Secret = secret
+		}
+	}
+	return nil
+}
+
+// String returns the manifest as YAML document with ops files applied
+func (m *Manifest) String() (string, error) {
+	d, err := m.yamlWithOps()
 	if err != nil {
 		log.Error("Error marshalling manifest", err)
-		return ""
+		return "", err
 	}
 
-	return string(d)
+	return d, nil
+}
+
+// Validates the manifest as it is sent to the director, after ops files are
+// applied
+func (m *Manifest) validateWithOps() error {
+	d, err := m.yamlWithOps()
+	if err != nil {
+		return err
+	}
+	if len(m.ops) == 0 {
+		return nil
+	}
+
+	rendered := &Manifest{}
+	if m.legacy {
+		legacy := legacyManifest{}
+		err = yaml.Unmarshal([]byte(d), &legacy)
+		rendered = legacy.manifest()
+	} else {
+		err = yaml.Unmarshal([]byte(d), rendered)
+	}
+	if err != nil {
+		return errors.New(fmt.Sprintf("Manifest after applying ops files cannot be parsed: %s", err))
+	}
+	err = rendered.Validate()
+	if err != nil {
+		return errors.New(fmt.Sprintf("Manifest after applying ops files is invalid: %s", err))
+	}
+	m.withOps = rendered
+	return nil
+}
+
+func (m *Manifest) yamlWithOps() (string, error) {
+	var document interface{} = m
+	if m.legacy {
+		document = m.legacyManifest()
+	}
+	d, err := yaml.Marshal(document)
+	if err != nil {
+		return "", err
+	}
+	return ApplyOps(string(d), m.ops)
 }
@@ -0,0 +1,126 @@
+package bosh
+
+import (
+	"reflect"
+)
+
+// legacyManifest is a manifest in the deprecated schema where instance
+// groups are called jobs and their release jobs templates. Properties of
+// all jobs are global.
+type legacyManifest struct {
+	Name         string      `yaml:"name"`
+	DirectorUuid string      `yaml:"director_uuid"`
+	Stemcells    Stemcells   `yaml:"stemcells"`
+	Releases     Releases    `yaml:"releases"`
+	Update       Update      `yaml:"update"`
+	Jobs         []legacyJob `yaml:"jobs"`
+	Variables    Variables   `yaml:"variables,omitempty"`
+	Properties   *Properties `yaml:"properties,omitempty"`
+}
+
+type legacyJob struct {
+	Instances      uint                `yaml:"instances"`
+	AZs            []string            `yaml:"azs"`
+	Name           string              `yaml:"name"`
+	Networks       []map[string]string `yaml:"networks"`
+	PersistentDisk uint                `yaml:"persistent_disk"`
+	VmType         string              `yaml:"vm_type"`
+	Stemcell       string              `yaml:"stemcell"`
+	Templates      []map[string]string `yaml:"templates"`
+}
+
+// Returns the manifest in legacy schema. Properties of jobs are merged into
+// global properties. Features, addons, tags and links are not supported by
+// the legacy schema and left out.
+func (m *Manifest) legacyManifest() *legacyManifest {
+	legacy := legacyManifest{
+		Name:         m.Name,
+		DirectorUuid: m.DirectorUuid,
+		Stemcells:    m.Stemcells,
+		Releases:     m.Releases,
+		Update:       m.Update,
+		Jobs:         []legacyJob{},
+		Variables:    m.Variables,
+	}
+
+	properties := Properties{}
+	properties.merge(m.Properties)
+	for _, instanceGroup := range m.InstanceGroups {
+		templates := []map[string]string{}
+		for _, job := range instanceGroup.Jobs {
+			templates = append(templates, map[string]string{"name": job.Name, "release": job.Release})
+			properties.merge(job.Properties)
+		}
+		legacy.Jobs = append(legacy.Jobs, legacyJob{
+			Instances:      instanceGroup.Instances,
+			AZs:            instanceGroup.AZs,
+			Name:           instanceGroup.Name,
+			Networks:       instanceGroup.Networks,
+			PersistentDisk: instanceGroup.PersistentDisk,
+			VmType:         instanceGroup.VmType,
+			Stemcell:       instanceGroup.Stemcell,
+			Templates:      templates,
+		})
+	}
+	if !reflect.DeepEqual(properties, Properties{}) {
+		legacy.Properties = &properties
+	}
+	return &legacy
+}
+
+// Returns manifest in v2 schema with the instance groups of the legacy
+// manifest. Properties stay global.
+func (l *legacyManifest) manifest() *Manifest {
+	manifest := Manifest{
+		Name:           l.Name,
+		DirectorUuid:   l.DirectorUuid,
+		Stemcells:      l.Stemcells,
+		Releases:       l.Releases,
+		Update:         l.Update,
+		InstanceGroups: InstanceGroups{},
+		Variables:      l.Variables,
+		Properties:     l.Properties,
+	}
+	for _, legacyJob := range l.Jobs {
+		jobs := Jobs{}
+		for _, template := range legacyJob.Templates {
+			jobs = append(jobs, Job{Name: template["name"], Release: template["release"]})
+		}
+		manifest.InstanceGroups = append(manifest.InstanceGroups, InstanceGroup{
+			Name:           legacyJob.Name,
+			Instances:      legacyJob.Instances,
+			AZs:            legacyJob.AZs,
+			Networks:       legacyJob.Networks,
+			PersistentDisk: legacyJob.PersistentDisk,
+			VmType:         legacyJob.VmType,
+			Stemcell:       legacyJob.Stemcell,
+			Jobs:           jobs,
+		})
+	}
+	return &manifest
+}
+
+// Sets sections of properties that are not set yet to the ones of other.
+// Sections set in both are kept as they are.
+func (p *Properties) merge(other *Properties) {
+	if other == nil {
+		return
+	}
+	if reflect.DeepEqual(p.Peer, PeerProperties{}) {
+		p.Peer = other.Peer
+	}
+	if reflect.DeepEqual(p.Docker, DockerProperties{}) {
+		p.Docker = other.Docker
+	}
+	if reflect.DeepEqual(p.MemberService, MemberServiceProperties{}) {
+		p.MemberService = other.MemberService
+	}
+	for key, value := range other.Other {
+		if p.Other == nil {
+			p.Other = map[string]interface{}{}
+		}
+		if _, found := p.Other[key]; !found {
+			p.Other[key] = value
+		}
+	}
+}
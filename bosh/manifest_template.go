@@ -0,0 +1,194 @@
+package bosh
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ManifestContext is the data manifest templates are rendered with, e.g.
+// {{.DeploymentName}} or {{.Details.Vmtype}}
+type ManifestContext struct {
+	InstanceId     string
+	PlanId         string
+	DeploymentName string
+	NetworkName    string
+	Permissioned   bool
+	Details        *Details
+	// Parameters given by user on provision
+	Parameters map[string]interface{}
+	// Tags of the deployment, e.g. org of the service instance. BOSH passes
+	// them on to VMs and disks for cost attribution.
+	Tags map[string]string
+}
+
+// Data templates are rendered with. Parameters and tags are given by users,
+// so their strings are quoted when rendered to keep them from changing the
+// structure of the manifest.
+type templateContext struct {
+	ManifestContext
+	Parameters map[string]interface{}
+	Tags       map[string]interface{}
+}
+
+// Keys of parameters that can be rendered without quoting
+var parameterKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// String given by user, rendered as double quoted YAML scalar
+type userString string
+
+func (s userString) String() string {
+	return strconv.Quote(string(s))
+}
+
+// Functions available in templates, e.g. {{quote .PlanId}} or
+// {{toYaml .Parameters.peer}}
+var templateFuncs = template.FuncMap{
+	"quote":  quote,
+	"toYaml": toYaml,
+}
+
+// Returns value as double quoted YAML scalar
+func quote(value interface{}) string {
+	if s, ok := value.(userString); ok {
+		return s.String()
+	}
+	return strconv.Quote(fmt.Sprint(value))
+}
+
+// Returns value as YAML flow node that can be used within a line, e.g. an
+// object given as parameter
+func toYaml(value interface{}) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Returns copy of value given by user with strings replaced by userString.
+// Keys of objects are rendered without quotes and must be plain names.
+func escapeUserValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return userString(v), nil
+	case map[string]interface{}:
+		escaped := make(map[string]interface{})
+		for key, nested := range v {
+			if !parameterKeyPattern.MatchString(key) {
+				return nil, errors.New(fmt.Sprintf("key %s must only contain letters, digits, '_', '.' and '-'", strconv.Quote(key)))
+			}
+			var err error
+			escaped[key], err = escapeUserValue(nested)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return escaped, nil
+	case []interface{}:
+		escaped := make([]interface{}, len(v))
+		for i := range v {
+			var err error
+			escaped[i], err = escapeUserValue(v[i])
+			if err != nil {
+				return nil, err
+			}
+		}
+		return escaped, nil
+	}
+	return value, nil
+}
+
+func newTemplateContext(context ManifestContext) (*templateContext, error) {
+	templateContext := &templateContext{
+		ManifestContext: context,
+		Parameters:      make(map[string]interface{}),
+		Tags:            make(map[string]interface{}),
+	}
+	for name, value := range context.Parameters {
+		if !parameterKeyPattern.MatchString(name) {
+			return nil, &ParameterError{Parameter: strconv.Quote(name), Message: "name must only contain letters, digits, '_', '.' and '-'"}
+		}
+		escaped, err := escapeUserValue(value)
+		if err != nil {
+			return nil, &ParameterError{Parameter: name, Message: err.Error()}
+		}
+		templateContext.Parameters[name] = escaped
+	}
+	for name, value := range context.Tags {
+		templateContext.Tags[name] = userString(value)
+	}
+	return templateContext, nil
+}
+
+// ManifestTemplates maps plan id to template of manifest for its instances
+type ManifestTemplates map[string]*template.Template
+
+// LoadManifestTemplates parses all *.yml files in dir as manifest templates.
+// Name of the file without extension is the plan id, e.g. the template for
+// plan 15175506-D9F6-4CD8-AA1E-8F0AAFB99C07 is in
+// 15175506-D9F6-4CD8-AA1E-8F0AAFB99C07.yml.
+func LoadManifestTemplates(dir string) (ManifestTemplates, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.yml"))
+	if err != nil {
+		return nil, err
+	}
+
+	templates := ManifestTemplates{}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Error("Error reading manifest template", err)
+			return nil, err
+		}
+
+		planId := strings.TrimSuffix(filepath.Base(path), ".yml")
+		tmpl, err := template.New(planId).Funcs(templateFuncs).Option("missingkey=error").Parse(string(data))
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid manifest template %s: %s", path, err))
+		}
+		templates[planId] = tmpl
+		log.Infof("Loaded manifest template for plan %s", planId)
+	}
+	return templates, nil
+}
+
+func renderManifest(tmpl *template.Template, context ManifestContext) (*Manifest, error) {
+	templateContext, err := newTemplateContext(context)
+	if err != nil {
+		return nil, err
+	}
+
+	var rendered bytes.Buffer
+	err = tmpl.Execute(&rendered, templateContext)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to render manifest template for plan %s: %s", context.PlanId, err))
+	}
+
+	// Templates written for the legacy schema have jobs at top level
+	legacy := legacyManifest{}
+	err = yaml.Unmarshal(rendered.Bytes(), &legacy)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Manifest rendered for plan %s is not valid YAML: %s", context.PlanId, err))
+	}
+	if len(legacy.Jobs) > 0 {
+		log.Debugf("Manifest template for plan %s uses legacy schema", context.PlanId)
+		return legacy.manifest(), nil
+	}
+
+	manifest := Manifest{}
+	err = yaml.Unmarshal(rendered.Bytes(), &manifest)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Manifest rendered for plan %s is not valid YAML: %s", context.PlanId, err))
+	}
+	return &manifest, nil
+}
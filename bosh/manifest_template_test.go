@@ -0,0 +1,222 @@
+package bosh_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/predix/fabric-service-broker/bosh"
+
+	. "gopkg.in/go-playground/assert.v1"
+)
+
+const manifestTemplate = `
+name: {{.DeploymentName}}
+director_uuid: {{.Details.DirectorUUID}}
+stemcells:
+- alias: default
+  name: {{.Details.StemcellName}}
+  version: latest
+releases:
+- name: fabric-release
+  version: latest
+jobs:
+- name: peer
+  instances: {{with index .Parameters "peers"}}{{.}}{{else}}4{{end}}
+  azs: [z1]
+  networks:
+  - name: {{.NetworkName}}
+  vm_type: {{.Details.Vmtype}}
+  stemcell: default
+  templates:
+  - name: peer
+    release: fabric-release
+properties:
+  peer:
+    network:
+      id: {{.InstanceId}}
+{{with index .Parameters "banner"}}  login_banner:
+    text: {{.}}
+{{end}}{{with index .Parameters "labels"}}  labels: {{toYaml .}}
+{{end}}`
+
+func writeManifestTemplates(t *testing.T, templates map[string]string) string {
+	dir, err := ioutil.TempDir("", "manifest-templates")
+	Equal(t, err, nil)
+	for name, content := range templates {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600)
+		Equal(t, err, nil)
+	}
+	return dir
+}
+
+func TestNewManifest_FromTemplate(t *testing.T) {
+	dir := writeManifestTemplates(t, map[string]string{"plan-1.yml": manifestTemplate, "README.md": "not a template"})
+	defer os.RemoveAll(dir)
+
+	templates, err := bosh.LoadManifestTemplates(dir)
+	Equal(t, err, nil)
+	Equal(t, len(templates), 1)
+
+	details := *boshDetails
+	details.ManifestTemplates = templates
+	context := manifestContextWithDetails(deploymentName, networkName, false, &details)
+	context.Parameters = map[string]interface{}{"peers": 7}
+
+	manifest, err := bosh.NewManifest(context, nil)
+	Equal(t, err, nil)
+	Equal(t, manifest.Name, deploymentName)
+	Equal(t, manifest.DirectorUuid, boshUuid)
+	Equal(t, len(manifest.InstanceGroups), 1)
+	peer := manifest.InstanceGroup("peer")
+	NotEqual(t, peer, nil)
+	Equal(t, peer.Instances, uint(7))
+	Equal(t, peer.VmType, vmType)
+	Equal(t, peer.Networks[0], map[string]string{"name": networkName})
+	Equal(t, manifest.Properties.Peer.Network["id"], "instance-1")
+
+	// Parameters are optional
+	context.Parameters = nil
+	manifest, err = bosh.NewManifest(context, nil)
+	Equal(t, err, nil)
+	Equal(t, manifest.InstanceGroup("peer").Instances, uint(4))
+
+	// Other plans use built-in manifest
+	context.PlanId = "plan-2"
+	manifest, err = bosh.NewManifest(context, nil)
+	Equal(t, err, nil)
+	Equal(t, len(manifest.InstanceGroups), 1)
+	Equal(t, manifest.InstanceGroup("peer").Instances, uint(4))
+}
+
+func TestNewManifest_TemplateQuotesParameters(t *testing.T) {
+	dir := writeManifestTemplates(t, map[string]string{"plan-1.yml": manifestTemplate})
+	defer os.RemoveAll(dir)
+	templates, err := bosh.LoadManifestTemplates(dir)
+	Equal(t, err, nil)
+
+	details := *boshDetails
+	details.ManifestTemplates = templates
+	context := manifestContextWithDetails(deploymentName, networkName, false, &details)
+	context.Parameters = map[string]interface{}{
+		"banner": "hello\nproperties:\n  injected: true",
+		"labels": map[string]interface{}{"team": "a: b"},
+	}
+	manifest, err := bosh.NewManifest(context, nil)
+	Equal(t, err, nil)
+	Equal(t, manifest.Properties.Other["login_banner"], map[interface{}]interface{}{"text": "hello\nproperties:\n  injected: true"})
+	Equal(t, manifest.Properties.Other["labels"], map[interface{}]interface{}{"team": "a: b"})
+	Equal(t, manifest.Properties.Other["injected"], nil)
+
+	context.Parameters = map[string]interface{}{"banner": "hello\n  injected: true"}
+	manifest, err = bosh.NewManifest(context, nil)
+	Equal(t, err, nil)
+	Equal(t, manifest.Properties.Other["login_banner"], map[interface{}]interface{}{"text": "hello\n  injected: true"})
+
+	context.Parameters = map[string]interface{}{"labels": map[string]interface{}{"team\nx": "a"}}
+	_, err = bosh.NewManifest(context, nil)
+	Equal(t, bosh.IsParameterError(err), true)
+}
+
+func TestNewManifest_TemplateMissingKey(t *testing.T) {
+	dir := writeManifestTemplates(t, map[string]string{"plan-1.yml": "name: {{.DeploymentName}}\ndirector_uuid: {{.Details.DirectorUUID}}\npeers: {{.Parameters.peers}}\n"})
+	defer os.RemoveAll(dir)
+	templates, err := bosh.LoadManifestTemplates(dir)
+	Equal(t, err, nil)
+
+	details := *boshDetails
+	details.ManifestTemplates = templates
+	_, err = bosh.NewManifest(manifestContextWithDetails(deploymentName, networkName, false, &details), nil)
+	NotEqual(t, err, nil)
+	Equal(t, strings.Contains(err.Error(), "map has no entry for key \"peers\""), true)
+}
+
+func TestNewManifest_InvalidTemplateOutput(t *testing.T) {
+	dir := writeManifestTemplates(t, map[string]string{"plan-1.yml": "name: {{.DeploymentName}}\ndirector_uuid: x\n"})
+	defer os.RemoveAll(dir)
+
+	templates, err := bosh.LoadManifestTemplates(dir)
+	Equal(t, err, nil)
+
+	details := *boshDetails
+	details.ManifestTemplates = templates
+	_, err = bosh.NewManifest(manifestContextWithDetails(deploymentName, networkName, false, &details), nil)
+	NotEqual(t, err, nil)
+	Equal(t, err.Error(), "Manifest must have at least one stemcell")
+}
+
+func TestLoadManifestTemplates_ParseError(t *testing.T) {
+	dir := writeManifestTemplates(t, map[string]string{"plan-1.yml": "name: {{.DeploymentName"})
+	defer os.RemoveAll(dir)
+
+	_, err := bosh.LoadManifestTemplates(dir)
+	NotEqual(t, err, nil)
+}
+
+const instanceGroupsTemplate = `
+name: {{.DeploymentName}}
+director_uuid: {{.Details.DirectorUUID}}
+stemcells:
+- alias: default
+  name: {{.Details.StemcellName}}
+  version: latest
+releases:
+- name: fabric-release
+  version: latest
+- name: os-conf
+  version: latest
+instance_groups:
+- name: peer
+  instances: 4
+  azs: [z1]
+  networks:
+  - name: {{.NetworkName}}
+  vm_type: {{.Details.Vmtype}}
+  stemcell: default
+  jobs:
+  - name: peer
+    release: fabric-release
+    properties:
+      peer:
+        network:
+          id: {{.InstanceId}}
+addons:
+- name: os-configuration
+  jobs:
+  - name: login_banner
+    release: os-conf
+    properties:
+      login_banner:
+        text: Hyperledger fabric
+  include:
+    stemcell:
+    - os: ubuntu-trusty
+tags:
+  instance: {{.InstanceId}}
+`
+
+func TestNewManifest_FromInstanceGroupsTemplate(t *testing.T) {
+	dir := writeManifestTemplates(t, map[string]string{"plan-1.yml": instanceGroupsTemplate})
+	defer os.RemoveAll(dir)
+
+	templates, err := bosh.LoadManifestTemplates(dir)
+	Equal(t, err, nil)
+
+	details := *boshDetails
+	details.ManifestTemplates = templates
+	context := manifestContextWithDetails(deploymentName, networkName, false, &details)
+	context.Tags = map[string]string{"space_guid": "space-1"}
+	manifest, err := bosh.NewManifest(context, nil)
+	Equal(t, err, nil)
+	Equal(t, len(manifest.InstanceGroups), 1)
+	Equal(t, manifest.JobProperties("peer", "peer").Peer.Network["id"], "instance-1")
+	Equal(t, manifest.Tags, map[string]string{"instance": "instance-1", "space_guid": "space-1"})
+	Equal(t, len(manifest.Addons), 1)
+	Equal(t, manifest.Addons[0].Jobs[0].Properties.Other["login_banner"], map[interface{}]interface{}{"text": "Hyperledger fabric"})
+
+	yaml := manifestYaml(t, manifest)
+	Equal(t, strings.Contains(yaml, "login_banner:\n        text: Hyperledger fabric"), true)
+	Equal(t, strings.Contains(yaml, "tags:\n  instance: instance-1\n  space_guid: space-1"), true)
+}
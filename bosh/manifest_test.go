@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/predix/fabric-service-broker/bosh"
+	"github.com/predix/fabric-service-broker/credhub"
 
 	. "gopkg.in/go-playground/assert.v1"
 )
@@ -16,8 +17,30 @@ const (
 
 var boshDetails = bosh.NewDetails(boshStemcell, boshUuid, vmType, networkNames, directorUrl, peerDataDir, dockerDataDir)
 
+func manifestContext(deploymentName, networkName string, permissioned bool) bosh.ManifestContext {
+	return manifestContextWithDetails(deploymentName, networkName, permissioned, boshDetails)
+}
+
+func manifestContextWithDetails(deploymentName, networkName string, permissioned bool, details *bosh.Details) bosh.ManifestContext {
+	return bosh.ManifestContext{
+		InstanceId:     "instance-1",
+		PlanId:         "plan-1",
+		DeploymentName: deploymentName,
+		NetworkName:    networkName,
+		Permissioned:   permissioned,
+		Details:        details,
+	}
+}
+
+// Returns manifest as YAML and fails the test if it cannot be rendered
+func manifestYaml(t *testing.T, manifest *bosh.Manifest) string {
+	yaml, err := manifest.String()
+	Equal(t, err, nil)
+	return yaml
+}
+
 func TestNewManifest(t *testing.T) {
-	manifest, err := bosh.NewManifest(deploymentName, networkName, false, boshDetails)
+	manifest, err := bosh.NewManifest(manifestContext(deploymentName, networkName, false), nil)
 
 	stemcell := bosh.Stemcell{
 		Alias:   "default",
@@ -31,16 +54,17 @@ func TestNewManifest(t *testing.T) {
 	Equal(t, manifest.Name, deploymentName)
 	Equal(t, manifest.DirectorUuid, boshUuid)
 	Equal(t, manifest.Stemcells[0], stemcell)
-	Equal(t, manifest.Jobs[0].VmType, vmType)
-	Equal(t, manifest.Jobs[0].Networks[0], map[string]string{"name": networkName})
-	Equal(t, manifest.Properties.Peer.Network, map[string]string{"id": deploymentName})
-	Equal(t, manifest.Properties.Peer.Consensus, map[string]string{"plugin": "pbft"})
-	Equal(t, manifest.Properties.Peer.Core.DataPath, peerDataDir)
-	Equal(t, manifest.Properties.Docker.Store.Dir, dockerDataDir)
+	Equal(t, manifest.InstanceGroups[0].VmType, vmType)
+	Equal(t, manifest.InstanceGroups[0].Networks[0], map[string]string{"name": networkName})
+	peer := manifest.JobProperties("peer", "peer").Peer
+	Equal(t, peer.Network, map[string]string{"id": deploymentName})
+	Equal(t, peer.Consensus, bosh.ConsensusProperties{Plugin: "pbft", PBFT: &bosh.PBFTSettings{N: 4, F: 1}})
+	Equal(t, peer.Core.DataPath, peerDataDir)
+	Equal(t, manifest.JobProperties("peer", "docker").Docker.Store.Dir, dockerDataDir)
 }
 
 func TestNewManifestPermissioned(t *testing.T) {
-	manifest, err := bosh.NewManifest(deploymentName, networkName, true, boshDetails)
+	manifest, err := bosh.NewManifest(manifestContext(deploymentName, networkName, true), nil)
 
 	stemcell := bosh.Stemcell{
 		Alias:   "default",
@@ -54,38 +78,252 @@ func TestNewManifestPermissioned(t *testing.T) {
 	Equal(t, manifest.Name, deploymentName)
 	Equal(t, manifest.DirectorUuid, boshUuid)
 	Equal(t, manifest.Stemcells[0], stemcell)
-	Equal(t, manifest.Jobs[0].VmType, vmType)
-	Equal(t, manifest.Jobs[0].Networks[0], map[string]string{"name": networkName})
-	Equal(t, manifest.Properties.Peer.Network, map[string]string{"id": deploymentName})
-	Equal(t, manifest.Properties.Peer.Consensus, map[string]string{"plugin": "pbft"})
-	Equal(t, manifest.Properties.Peer.Core.DataPath, peerDataDir)
-	Equal(t, manifest.Properties.Docker.Store.Dir, dockerDataDir)
-	Equal(t, len(manifest.Properties.MemberService.Clients), 7)
-	user := bosh.BlockchainUser{
-		Name:            "lukas",
-		Secret:          "NPKYL39uKbkj",
-		Affiliation:     "bank_a",
-		AffiliationRole: "00001",
-	}
-	found := false
-	for _, client := range manifest.Properties.MemberService.Clients {
-		if client == user {
-			found = true
+	Equal(t, manifest.InstanceGroups[0].VmType, vmType)
+	Equal(t, manifest.InstanceGroups[0].Networks[0], map[string]string{"name": networkName})
+	peer := manifest.JobProperties("peer", "peer").Peer
+	Equal(t, peer.Network, map[string]string{"id": deploymentName})
+	Equal(t, peer.Consensus, bosh.ConsensusProperties{Plugin: "pbft", PBFT: &bosh.PBFTSettings{N: 4, F: 1}})
+	Equal(t, peer.Core.DataPath, peerDataDir)
+	Equal(t, manifest.JobProperties("peer", "docker").Docker.Store.Dir, dockerDataDir)
+	memberService := manifest.JobProperties("membersrvc", "member_service").MemberService
+	Equal(t, len(memberService.Clients), 7)
+
+	var lukas *bosh.BlockchainUser
+	for i, client := range memberService.Clients {
+		if client.Name == "lukas" {
+			lukas = &memberService.Clients[i]
 			break
 		}
 	}
-	Equal(t, found, true)
+	NotEqual(t, lukas, nil)
+	Equal(t, lukas.Affiliation, "bank_a")
+	Equal(t, lukas.AffiliationRole, "00001")
+	Equal(t, len(lukas.Secret), 20)
+	NotEqual(t, lukas.Secret, "NPKYL39uKbkj")
+
+	secrets := manifest.Secrets()
+	Equal(t, len(secrets), 13)
+	Equal(t, secrets["lukas"], lukas.Secret)
+	Equal(t, secrets["vp0"], memberService.Validators[0].Secret)
+}
+
+func TestNewManifestPermissioned_SecretsPerInstance(t *testing.T) {
+	manifest, err := bosh.NewManifest(manifestContext(deploymentName, networkName, true), nil)
+	Equal(t, err, nil)
+	otherManifest, err := bosh.NewManifest(manifestContext("otherdeployment", networkName, true), nil)
+	Equal(t, err, nil)
+
+	for name, secret := range manifest.Secrets() {
+		NotEqual(t, otherManifest.Secrets()[name], secret)
+	}
+}
+
+func TestNewManifestPermissioned_ReusesSecrets(t *testing.T) {
+	manifest, err := bosh.NewManifest(manifestContext(deploymentName, networkName, true), nil)
+	Equal(t, err, nil)
+
+	encoded, err := manifest.Secrets().Encode()
+	Equal(t, err, nil)
+	secrets, err := bosh.ParseSecrets(encoded)
+	Equal(t, err, nil)
+
+	redeployManifest, err := bosh.NewManifest(manifestContext(deploymentName, networkName, true), secrets)
+	Equal(t, err, nil)
+	Equal(t, redeployManifest.Secrets(), manifest.Secrets())
+}
+
+func TestNewManifest_NoSecrets(t *testing.T) {
+	manifest, err := bosh.NewManifest(manifestContext(deploymentName, networkName, false), nil)
+	Equal(t, err, nil)
+	Equal(t, len(manifest.Secrets()), 0)
 }
 
 func TestManifestToString(t *testing.T) {
-	manifest, err := bosh.NewManifest(deploymentName, networkName, false, boshDetails)
+	manifest, err := bosh.NewManifest(manifestContext(deploymentName, networkName, false), nil)
 
 	Equal(t, err, nil)
 	NotEqual(t, manifest, nil)
 
 	manifest.Name = "test-deployment-name"
 
-	Equal(t, strings.Contains(manifest.String(), "name: test-deployment-name"), true)
-	Equal(t, strings.Contains(manifest.String(), "name: hyperledger-fabric"), false)
-	Equal(t, strings.Contains(manifest.String(), "plugin: pbft"), true)
+	Equal(t, strings.Contains(manifestYaml(t, manifest), "name: test-deployment-name"), true)
+	Equal(t, strings.Contains(manifestYaml(t, manifest), "name: hyperledger-fabric"), false)
+	Equal(t, strings.Contains(manifestYaml(t, manifest), "plugin: pbft"), true)
+}
+
+func TestManifestValidate(t *testing.T) {
+	for _, test := range []struct {
+		change func(*bosh.Manifest)
+		err    string
+	}{
+		{func(m *bosh.Manifest) {}, ""},
+		{func(m *bosh.Manifest) { m.Stemcells[0].Name = "" }, "Stemcell default must have alias, name and version"},
+		{func(m *bosh.Manifest) { m.InstanceGroups[0].Instances = 0 }, "Instance group peer must have at least one instance"},
+		{func(m *bosh.Manifest) { m.InstanceGroups[0].Networks = []map[string]string{{}} }, "Network of instance group peer must have a name"},
+		{func(m *bosh.Manifest) { m.InstanceGroups[0].Stemcell = "trusty" }, "Instance group peer uses stemcell trusty which is not defined"},
+		{func(m *bosh.Manifest) { m.InstanceGroups[0].Jobs[1].Name = "peer" }, "Job peer defined more than once in peer"},
+		{func(m *bosh.Manifest) { m.InstanceGroups[0].Jobs[1].Release = "docker" }, "Job docker in peer uses release docker which is not defined"},
+		{func(m *bosh.Manifest) {
+			m.Addons = []bosh.Addon{{Name: "os-configuration", Jobs: bosh.Jobs{{Name: "login_banner", Release: "os-conf"}}}}
+		}, "Job login_banner in addon os-configuration uses release os-conf which is not defined"},
+		{func(m *bosh.Manifest) { m.JobProperties("peer", "peer").Peer.Consensus.PBFT.F = 2 }, "Invalid consensus settings of peers: pbft needs N >= 3f+1 but N is 4 and f is 2"},
+	} {
+		manifest, err := bosh.NewManifest(manifestContext(deploymentName, networkName, false), nil)
+		Equal(t, err, nil)
+		test.change(manifest)
+		err = manifest.Validate()
+		if test.err == "" {
+			Equal(t, err, nil)
+		} else {
+			Equal(t, err.Error(), test.err)
+		}
+	}
+}
+
+func TestNewManifest_Invalid(t *testing.T) {
+	details := *boshDetails
+	details.StemcellName = ""
+	_, err := bosh.NewManifest(manifestContextWithDetails(deploymentName, networkName, false, &details), nil)
+	Equal(t, bosh.IsManifestError(err), true)
+	Equal(t, bosh.IsParameterError(err), false)
+	Equal(t, err.Error(), "Stemcell default must have alias, name and version")
+}
+
+func TestNewManifestPermissioned_ConfigServer(t *testing.T) {
+	details := *boshDetails
+	details.Credhub = credhub.Details{Url: "https://credhub:8844", UaaUrl: "https://uaa:8443", ClientId: "broker"}
+
+	manifest, err := bosh.NewManifest(manifestContextWithDetails(deploymentName, networkName, true, &details), nil)
+	Equal(t, err, nil)
+	Equal(t, len(manifest.Variables), 13)
+	Equal(t, manifest.Variables[0], bosh.Variable{Name: "membersrvc_admin_secret", Type: "password"})
+	Equal(t, manifest.JobProperties("membersrvc", "member_service").MemberService.Clients[0].Secret, "((membersrvc_admin_secret))")
+	Equal(t, len(manifest.Secrets()), 0)
+	Equal(t, strings.Contains(manifestYaml(t, manifest), "variables:"), true)
+}
+
+func TestNewManifestPermissioned_NoConfigServer(t *testing.T) {
+	manifest, err := bosh.NewManifest(manifestContext(deploymentName, networkName, true), nil)
+	Equal(t, err, nil)
+	Equal(t, len(manifest.Variables), 0)
+	Equal(t, strings.Contains(manifestYaml(t, manifest), "(("), false)
+	Equal(t, strings.Contains(manifestYaml(t, manifest), "variables:"), false)
+}
+
+func TestMemberServiceClientNames(t *testing.T) {
+	manifest, err := bosh.NewManifest(manifestContext(deploymentName, networkName, true), nil)
+	Equal(t, err, nil)
+	names := manifest.MemberServiceClientNames()
+	Equal(t, len(names), 7)
+	Equal(t, names[0], "admin")
+	Equal(t, bosh.SecretVariablePath("bosh-lite", "fabric-1", names[0]), "/bosh-lite/fabric-1/membersrvc_admin_secret")
+
+	manifest, err = bosh.NewManifest(manifestContext(deploymentName, networkName, false), nil)
+	Equal(t, err, nil)
+	Equal(t, len(manifest.MemberServiceClientNames()), 0)
+}
+
+func TestParseManifest(t *testing.T) {
+	manifest, err := bosh.NewManifest(manifestContext(deploymentName, networkName, true), nil)
+	Equal(t, err, nil)
+	parsed, err := bosh.ParseManifest(manifestYaml(t, manifest))
+	Equal(t, err, nil)
+	Equal(t, parsed.MemberServiceClientNames(), manifest.MemberServiceClientNames())
+
+	details := *boshDetails
+	details.LegacyManifest = true
+	manifest, err = bosh.NewManifest(manifestContextWithDetails(deploymentName, networkName, true, &details), nil)
+	Equal(t, err, nil)
+	parsed, err = bosh.ParseManifest(manifestYaml(t, manifest))
+	Equal(t, err, nil)
+	Equal(t, parsed.MemberServiceClientNames(), manifest.MemberServiceClientNames())
+}
+
+func TestNewManifestPermissioned_Links(t *testing.T) {
+	manifest, err := bosh.NewManifest(manifestContext(deploymentName, networkName, true), nil)
+	Equal(t, err, nil)
+
+	memberService := manifest.InstanceGroup("membersrvc").Job("member_service")
+	Equal(t, memberService.Provides["membersrvc"], bosh.Link{As: "membersrvc"})
+	peer := manifest.InstanceGroup("peer").Job("peer")
+	Equal(t, peer.Consumes["membersrvc"], bosh.Link{From: "membersrvc"})
+	Equal(t, manifest.JobProperties("peer", "peer").Peer.Security.Enabled, true)
+	Equal(t, manifest.JobProperties("peer", "missing") == nil, true)
+}
+
+func TestNewManifestPermissioned_PeerProperties(t *testing.T) {
+	withConfigServer := *boshDetails
+	withConfigServer.Credhub = credhub.Details{Url: "https://credhub:8844", UaaUrl: "https://uaa:8443", ClientId: "broker"}
+	legacy := *boshDetails
+	legacy.LegacyManifest = true
+
+	for _, details := range []*bosh.Details{boshDetails, &withConfigServer, &legacy} {
+		manifest, err := bosh.NewManifest(manifestContextWithDetails(deploymentName, networkName, true, details), nil)
+		Equal(t, err, nil)
+		if details.LegacyManifest {
+			// Properties as the director sees them
+			manifest, err = bosh.ParseManifest(manifestYaml(t, manifest))
+			Equal(t, err, nil)
+		}
+
+		// Peers see the same membership service users and secrets as the
+		// membership service
+		memberService := manifest.JobProperties("membersrvc", "member_service").MemberService
+		peer := manifest.JobProperties("peer", "peer")
+		Equal(t, len(peer.MemberService.Validators), 4)
+		Equal(t, peer.MemberService, memberService)
+		Equal(t, peer.Peer.Consensus.Plugin, "pbft")
+		Equal(t, peer.Peer.Security.Enabled, true)
+	}
+}
+
+func TestManifestToString_InstanceGroups(t *testing.T) {
+	details := *boshDetails
+	details.UseDNSAddresses = true
+
+	manifest, err := bosh.NewManifest(manifestContextWithDetails(deploymentName, networkName, true, &details), nil)
+	Equal(t, err, nil)
+
+	yaml := manifestYaml(t, manifest)
+	Equal(t, strings.Contains(yaml, "instance_groups:"), true)
+	Equal(t, strings.Contains(yaml, "templates:"), false)
+	Equal(t, strings.Contains(yaml, "features:\n  use_dns_addresses: true"), true)
+	Equal(t, strings.Contains(yaml, "consumes:\n      membersrvc:\n        from: membersrvc"), true)
+	Equal(t, strings.Contains(yaml, "\nproperties:"), false)
+}
+
+func TestManifestToString_Legacy(t *testing.T) {
+	details := *boshDetails
+	details.LegacyManifest = true
+	details.UseDNSAddresses = true
+
+	manifest, err := bosh.NewManifest(manifestContextWithDetails(deploymentName, networkName, true, &details), nil)
+	Equal(t, err, nil)
+
+	yaml := manifestYaml(t, manifest)
+	Equal(t, strings.Contains(yaml, "instance_groups:"), false)
+	Equal(t, strings.Contains(yaml, "features:"), false)
+	Equal(t, strings.Contains(yaml, "consumes:"), false)
+	Equal(t, strings.Contains(yaml, "\njobs:\n- instances: 1"), true)
+	Equal(t, strings.Contains(yaml, "  templates:\n  - name: member_service\n    release: fabric-release"), true)
+	Equal(t, strings.Contains(yaml, "\nproperties:\n  peer:"), true)
+	Equal(t, strings.Contains(yaml, "\n  docker:\n    store:"), true)
+	Equal(t, strings.Contains(yaml, "\n  membersrvc:\n    affiliations:"), true)
+}
+
+func TestNewManifest_Tags(t *testing.T) {
+	context := manifestContext(deploymentName, networkName, false)
+	context.Tags = map[string]string{"organization_guid": "org-1", "space_guid": "space-1"}
+
+	manifest, err := bosh.NewManifest(context, nil)
+	Equal(t, err, nil)
+	Equal(t, manifest.Tags, context.Tags)
+	Equal(t, strings.Contains(manifestYaml(t, manifest), "tags:\n  organization_guid: org-1\n  space_guid: space-1\n"), true)
+
+	details := *boshDetails
+	details.LegacyManifest = true
+	context.Details = &details
+	manifest, err = bosh.NewManifest(context, nil)
+	Equal(t, err, nil)
+	Equal(t, strings.Contains(manifestYaml(t, manifest), "tags:"), false)
 }
@@ -0,0 +1,312 @@
+package bosh
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	OpReplace = "replace"
+	OpRemove  = "remove"
+)
+
+// Operation is a single entry of a BOSH ops file. Path follows the syntax
+// of BOSH CLI, e.g. /instance_groups/name=peer/instances, with "-" to
+// append to an array and a "?" suffix to create missing map keys and array
+// elements from that segment on instead of failing.
+type Operation struct {
+	Type  string      `yaml:"type"`
+	Path  string      `yaml:"path"`
+	Value interface{} `yaml:"value,omitempty"`
+}
+
+// LoadOpsFile reads operations from a BOSH ops file
+func LoadOpsFile(path string) ([]Operation, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Error("Error reading ops file", err)
+		return nil, err
+	}
+
+	ops := []Operation{}
+	err = yaml.Unmarshal(data, &ops)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Invalid ops file %s: %s", path, err))
+	}
+	for _, op := range ops {
+		_, err = parsePath(op.Path)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid ops file %s: %s", path, err))
+		}
+		if op.Type != OpReplace && op.Type != OpRemove {
+			return nil, errors.New(fmt.Sprintf("Invalid ops file %s: unknown operation type %s", path, op.Type))
+		}
+	}
+	return ops, nil
+}
+
+// LoadOpsFiles reads operations of all given ops files in order
+func LoadOpsFiles(paths []string) ([]Operation, error) {
+	ops := []Operation{}
+	for _, path := range paths {
+		fileOps, err := LoadOpsFile(path)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, fileOps...)
+	}
+	return ops, nil
+}
+
+// ApplyOps applies operations in order to YAML document and returns the
+// resulting document
+func ApplyOps(document string, ops []Operation) (string, error) {
+	if len(ops) == 0 {
+		return document, nil
+	}
+
+	var root interface{}
+	err := yaml.Unmarshal([]byte(document), &root)
+	if err != nil {
+		return "", err
+	}
+
+	for _, op := range ops {
+		tokens, err := parsePath(op.Path)
+		if err != nil {
+			return "", err
+		}
+		// Value is copied as later ops can change it inside the document and
+		// the same ops are applied to every manifest of the plan
+		op.Value = copyValue(op.Value)
+		root, err = applyOp(root, tokens, op)
+		if err != nil {
+			return "", errors.New(fmt.Sprintf("Unable to apply %s operation for path %s: %s", op.Type, op.Path, err))
+		}
+	}
+
+	data, err := yaml.Marshal(root)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+type tokenKind int
+
+const (
+	keyToken tokenKind = iota
+	indexToken
+	appendToken
+	matchToken
+)
+
+type pathToken struct {
+	kind     tokenKind
+	key      string
+	index    int
+	value    string
+	optional bool
+}
+
+func (t pathToken) String() string {
+	switch t.kind {
+	case indexToken:
+		return strconv.Itoa(t.index)
+	case appendToken:
+		return "-"
+	case matchToken:
+		return fmt.Sprintf("%s=%s", t.key, t.value)
+	}
+	return t.key
+}
+
+func parsePath(path string) ([]pathToken, error) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, errors.New(fmt.Sprintf("Path %s must start with /", path))
+	}
+	if path == "/" {
+		return nil, errors.New("Path must not be empty")
+	}
+
+	tokens := []pathToken{}
+	optional := false
+	for _, part := range strings.Split(path[1:], "/") {
+		// Escapes as in JSON pointers
+		part = strings.Replace(strings.Replace(part, "~1", "/", -1), "~0", "~", -1)
+
+		// As in BOSH CLI all segments following an optional one are
+		// optional as well
+		if strings.HasSuffix(part, "?") {
+			optional = true
+			part = strings.TrimSuffix(part, "?")
+		}
+		token := pathToken{optional: optional}
+
+		index, err := strconv.Atoi(part)
+		switch {
+		case part == "-":
+			token.kind = appendToken
+		case err == nil:
+			token.kind = indexToken
+			token.index = index
+		case strings.Contains(part, "="):
+			token.kind = matchToken
+			pair := strings.SplitN(part, "=", 2)
+			token.key = pair[0]
+			token.value = pair[1]
+		case part == "":
+			return nil, errors.New(fmt.Sprintf("Path %s has an empty segment", path))
+		default:
+			token.kind = keyToken
+			token.key = part
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// Applies op at tokens below node and returns the resulting node. Arrays
+// can change length so parents store the returned node.
+func applyOp(node interface{}, tokens []pathToken, op Operation) (interface{}, error) {
+	token := tokens[0]
+	last := len(tokens) == 1
+
+	switch typed := node.(type) {
+	case map[interface{}]interface{}:
+		if token.kind != keyToken {
+			return nil, errors.New(fmt.Sprintf("expected map key but found %s", token))
+		}
+		child, found := typed[token.key]
+		if last {
+			if !found && !token.optional {
+				return nil, errors.New(fmt.Sprintf("map key %s not found", token.key))
+			}
+			if op.Type == OpRemove {
+				delete(typed, token.key)
+			} else {
+				typed[token.key] = op.Value
+			}
+			return typed, nil
+		}
+		if !found {
+			if !token.optional {
+				return nil, errors.New(fmt.Sprintf("map key %s not found", token.key))
+			}
+			child = newContainer(tokens[1])
+		}
+		updated, err := applyOp(child, tokens[1:], op)
+		if err != nil {
+			return nil, err
+		}
+		typed[token.key] = updated
+		return typed, nil
+
+	case []interface{}:
+		index, err := findIndex(typed, token)
+		if err != nil {
+			return nil, err
+		}
+		if index < 0 {
+			// Appending, or optional element that does not exist
+			if op.Type == OpRemove {
+				if token.optional {
+					return typed, nil
+				}
+				return nil, errors.New(fmt.Sprintf("array element %s not found", token))
+			}
+			if last {
+				return append(typed, op.Value), nil
+			}
+			var child interface{} = newContainer(tokens[1])
+			if token.kind == matchToken {
+				child = map[interface{}]interface{}{token.key: token.value}
+			}
+			updated, err := applyOp(child, tokens[1:], op)
+			if err != nil {
+				return nil, err
+			}
+			return append(typed, updated), nil
+		}
+		if last {
+			if op.Type == OpRemove {
+				return append(typed[:index], typed[index+1:]...), nil
+			}
+			typed[index] = op.Value
+			return typed, nil
+		}
+		updated, err := applyOp(typed[index], tokens[1:], op)
+		if err != nil {
+			return nil, err
+		}
+		typed[index] = updated
+		return typed, nil
+	}
+
+	if node == nil && token.kind == keyToken && token.optional {
+		return applyOp(map[interface{}]interface{}{}, tokens, op)
+	}
+	return nil, errors.New(fmt.Sprintf("cannot descend into %s", token))
+}
+
+// Returns index of array element addressed by token or -1 if the token
+// appends or addresses an optional element that does not exist
+func findIndex(array []interface{}, token pathToken) (int, error) {
+	switch token.kind {
+	case appendToken:
+		return -1, nil
+	case indexToken:
+		index := token.index
+		if index < 0 {
+			index += len(array)
+		}
+		if index < 0 || index >= len(array) {
+			return 0, errors.New(fmt.Sprintf("array index %d out of range", token.index))
+		}
+		return index, nil
+	case matchToken:
+		for i, element := range array {
+			elementMap, ok := element.(map[interface{}]interface{})
+			if ok && fmt.Sprint(elementMap[token.key]) == token.value {
+				return i, nil
+			}
+		}
+		if token.optional {
+			return -1, nil
+		}
+		return 0, errors.New(fmt.Sprintf("array element %s not found", token))
+	}
+	return 0, errors.New(fmt.Sprintf("expected array index but found %s", token))
+}
+
+// Returns empty container that can be addressed by token
+func newContainer(token pathToken) interface{} {
+	if token.kind == keyToken {
+		return map[interface{}]interface{}{}
+	}
+	return []interface{}{}
+}
+
+// Returns a deep copy of YAML value
+func copyValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[interface{}]interface{}:
+		copied := make(map[interface{}]interface{}, len(typed))
+		for key, element := range typed {
+			copied[key] = copyValue(element)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(typed))
+		for i, element := range typed {
+			copied[i] = copyValue(element)
+		}
+		return copied
+	}
+	return value
+}
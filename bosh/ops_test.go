@@ -0,0 +1,197 @@
+package bosh_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/predix/fabric-service-broker/bosh"
+
+	. "gopkg.in/go-playground/assert.v1"
+)
+
+const opsDocument = `name: fabric-1
+jobs:
+- name: membersrvc
+  instances: 1
+- name: peer
+  instances: 4
+  azs: [z1, z2]
+properties:
+  peer:
+    consensus:
+      plugin: pbft
+`
+
+func applyOps(t *testing.T, ops ...bosh.Operation) string {
+	result, err := bosh.ApplyOps(opsDocument, ops)
+	Equal(t, err, nil)
+	return result
+}
+
+func TestApplyOps_ReplaceMatchedElement(t *testing.T) {
+	result := applyOps(t, bosh.Operation{Type: bosh.OpReplace, Path: "/jobs/name=peer/instances", Value: 7})
+	Equal(t, strings.Contains(result, "instances: 7"), true)
+	Equal(t, strings.Contains(result, "instances: 1"), true)
+	Equal(t, strings.Contains(result, "instances: 4"), false)
+}
+
+func TestApplyOps_ReplaceIndexAndAppend(t *testing.T) {
+	result := applyOps(t,
+		bosh.Operation{Type: bosh.OpReplace, Path: "/jobs/1/azs/0", Value: "z3"},
+		bosh.Operation{Type: bosh.OpReplace, Path: "/jobs/name=peer/azs/-", Value: "z4"},
+	)
+	Equal(t, strings.Contains(result, "- z3\n  - z2\n  - z4"), true)
+}
+
+func TestApplyOps_Remove(t *testing.T) {
+	result := applyOps(t,
+		bosh.Operation{Type: bosh.OpRemove, Path: "/jobs/name=membersrvc"},
+		bosh.Operation{Type: bosh.OpRemove, Path: "/properties/peer/consensus"},
+	)
+	Equal(t, strings.Contains(result, "membersrvc"), false)
+	Equal(t, strings.Contains(result, "consensus"), false)
+	Equal(t, strings.Contains(result, "name: peer"), true)
+}
+
+func TestApplyOps_Optional(t *testing.T) {
+	result := applyOps(t,
+		bosh.Operation{Type: bosh.OpReplace, Path: "/properties/peer/logging?/level", Value: "debug"},
+		bosh.Operation{Type: bosh.OpReplace, Path: "/jobs/name=orderer?/instances", Value: 1},
+		bosh.Operation{Type: bosh.OpRemove, Path: "/properties/docker?"},
+	)
+	Equal(t, strings.Contains(result, "logging:\n      level: debug"), true)
+	Equal(t, strings.Contains(result, "- instances: 1\n  name: orderer"), true)
+}
+
+func TestApplyOps_ReusedOps(t *testing.T) {
+	// Later ops change inside the values inserted by earlier ones
+	ops := []bosh.Operation{
+		{Type: bosh.OpReplace, Path: "/properties/peer/logging?", Value: map[interface{}]interface{}{"levels": []interface{}{"info"}}},
+		{Type: bosh.OpReplace, Path: "/properties/peer/logging/levels/-", Value: "debug"},
+		{Type: bosh.OpReplace, Path: "/jobs/-", Value: map[interface{}]interface{}{"name": "orderer", "azs": []interface{}{"z1"}}},
+		{Type: bosh.OpReplace, Path: "/jobs/name=orderer/azs/-", Value: "z2"},
+		{Type: bosh.OpReplace, Path: "/jobs/0", Value: map[interface{}]interface{}{"name": "membersrvc", "azs": []interface{}{"z1"}}},
+		{Type: bosh.OpReplace, Path: "/jobs/0/azs/0", Value: "z3"},
+	}
+
+	first, err := bosh.ApplyOps(opsDocument, ops)
+	Equal(t, err, nil)
+	second, err := bosh.ApplyOps(opsDocument, ops)
+	Equal(t, err, nil)
+	Equal(t, second, first)
+	Equal(t, strings.Count(first, "debug"), 1)
+	Equal(t, strings.Count(first, "z3"), 1)
+}
+
+func TestApplyOps_Errors(t *testing.T) {
+	_, err := bosh.ApplyOps(opsDocument, []bosh.Operation{{Type: bosh.OpReplace, Path: "/jobs/name=orderer/instances", Value: 1}})
+	NotEqual(t, err, nil)
+	Equal(t, err.Error(), "Unable to apply replace operation for path /jobs/name=orderer/instances: array element name=orderer not found")
+
+	_, err = bosh.ApplyOps(opsDocument, []bosh.Operation{{Type: bosh.OpReplace, Path: "/properties/missing/key", Value: 1}})
+	NotEqual(t, err, nil)
+
+	_, err = bosh.ApplyOps(opsDocument, []bosh.Operation{{Type: bosh.OpRemove, Path: "/jobs/5"}})
+	NotEqual(t, err, nil)
+
+	_, err = bosh.ApplyOps(opsDocument, []bosh.Operation{{Type: bosh.OpReplace, Path: "jobs", Value: 1}})
+	NotEqual(t, err, nil)
+}
+
+func TestLoadOpsFile(t *testing.T) {
+	path := writeTempFile(t, "- type: replace\n  path: /jobs/name=peer/instances\n  value: 7\n")
+	defer os.Remove(path)
+
+	ops, err := bosh.LoadOpsFile(path)
+	Equal(t, err, nil)
+	Equal(t, len(ops), 1)
+	Equal(t, ops[0].Type, bosh.OpReplace)
+
+	invalidPath := writeTempFile(t, "- type: move\n  path: /jobs\n")
+	defer os.Remove(invalidPath)
+	_, err = bosh.LoadOpsFile(invalidPath)
+	NotEqual(t, err, nil)
+}
+
+func TestNewManifest_Ops(t *testing.T) {
+	details := *boshDetails
+	details.Ops = []bosh.Operation{{Type: bosh.OpReplace, Path: "/instance_groups/name=peer/persistent_disk", Value: 20000}}
+	details.Plans = bosh.PlanConfigs{
+		"plan-1": bosh.PlanConfig{Ops: []bosh.Operation{{Type: bosh.OpReplace, Path: "/update/max_in_flight", Value: 1}}},
+	}
+
+	manifest, err := bosh.NewManifest(manifestContextWithDetails(deploymentName, networkName, false, &details), nil)
+	Equal(t, err, nil)
+	Equal(t, strings.Contains(manifestYaml(t, manifest), "persistent_disk: 20000"), true)
+	Equal(t, strings.Contains(manifestYaml(t, manifest), "max_in_flight: 1"), true)
+
+	details.Ops = []bosh.Operation{{Type: bosh.OpRemove, Path: "/instance_groups/name=orderer"}}
+	_, err = bosh.NewManifest(manifestContextWithDetails(deploymentName, networkName, false, &details), nil)
+	NotEqual(t, err, nil)
+
+	// pbft N and f default to the peers after applying ops files
+	details.Ops = []bosh.Operation{{Type: bosh.OpReplace, Path: "/instance_groups/name=peer/instances", Value: 7}}
+	manifest, err = bosh.NewManifest(manifestContextWithDetails(deploymentName, networkName, false, &details), nil)
+	Equal(t, err, nil)
+	Equal(t, strings.Contains(manifestYaml(t, manifest), `"n": 7`), true)
+	Equal(t, strings.Contains(manifestYaml(t, manifest), " f: 2\n"), true)
+
+	// pbft N given for the plan must match the peers after applying ops files
+	details.Plans = bosh.PlanConfigs{
+		"plan-1": bosh.PlanConfig{Consensus: &bosh.ConsensusProperties{PBFT: &bosh.PBFTSettings{N: 4}}},
+	}
+	_, err = bosh.NewManifest(manifestContextWithDetails(deploymentName, networkName, false, &details), nil)
+	Equal(t, bosh.IsManifestError(err), true)
+	Equal(t, err.Error(), "Manifest after applying ops files is invalid: Invalid consensus settings of peers: pbft N is 4 but deployment has 7 peers")
+}
+
+func TestNewManifest_OpsOnMemberServiceUsers(t *testing.T) {
+	clients := "/instance_groups/name=membersrvc/jobs/name=member_service/properties/membersrvc/clients"
+	peerClients := "/instance_groups/name=peer/jobs/name=peer/properties/membersrvc/clients"
+	details := *boshDetails
+	details.Ops = []bosh.Operation{}
+	for _, path := range []string{clients, peerClients} {
+		details.Ops = append(details.Ops,
+			bosh.Operation{Type: bosh.OpReplace, Path: path + "/name=admin/secret", Value: "fixed-secret"},
+			bosh.Operation{Type: bosh.OpReplace, Path: path + "/-", Value: map[string]interface{}{"name": "carol", "secret": "carol-secret"}},
+		)
+	}
+
+	manifest, err := bosh.NewManifest(manifestContextWithDetails(deploymentName, networkName, true, &details), nil)
+	Equal(t, err, nil)
+	secrets := manifest.Secrets()
+	Equal(t, secrets["admin"], "fixed-secret")
+	Equal(t, secrets["carol"], "carol-secret")
+	names := manifest.MemberServiceClientNames()
+	Equal(t, names[len(names)-1], "carol")
+
+	// Secrets are reused on next generation and ops still apply
+	manifest, err = bosh.NewManifest(manifestContextWithDetails(deploymentName, networkName, true, &details), secrets)
+	Equal(t, err, nil)
+	Equal(t, manifest.Secrets(), secrets)
+
+	details.Ops = []bosh.Operation{{Type: bosh.OpReplace, Path: clients + "/-", Value: map[string]interface{}{"name": "carol"}}}
+	_, err = bosh.NewManifest(manifestContextWithDetails(deploymentName, networkName, true, &details), nil)
+	Equal(t, bosh.IsManifestError(err), true)
+	Equal(t, err.Error(), "Membership service user carol has no secret after applying ops files")
+
+	// Membership service and peers would not agree on the secret
+	details.Ops = []bosh.Operation{{Type: bosh.OpReplace, Path: clients + "/name=admin/secret", Value: "fixed-secret"}}
+	_, err = bosh.NewManifest(manifestContextWithDetails(deploymentName, networkName, true, &details), nil)
+	Equal(t, bosh.IsManifestError(err), true)
+	Equal(t, err.Error(), "Membership service user admin has different secrets in different jobs")
+}
+
+func TestLoadPlanConfigs_OpsFiles(t *testing.T) {
+	opsPath := writeTempFile(t, "- type: remove\n  path: /jobs/name=membersrvc\n")
+	defer os.Remove(opsPath)
+	path := writeTempFile(t, "plan-1:\n  ops_files: ["+filepath.Base(opsPath)+"]\n")
+	defer os.Remove(path)
+
+	planConfigs, err := bosh.LoadPlanConfigs(path)
+	Equal(t, err, nil)
+	Equal(t, len(planConfigs["plan-1"].Ops), 1)
+	Equal(t, planConfigs["plan-1"].Ops[0].Path, "/jobs/name=membersrvc")
+}
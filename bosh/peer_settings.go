@@ -0,0 +1,296 @@
+package bosh
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Provision parameter overriding peer settings of plan, e.g.
+// {"peer": {"logging": {"chaincode": "debug"}}}. Only settings allowed by
+// PlanConfig.PeerParameters can be given.
+const peerParameter = "peer"
+
+// Settings of peers that provision parameters can override unless the plan
+// allows others
+var defaultPeerParameters = []string{"logging"}
+
+// Log levels of fabric peers
+var logLevels = []string{"critical", "error", "warning", "notice", "info", "debug"}
+
+// Settings of peers given in plan config or provision parameters, in the
+// notation of PlanConfig.PeerParameters
+var peerSettingKeys = []string{
+	"logging.level",
+	"logging.peer",
+	"logging.chaincode",
+	"logging.consensus",
+	"ports.grpc",
+	"ports.events",
+	"tls.enabled",
+	"chaincode.deploy_timeout",
+	"chaincode.startup_timeout",
+	"chaincode.execute_timeout",
+}
+
+// PeerSettings tune peers. Settings that are not set keep the defaults of
+// fabric-release.
+type PeerSettings struct {
+	Logging   *LoggingSettings   `yaml:"logging,omitempty"`
+	Ports     *PortSettings      `yaml:"ports,omitempty"`
+	TLS       *TLSSettings       `yaml:"tls,omitempty"`
+	Chaincode *ChaincodeSettings `yaml:"chaincode,omitempty"`
+}
+
+// LoggingSettings set the log level of all modules of the peer and of
+// single modules
+type LoggingSettings struct {
+	Level     string `yaml:"level,omitempty"`
+	Peer      string `yaml:"peer,omitempty"`
+	Chaincode string `yaml:"chaincode,omitempty"`
+	Consensus string `yaml:"consensus,omitempty"`
+}
+
+// PortSettings set ports of gRPC and event services. The REST port is not
+// configurable as the broker checks health of peers on it.
+type PortSettings struct {
+	Grpc   uint `yaml:"grpc,omitempty"`
+	Events uint `yaml:"events,omitempty"`
+}
+
+type TLSSettings struct {
+	Enabled *bool `yaml:"enabled,omitempty"`
+}
+
+// ChaincodeSettings set timeouts of chaincode containers as durations, e.g.
+// 30s
+type ChaincodeSettings struct {
+	DeployTimeout  string `yaml:"deploy_timeout,omitempty"`
+	StartupTimeout string `yaml:"startup_timeout,omitempty"`
+	ExecuteTimeout string `yaml:"execute_timeout,omitempty"`
+}
+
+// DockerSettings tune the docker daemon peers run chaincode in
+type DockerSettings struct {
+	RegistryMirrors []string `yaml:"registry_mirrors"`
+}
+
+// Validate checks log levels, ports and timeouts
+func (p *PeerSettings) Validate() error {
+	if p.Logging != nil {
+		for name, level := range map[string]string{
+			"level":     p.Logging.Level,
+			"peer":      p.Logging.Peer,
+			"chaincode": p.Logging.Chaincode,
+			"consensus": p.Logging.Consensus,
+		} {
+			if level != "" && !isLogLevel(level) {
+				return errors.New(fmt.Sprintf("logging %s %s is not a log level, must be one of %s", name, level, strings.Join(logLevels, ", ")))
+			}
+		}
+	}
+	if p.Ports != nil {
+		if p.Ports.Grpc > 65535 || p.Ports.Events > 65535 {
+			return errors.New("ports must be between 1 and 65535")
+		}
+		if p.Ports.Grpc != 0 && p.Ports.Grpc == p.Ports.Events {
+			return errors.New(fmt.Sprintf("ports of grpc and events are both %d", p.Ports.Grpc))
+		}
+	}
+	if p.Chaincode != nil {
+		for name, timeout := range map[string]string{
+			"deploy_timeout":  p.Chaincode.DeployTimeout,
+			"startup_timeout": p.Chaincode.StartupTimeout,
+			"execute_timeout": p.Chaincode.ExecuteTimeout,
+		} {
+			if timeout == "" {
+				continue
+			}
+			duration, err := time.ParseDuration(timeout)
+			if err != nil || duration <= 0 {
+				return errors.New(fmt.Sprintf("chaincode %s %s is not a positive duration, e.g. 30s", name, timeout))
+			}
+		}
+	}
+	return nil
+}
+
+func isLogLevel(level string) bool {
+	for _, logLevel := range logLevels {
+		if strings.EqualFold(level, logLevel) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sets settings given in other
+func (p *PeerSettings) override(other *PeerSettings) {
+	if other == nil {
+		return
+	}
+	if other.Logging != nil {
+		if p.Logging == nil {
+			p.Logging = &LoggingSettings{}
+		}
+		overrideString(&p.Logging.Level, other.Logging.Level)
+		overrideString(&p.Logging.Peer, other.Logging.Peer)
+		overrideString(&p.Logging.Chaincode, other.Logging.Chaincode)
+		overrideString(&p.Logging.Consensus, other.Logging.Consensus)
+	}
+	if other.Ports != nil {
+		if p.Ports == nil {
+			p.Ports = &PortSettings{}
+		}
+		if other.Ports.Grpc != 0 {
+			p.Ports.Grpc = other.Ports.Grpc
+		}
+		if other.Ports.Events != 0 {
+			p.Ports.Events = other.Ports.Events
+		}
+	}
+	if other.TLS != nil && other.TLS.Enabled != nil {
+		enabled := *other.TLS.Enabled
+		p.TLS = &TLSSettings{Enabled: &enabled}
+	}
+	if other.Chaincode != nil {
+		if p.Chaincode == nil {
+			p.Chaincode = &ChaincodeSettings{}
+		}
+		overrideString(&p.Chaincode.DeployTimeout, other.Chaincode.DeployTimeout)
+		overrideString(&p.Chaincode.StartupTimeout, other.Chaincode.StartupTimeout)
+		overrideString(&p.Chaincode.ExecuteTimeout, other.Chaincode.ExecuteTimeout)
+	}
+}
+
+func overrideString(value *string, other string) {
+	if other != "" {
+		*value = other
+	}
+}
+
+// Validate checks that registry mirrors are http or https URLs
+func (d *DockerSettings) Validate() error {
+	for _, mirror := range d.RegistryMirrors {
+		mirrorUrl, err := url.Parse(mirror)
+		if err != nil || (mirrorUrl.Scheme != "http" && mirrorUrl.Scheme != "https") || mirrorUrl.Host == "" {
+			return errors.New(fmt.Sprintf("registry mirror %s is not an http or https URL", mirror))
+		}
+	}
+	return nil
+}
+
+// Returns peer settings given in provision parameters or nil if there are
+// none. Settings not in allowed, or below one of them, are rejected.
+func peerSettingsFromParameters(parameters map[string]interface{}, allowed []string) (*PeerSettings, error) {
+	value, found := parameters[peerParameter]
+	if !found {
+		return nil, nil
+	}
+
+	values, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, &ParameterError{Parameter: peerParameter, Message: "must be an object"}
+	}
+	keys := []string{}
+	collectSettingKeys("", values, &keys)
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !containsSetting(peerSettingKeys, key) {
+			return nil, &ParameterError{Parameter: peerParameter, Message: fmt.Sprintf("unknown setting %s", key)}
+		}
+		if !containsSetting(allowed, key) {
+			return nil, &ParameterError{Parameter: peerParameter, Message: fmt.Sprintf("setting %s cannot be changed for this plan", key)}
+		}
+	}
+
+	data, err := yaml.Marshal(values)
+	if err != nil {
+		return nil, &ParameterError{Parameter: peerParameter, Message: err.Error()}
+	}
+	settings := PeerSettings{}
+	err = yaml.Unmarshal(data, &settings)
+	if err != nil {
+		return nil, &ParameterError{Parameter: peerParameter, Message: err.Error()}
+	}
+	return &settings, nil
+}
+
+// Returns true if key is a group of settings, e.g. logging
+func isSettingGroup(key string) bool {
+	for _, setting := range peerSettingKeys {
+		if strings.HasPrefix(setting, key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Appends dotted keys of all leaves of values to keys
+func collectSettingKeys(prefix string, values map[string]interface{}, keys *[]string) {
+	for name, value := range values {
+		key := prefix + name
+		nested, ok := value.(map[string]interface{})
+		if ok && len(nested) > 0 {
+			collectSettingKeys(key+".", nested, keys)
+		} else {
+			*keys = append(*keys, key)
+		}
+	}
+}
+
+// Returns true if key is one of settings or below one of them
+func containsSetting(settings []string, key string) bool {
+	for _, setting := range settings {
+		if key == setting || strings.HasPrefix(key, setting+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Applies peer and docker settings of plan and then peer settings of
+// provision parameters to the peer jobs
+func (m *Manifest) configurePeers(planConfig PlanConfig, parameters map[string]interface{}) error {
+	allowed := planConfig.PeerParameters
+	if allowed == nil {
+		allowed = defaultPeerParameters
+	}
+	parameterSettings, err := peerSettingsFromParameters(parameters, allowed)
+	if err != nil {
+		return err
+	}
+	if parameterSettings != nil {
+		err = parameterSettings.Validate()
+		if err != nil {
+			return &ParameterError{Parameter: peerParameter, Message: err.Error()}
+		}
+	}
+	err = planConfig.validatePeerSettings()
+	if err != nil {
+		return errors.New(fmt.Sprintf("Invalid config of plan: %s", err))
+	}
+
+	peer := m.JobProperties(peerJobName, peerJobName)
+	if peer != nil {
+		peer.Peer.PeerSettings.override(planConfig.Peer)
+		peer.Peer.PeerSettings.override(parameterSettings)
+	} else if planConfig.Peer != nil || parameterSettings != nil {
+		return errors.New("Manifest has no peer job to apply peer settings to")
+	}
+
+	if planConfig.Docker == nil || len(planConfig.Docker.RegistryMirrors) == 0 {
+		return nil
+	}
+	docker := m.JobProperties(peerJobName, dockerJobName)
+	if docker == nil {
+		return errors.New("Manifest has no docker job to apply docker settings to")
+	}
+	docker.Docker.RegistryMirrors = planConfig.Docker.RegistryMirrors
+	return nil
+}
@@ -0,0 +1,130 @@
+package bosh_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/predix/fabric-service-broker/bosh"
+
+	. "gopkg.in/go-playground/assert.v1"
+)
+
+func TestPeerSettings_Validate(t *testing.T) {
+	settings := bosh.PeerSettings{
+		Logging:   &bosh.LoggingSettings{Level: "INFO", Chaincode: "debug"},
+		Ports:     &bosh.PortSettings{Grpc: 7051, Events: 7053},
+		Chaincode: &bosh.ChaincodeSettings{DeployTimeout: "30s"},
+	}
+	Equal(t, settings.Validate(), nil)
+
+	settings.Logging.Peer = "verbose"
+	NotEqual(t, settings.Validate(), nil)
+
+	settings.Logging.Peer = ""
+	settings.Ports.Events = 7051
+	Equal(t, settings.Validate().Error(), "ports of grpc and events are both 7051")
+
+	settings.Ports.Events = 7053
+	settings.Chaincode.StartupTimeout = "-5s"
+	NotEqual(t, settings.Validate(), nil)
+
+	docker := bosh.DockerSettings{RegistryMirrors: []string{"https://mirror.example.com"}}
+	Equal(t, docker.Validate(), nil)
+	docker.RegistryMirrors = append(docker.RegistryMirrors, "mirror.example.com")
+	NotEqual(t, docker.Validate(), nil)
+}
+
+func TestNewManifest_PlanPeerSettings(t *testing.T) {
+	enabled := true
+	details := *boshDetails
+	details.Plans = bosh.PlanConfigs{
+		"plan-1": bosh.PlanConfig{
+			Peer: &bosh.PeerSettings{
+				Logging: &bosh.LoggingSettings{Level: "warning"},
+				Ports:   &bosh.PortSettings{Grpc: 7051},
+				TLS:     &bosh.TLSSettings{Enabled: &enabled},
+			},
+			Docker: &bosh.DockerSettings{RegistryMirrors: []string{"https://mirror.example.com"}},
+		},
+	}
+
+	manifest, err := bosh.NewManifest(manifestContextWithDetails(deploymentName, networkName, false, &details), nil)
+	Equal(t, err, nil)
+	peer := manifest.JobProperties("peer", "peer").Peer
+	Equal(t, *peer.Logging, bosh.LoggingSettings{Level: "warning"})
+	Equal(t, *peer.Ports, bosh.PortSettings{Grpc: 7051})
+	Equal(t, *peer.TLS.Enabled, true)
+	Equal(t, peer.Chaincode == nil, true)
+	Equal(t, manifest.JobProperties("peer", "docker").Docker.RegistryMirrors, []string{"https://mirror.example.com"})
+
+	yaml := manifestYaml(t, manifest)
+	Equal(t, strings.Contains(yaml, "logging:\n          level: warning\n"), true)
+	Equal(t, strings.Contains(yaml, "registry_mirrors:\n        - https://mirror.example.com\n"), true)
+}
+
+func TestNewManifest_PeerParameters(t *testing.T) {
+	details := *boshDetails
+	details.Plans = bosh.PlanConfigs{
+		"plan-1": bosh.PlanConfig{Peer: &bosh.PeerSettings{Logging: &bosh.LoggingSettings{Level: "warning"}}},
+	}
+	context := manifestContextWithDetails(deploymentName, networkName, false, &details)
+
+	// Logging can be changed unless the plan says otherwise
+	context.Parameters = map[string]interface{}{
+		"peer": map[string]interface{}{"logging": map[string]interface{}{"chaincode": "debug"}},
+	}
+	manifest, err := bosh.NewManifest(context, nil)
+	Equal(t, err, nil)
+	Equal(t, *manifest.JobProperties("peer", "peer").Peer.Logging, bosh.LoggingSettings{Level: "warning", Chaincode: "debug"})
+
+	context.Parameters = map[string]interface{}{
+		"peer": map[string]interface{}{"chaincode": map[string]interface{}{"deploy_timeout": "1m"}},
+	}
+	_, err = bosh.NewManifest(context, nil)
+	Equal(t, bosh.IsParameterError(err), true)
+	Equal(t, err.Error(), "Invalid parameter peer: setting chaincode.deploy_timeout cannot be changed for this plan")
+
+	context.Parameters = map[string]interface{}{
+		"peer": map[string]interface{}{"logging": map[string]interface{}{"peer": "loud"}},
+	}
+	_, err = bosh.NewManifest(context, nil)
+	Equal(t, bosh.IsParameterError(err), true)
+
+	context.Parameters = map[string]interface{}{
+		"peer": map[string]interface{}{"core": map[string]interface{}{"data_path": "/tmp"}},
+	}
+	_, err = bosh.NewManifest(context, nil)
+	Equal(t, err.Error(), "Invalid parameter peer: unknown setting core.data_path")
+
+	// Plan allows chaincode timeouts but not logging
+	details.Plans = bosh.PlanConfigs{"plan-1": bosh.PlanConfig{PeerParameters: []string{"chaincode.deploy_timeout"}}}
+	context.Parameters = map[string]interface{}{
+		"peer": map[string]interface{}{"chaincode": map[string]interface{}{"deploy_timeout": "1m"}},
+	}
+	manifest, err = bosh.NewManifest(context, nil)
+	Equal(t, err, nil)
+	Equal(t, manifest.JobProperties("peer", "peer").Peer.Chaincode.DeployTimeout, "1m")
+
+	context.Parameters = map[string]interface{}{
+		"peer": map[string]interface{}{"logging": map[string]interface{}{"level": "debug"}},
+	}
+	_, err = bosh.NewManifest(context, nil)
+	Equal(t, bosh.IsParameterError(err), true)
+}
+
+func TestLoadPlanConfigs_PeerSettings(t *testing.T) {
+	path := writeTempFile(t, "plan-1:\n  peer:\n    logging:\n      level: debug\n  peer_parameters: [logging, chaincode]\n  docker:\n    registry_mirrors: [\"https://mirror.example.com\"]\n")
+	defer os.Remove(path)
+
+	planConfigs, err := bosh.LoadPlanConfigs(path)
+	Equal(t, err, nil)
+	Equal(t, planConfigs["plan-1"].Peer.Logging.Level, "debug")
+	Equal(t, planConfigs["plan-1"].PeerParameters, []string{"logging", "chaincode"})
+	Equal(t, planConfigs["plan-1"].Docker.RegistryMirrors, []string{"https://mirror.example.com"})
+
+	invalidPath := writeTempFile(t, "plan-1:\n  peer_parameters: [core]\n")
+	defer os.Remove(invalidPath)
+	_, err = bosh.LoadPlanConfigs(invalidPath)
+	Equal(t, err.Error(), "Invalid config of plan plan-1: unknown peer setting core in peer_parameters")
+}
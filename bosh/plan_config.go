@@ -0,0 +1,107 @@
+package bosh
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+)
+
+// PlanConfig holds operator provided settings of a service plan
+type PlanConfig struct {
+	// Errand run after deployment of instance finishes. Provision succeeds
+	// only if the errand exits successfully.
+	PostDeployErrand string `yaml:"post_deploy_errand"`
+	// Labels a director must have to deploy instances of the plan when
+	// label-affinity placement is used
+	DirectorLabels []string `yaml:"director_labels"`
+	// Ops files applied to manifests of the plan after the global ones.
+	// Relative paths are relative to the plan config file.
+	OpsFiles []string `yaml:"ops_files"`
+	// Number of peers in built-in manifests. 0 keeps the default of 4.
+	Peers uint `yaml:"peers"`
+	// AZs of all instance groups overriding the ones of the director.
+	// Provision parameters can override them in turn.
+	AZs []string `yaml:"azs"`
+	// Reject provisions where an AZ hosts more than f validating peers
+	// instead of logging a warning
+	RequireAZFaultTolerance bool `yaml:"require_az_fault_tolerance"`
+	// Consensus settings overriding the ones of the manifest. Provision
+	// parameters can override them in turn.
+	Consensus *ConsensusProperties `yaml:"consensus"`
+	// Settings of peers overriding the ones of the manifest
+	Peer *PeerSettings `yaml:"peer"`
+	// Settings of peers provision parameters can override, e.g. logging or
+	// chaincode.deploy_timeout. Defaults to logging, an empty list allows
+	// none.
+	PeerParameters []string `yaml:"peer_parameters"`
+	// Settings of docker daemons of peers overriding the ones of the manifest
+	Docker *DockerSettings `yaml:"docker"`
+
+	// Operations loaded from OpsFiles
+	Ops []Operation `yaml:"-"`
+}
+
+// PlanConfigs maps plan id to its settings
+type PlanConfigs map[string]PlanConfig
+
+// LoadPlanConfigs reads plan settings from YAML file keyed by plan id
+func LoadPlanConfigs(path string) (PlanConfigs, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Error("Error reading plan config file", err)
+		return nil, err
+	}
+
+	planConfigs := PlanConfigs{}
+	err = yaml.Unmarshal(data, &planConfigs)
+	if err != nil {
+		log.Error("Error unmarshalling plan config file", err)
+		return nil, err
+	}
+
+	for planId, planConfig := range planConfigs {
+		paths := []string{}
+		for _, opsFile := range planConfig.OpsFiles {
+			if !filepath.IsAbs(opsFile) {
+				opsFile = filepath.Join(filepath.Dir(path), opsFile)
+			}
+			paths = append(paths, opsFile)
+		}
+		planConfig.Ops, err = LoadOpsFiles(paths)
+		if err != nil {
+			return nil, err
+		}
+		err = planConfig.validatePeerSettings()
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid config of plan %s: %s", planId, err))
+		}
+		planConfigs[planId] = planConfig
+	}
+	return planConfigs, nil
+}
+
+// Checks settings of peers and docker and that provision parameters can
+// only override known settings
+func (p *PlanConfig) validatePeerSettings() error {
+	for _, key := range p.PeerParameters {
+		if !containsSetting(peerSettingKeys, key) && !isSettingGroup(key) {
+			return errors.New(fmt.Sprintf("unknown peer setting %s in peer_parameters", key))
+		}
+	}
+	if p.Peer != nil {
+		err := p.Peer.Validate()
+		if err != nil {
+			return errors.New(fmt.Sprintf("peer settings: %s", err))
+		}
+	}
+	if p.Docker != nil {
+		err := p.Docker.Validate()
+		if err != nil {
+			return errors.New(fmt.Sprintf("docker settings: %s", err))
+		}
+	}
+	return nil
+}
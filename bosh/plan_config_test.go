@@ -0,0 +1,43 @@
+package bosh_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/predix/fabric-service-broker/bosh"
+
+	. "gopkg.in/go-playground/assert.v1"
+)
+
+const planConfigYaml = `
+15175506-D9F6-4CD8-AA1E-8F0AAFB99C07:
+  post_deploy_errand: smoke-tests
+`
+
+func writeTempFile(t *testing.T, content string) string {
+	file, err := ioutil.TempFile("", "fabric-broker-test")
+	Equal(t, err, nil)
+	defer file.Close()
+	_, err = file.WriteString(content)
+	Equal(t, err, nil)
+	return file.Name()
+}
+
+func TestLoadPlanConfigs(t *testing.T) {
+	path := writeTempFile(t, planConfigYaml)
+	defer os.Remove(path)
+
+	planConfigs, err := bosh.LoadPlanConfigs(path)
+	Equal(t, err, nil)
+
+	details := bosh.NewDetails(boshStemcell, boshUuid, vmType, networkNames, directorUrl, peerDataDir, dockerDataDir)
+	details.Plans = planConfigs
+	Equal(t, details.PlanConfig("15175506-D9F6-4CD8-AA1E-8F0AAFB99C07").PostDeployErrand, "smoke-tests")
+	Equal(t, details.PlanConfig("unknown-plan").PostDeployErrand, "")
+}
+
+func TestLoadPlanConfigs_MissingFile(t *testing.T) {
+	_, err := bosh.LoadPlanConfigs("/does/not/exist.yml")
+	NotEqual(t, err, nil)
+}
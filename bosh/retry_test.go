@@ -0,0 +1,76 @@
+package bosh_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/predix/fabric-service-broker/bosh"
+
+	. "gopkg.in/go-playground/assert.v1"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	retryPolicy := bosh.RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     2,
+	}
+	Equal(t, retryPolicy.Backoff(1), 100*time.Millisecond)
+	Equal(t, retryPolicy.Backoff(2), 200*time.Millisecond)
+	Equal(t, retryPolicy.Backoff(3), 400*time.Millisecond)
+	Equal(t, retryPolicy.Backoff(5), time.Second)
+	Equal(t, retryPolicy.Backoff(50), time.Second)
+}
+
+func TestRetryPolicy_BackoffJitter(t *testing.T) {
+	retryPolicy := bosh.RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     2,
+		Jitter:         0.5,
+	}
+	for i := 0; i < 100; i++ {
+		backoff := retryPolicy.Backoff(2)
+		Equal(t, backoff >= 100*time.Millisecond, true)
+		Equal(t, backoff <= 300*time.Millisecond, true)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	circuitBreaker := bosh.NewCircuitBreaker(2, 50*time.Millisecond)
+	Equal(t, circuitBreaker.Allow(), true)
+
+	circuitBreaker.RecordFailure()
+	Equal(t, circuitBreaker.Allow(), true)
+	circuitBreaker.RecordFailure()
+	Equal(t, circuitBreaker.Allow(), false)
+
+	time.Sleep(60 * time.Millisecond)
+	// Single trial call allowed once reset timeout has passed
+	Equal(t, circuitBreaker.Allow(), true)
+	Equal(t, circuitBreaker.Allow(), false)
+
+	circuitBreaker.RecordSuccess()
+	Equal(t, circuitBreaker.Allow(), true)
+}
+
+func TestCircuitBreaker_FailedTrial(t *testing.T) {
+	circuitBreaker := bosh.NewCircuitBreaker(1, 50*time.Millisecond)
+	circuitBreaker.RecordFailure()
+	Equal(t, circuitBreaker.Allow(), false)
+
+	time.Sleep(60 * time.Millisecond)
+	Equal(t, circuitBreaker.Allow(), true)
+	circuitBreaker.RecordFailure()
+	Equal(t, circuitBreaker.Allow(), false)
+}
+
+func TestCircuitBreaker_Disabled(t *testing.T) {
+	circuitBreaker := bosh.NewCircuitBreaker(0, time.Second)
+	for i := 0; i < 10; i++ {
+		circuitBreaker.RecordFailure()
+	}
+	Equal(t, circuitBreaker.Allow(), true)
+}
@@ -0,0 +1,71 @@
+package bosh
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const (
+	secretLength   = 20
+	secretAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+// Secrets maps name of membership service user to its secret
+type Secrets map[string]string
+
+// ParseSecrets decodes secrets as stored with the service instance. Empty
+// data results in no secrets.
+func ParseSecrets(data string) (Secrets, error) {
+	secrets := Secrets{}
+	if data == "" {
+		return secrets, nil
+	}
+	err := json.Unmarshal([]byte(data), &secrets)
+	if err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+// Encode returns secrets in the form stored with the service instance
+func (s Secrets) Encode() (string, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// SecretVariableName returns name of config server variable holding the
+// secret of membership service user
+func SecretVariableName(userName string) string {
+	return fmt.Sprintf("membersrvc_%s_secret", userName)
+}
+
+// SecretVariablePath returns full name of the variable in the config server
+// of director with given name
+func SecretVariablePath(directorName, deploymentName, userName string) string {
+	return fmt.Sprintf("/%s/%s/%s", directorName, deploymentName, SecretVariableName(userName))
+}
+
+// IsPlaceholder returns true if value references a config server variable
+func IsPlaceholder(value string) bool {
+	return strings.HasPrefix(value, "((") && strings.HasSuffix(value, "))")
+}
+
+// GenerateSecret returns a cryptographically random alphanumeric secret
+func GenerateSecret() (string, error) {
+	secret := make([]byte, secretLength)
+	max := big.NewInt(int64(len(secretAlphabet)))
+	for i := range secret {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		secret[i] = secretAlphabet[n.Int64()]
+	}
+	return string(secret), nil
+}
@@ -1,15 +1,200 @@
 package bosh
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// TaskState is the state of a BOSH task. A task starts as queued, moves to
+// processing and ends up in one of the terminal states done, error, timeout
+// or cancelled. A cancel request moves a running task to cancelling first.
+type TaskState string
+
 const (
-	BoshStateProcessing = "processing"
-	BoshStateQueued     = "queued"
-	BoshStateDone       = "done"
+	BoshStateQueued     TaskState = "queued"
+	BoshStateProcessing TaskState = "processing"
+	BoshStateCancelling TaskState = "cancelling"
+	BoshStateCancelled  TaskState = "cancelled"
+	BoshStateDone       TaskState = "done"
+	BoshStateError      TaskState = "error"
+	BoshStateTimeout    TaskState = "timeout"
+)
+
+// IsTerminal returns true if the task will not change its state anymore.
+// States not known to the broker are treated as terminal so that an
+// instance never gets stuck waiting on a task that will not progress.
+func (s TaskState) IsTerminal() bool {
+	switch s {
+	case BoshStateQueued, BoshStateProcessing, BoshStateCancelling:
+		return false
+	}
+	return true
+}
+
+// IsSuccess returns true if the task finished successfully
+func (s TaskState) IsSuccess() bool {
+	return s == BoshStateDone
+}
+
+const (
+	TaskOutputEvent  = "event"
+	TaskOutputDebug  = "debug"
+	TaskOutputResult = "result"
 )
 
 type Task struct {
-	Id          int    `json:"id"`
-	State       string `json:"state"`
-	Description string `json:"description"`
-	Result      string `json:"result"`
-	User        string `json:"user"`
+	Id          int       `json:"id"`
+	State       TaskState `json:"state"`
+	Description string    `json:"description"`
+	Result      string    `json:"result"`
+	User        string    `json:"user"`
+	Deployment  string    `json:"deployment"`
+}
+
+type TaskEventError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// TaskEvent is a single line of the event output of a BOSH task
+type TaskEvent struct {
+	Time     int64           `json:"time"`
+	Stage    string          `json:"stage"`
+	Tags     []string        `json:"tags"`
+	Total    int             `json:"total"`
+	Task     string          `json:"task"`
+	Index    int             `json:"index"`
+	State    string          `json:"state"`
+	Progress int             `json:"progress"`
+	Error    *TaskEventError `json:"error"`
+}
+
+type TaskEvents []TaskEvent
+
+// ParseTaskEvents parses newline separated JSON events as returned by
+// /tasks/:id/output?type=event. Lines that cannot be parsed are skipped.
+func ParseTaskEvents(data []byte) TaskEvents {
+	events := TaskEvents{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		event := TaskEvent{}
+		err := json.Unmarshal([]byte(line), &event)
+		if err != nil {
+			log.Debugf("Skipping unparsable task event: %s", line)
+			continue
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// CurrentStage returns the most recent stage event or nil if no stage has
+// started yet
+func (e TaskEvents) CurrentStage() *TaskEvent {
+	for i := len(e) - 1; i >= 0; i-- {
+		if e[i].Stage != "" {
+			return &e[i]
+		}
+	}
+	return nil
+}
+
+// Progress returns percentage of completed steps in the current run of the
+// current stage. Stages are told apart by their tags, e.g. updating membersrvc
+// and updating peer, and a stage starting again at its first step, e.g. after
+// canaries, starts a new run.
+func (e TaskEvents) Progress() int {
+	current := e.CurrentStage()
+	if current == nil || current.Total == 0 {
+		return 0
+	}
+
+	finished := 0
+	for _, event := range e {
+		if !event.sameStage(current) {
+			continue
+		}
+		if event.Index == 1 && event.State == "started" {
+			finished = 0
+		}
+		if event.State == "finished" {
+			finished++
+		}
+	}
+	if finished > current.Total {
+		finished = current.Total
+	}
+	return finished * 100 / current.Total
+}
+
+func (e TaskEvent) sameStage(other *TaskEvent) bool {
+	return e.Stage == other.Stage && strings.Join(e.Tags, ",") == strings.Join(other.Tags, ",")
+}
+
+// ErrorMessage returns the last error reported in the events or an empty string if
+// no error was reported
+func (e TaskEvents) ErrorMessage() string {
+	for i := len(e) - 1; i >= 0; i-- {
+		if e[i].Error != nil {
+			return e[i].Error.Message
+		}
+		if e[i].State == "failed" && e[i].Stage != "" {
+			return fmt.Sprintf("%s: %s failed", e[i].Stage, e[i].Task)
+		}
+	}
+	return ""
+}
+
+// StageDescription returns a human readable description of the current stage
+// including tags and progress, e.g. "Updating instance peer (50%)"
+func (e TaskEvents) StageDescription() string {
+	current := e.CurrentStage()
+	if current == nil {
+		return ""
+	}
+
+	description := current.Stage
+	if len(current.Tags) > 0 {
+		description = fmt.Sprintf("%s %s", description, strings.Join(current.Tags, ", "))
+	}
+	if current.Total > 0 {
+		description = fmt.Sprintf("%s (%d%%)", description, e.Progress())
+	}
+	return description
+}
+
+// ErrandResult is the result output of an errand run task
+type ErrandResult struct {
+	ExitCode int    `json:"exit_code"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+}
+
+// ParseErrandResult parses result output of errand run task. Directors
+// running errand on multiple instances report one result per line, the
+// first non zero exit code among them is returned.
+func ParseErrandResult(data []byte) (*ErrandResult, error) {
+	var errandResult *ErrandResult
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		result := ErrandResult{}
+		err := json.Unmarshal([]byte(line), &result)
+		if err != nil {
+			log.Error("Error in unmarshaling errand result", err)
+			return nil, err
+		}
+		if errandResult == nil || (errandResult.ExitCode == 0 && result.ExitCode != 0) {
+			errandResult = &result
+		}
+	}
+	if errandResult == nil {
+		return nil, errors.New("No result in errand output")
+	}
+	return errandResult, nil
 }
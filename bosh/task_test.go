@@ -0,0 +1,116 @@
+package bosh_test
+
+import (
+	"testing"
+
+	"github.com/predix/fabric-service-broker/bosh"
+
+	. "gopkg.in/go-playground/assert.v1"
+)
+
+const taskEventOutput = `
+{"time":1470000000,"stage":"Preparing deployment","tags":[],"total":1,"task":"Preparing deployment","index":1,"state":"started","progress":0}
+{"time":1470000001,"stage":"Preparing deployment","tags":[],"total":1,"task":"Preparing deployment","index":1,"state":"finished","progress":100}
+not a json line
+{"time":1470000002,"stage":"Updating instance","tags":["peer"],"total":4,"task":"peer/0 (canary)","index":1,"state":"started","progress":0}
+{"time":1470000003,"stage":"Updating instance","tags":["peer"],"total":4,"task":"peer/0 (canary)","index":1,"state":"finished","progress":100}
+{"time":1470000004,"stage":"Updating instance","tags":["peer"],"total":4,"task":"peer/1","index":2,"state":"started","progress":0}
+`
+
+const taskErrorEventOutput = `
+{"time":1470000002,"stage":"Updating instance","tags":["peer"],"total":4,"task":"peer/0 (canary)","index":1,"state":"started","progress":0}
+{"time":1470000003,"stage":"Updating instance","tags":["peer"],"total":4,"task":"peer/0 (canary)","index":1,"state":"failed","progress":100,"data":{"error":"timeout"}}
+{"time":1470000004,"error":{"code":400007,"message":"'peer/0' is not running after update"}}
+`
+
+func TestParseTaskEvents(t *testing.T) {
+	events := bosh.ParseTaskEvents([]byte(taskEventOutput))
+	Equal(t, len(events), 5)
+
+	current := events.CurrentStage()
+	NotEqual(t, current, nil)
+	Equal(t, current.Stage, "Updating instance")
+	Equal(t, events.Progress(), 25)
+	Equal(t, events.StageDescription(), "Updating instance peer (25%)")
+	Equal(t, events.ErrorMessage(), "")
+}
+
+func TestParseTaskEvents_Error(t *testing.T) {
+	events := bosh.ParseTaskEvents([]byte(taskErrorEventOutput))
+	Equal(t, len(events), 3)
+	Equal(t, events.ErrorMessage(), "'peer/0' is not running after update")
+}
+
+func TestParseTaskEvents_Empty(t *testing.T) {
+	events := bosh.ParseTaskEvents([]byte(""))
+	Equal(t, len(events), 0)
+	Equal(t, events.CurrentStage() == nil, true)
+	Equal(t, events.StageDescription(), "")
+	Equal(t, events.Progress(), 0)
+}
+
+func TestTaskEvents_ProgressOfRepeatedStage(t *testing.T) {
+	// Stage of another instance group with the same name and a second run of
+	// the peer stage after canaries
+	output := `
+{"time":1470000002,"stage":"Updating instance","tags":["membersrvc"],"total":1,"task":"membersrvc/0","index":1,"state":"started","progress":0}
+{"time":1470000003,"stage":"Updating instance","tags":["membersrvc"],"total":1,"task":"membersrvc/0","index":1,"state":"finished","progress":100}
+{"time":1470000004,"stage":"Updating instance","tags":["peer"],"total":2,"task":"peer/0 (canary)","index":1,"state":"started","progress":0}
+{"time":1470000005,"stage":"Updating instance","tags":["peer"],"total":2,"task":"peer/0 (canary)","index":1,"state":"finished","progress":100}
+{"time":1470000006,"stage":"Updating instance","tags":["peer"],"total":2,"task":"peer/1","index":2,"state":"started","progress":0}
+{"time":1470000007,"stage":"Updating instance","tags":["peer"],"total":2,"task":"peer/1","index":2,"state":"finished","progress":100}
+{"time":1470000008,"stage":"Updating instance","tags":["peer"],"total":2,"task":"peer/0","index":1,"state":"started","progress":0}
+`
+	events := bosh.ParseTaskEvents([]byte(output))
+	Equal(t, events.Progress(), 0)
+	Equal(t, events.StageDescription(), "Updating instance peer (0%)")
+
+	// Duplicated finished events do not go past 100%
+	events = bosh.ParseTaskEvents([]byte(output + `
+{"time":1470000009,"stage":"Updating instance","tags":["peer"],"total":2,"task":"peer/0","index":1,"state":"finished","progress":100}
+{"time":1470000009,"stage":"Updating instance","tags":["peer"],"total":2,"task":"peer/0","index":1,"state":"finished","progress":100}
+{"time":1470000010,"stage":"Updating instance","tags":["peer"],"total":2,"task":"peer/1","index":2,"state":"finished","progress":100}
+`))
+	Equal(t, events.Progress(), 100)
+}
+
+func TestTaskState_IsTerminal(t *testing.T) {
+	Equal(t, bosh.BoshStateQueued.IsTerminal(), false)
+	Equal(t, bosh.BoshStateProcessing.IsTerminal(), false)
+	Equal(t, bosh.BoshStateCancelling.IsTerminal(), false)
+	Equal(t, bosh.BoshStateCancelled.IsTerminal(), true)
+	Equal(t, bosh.BoshStateDone.IsTerminal(), true)
+	Equal(t, bosh.BoshStateError.IsTerminal(), true)
+	Equal(t, bosh.BoshStateTimeout.IsTerminal(), true)
+	Equal(t, bosh.TaskState("unknown").IsTerminal(), true)
+}
+
+func TestTaskState_IsSuccess(t *testing.T) {
+	Equal(t, bosh.BoshStateDone.IsSuccess(), true)
+	Equal(t, bosh.BoshStateProcessing.IsSuccess(), false)
+	Equal(t, bosh.BoshStateCancelled.IsSuccess(), false)
+	Equal(t, bosh.BoshStateError.IsSuccess(), false)
+	Equal(t, bosh.BoshStateTimeout.IsSuccess(), false)
+}
+
+func TestParseErrandResult(t *testing.T) {
+	result, err := bosh.ParseErrandResult([]byte(`{"exit_code":0,"stdout":"ok","stderr":""}`))
+	Equal(t, err, nil)
+	Equal(t, result.ExitCode, 0)
+	Equal(t, result.Stdout, "ok")
+}
+
+func TestParseErrandResult_MultipleInstances(t *testing.T) {
+	output := `{"exit_code":0,"stdout":"ok","stderr":""}
+{"exit_code":1,"stdout":"","stderr":"chaincode invoke timed out"}
+`
+	result, err := bosh.ParseErrandResult([]byte(output))
+	Equal(t, err, nil)
+	Equal(t, result.ExitCode, 1)
+	Equal(t, result.Stderr, "chaincode invoke timed out")
+}
+
+func TestParseErrandResult_Empty(t *testing.T) {
+	_, err := bosh.ParseErrandResult([]byte(""))
+	NotEqual(t, err, nil)
+}
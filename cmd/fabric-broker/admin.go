@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const adminCommand = "admin"
+
+const adminUsage = `Usage: fabric-broker admin [options] <action> <service instance id>
+
+Actions:
+  health      Show health of service instance
+  start       Start VMs of service instance
+  stop        Stop VMs of service instance
+  restart     Restart VMs of service instance
+  recreate    Recreate VMs of service instance
+  suspend     Stop all VMs of service instance keeping persistent disks
+  resume      Start all VMs of a suspended service instance
+
+Options:
+`
+
+// Runs admin subcommand against admin endpoints of a running broker and
+// returns the exit code
+func runAdminCommand(args []string) int {
+	flags := flag.NewFlagSet(adminCommand, flag.ContinueOnError)
+	brokerUrl := flags.String("brokerUrl", "http://localhost:"+defaultPort, "Url of the service broker")
+	username := flags.String("adminUsername", os.Getenv("ADMIN_USERNAME"), "Username for admin endpoints")
+	password := flags.String("adminPassword", os.Getenv("ADMIN_PASSWORD"), "Password for admin endpoints")
+	job := flags.String("job", "", "Restrict action to VMs of this job")
+	instance := flags.String("instance", "", "Restrict action to this instance (index or id) of the job")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, adminUsage)
+		flags.PrintDefaults()
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+	action := flags.Arg(0)
+	instanceId := flags.Arg(1)
+
+	method := "POST"
+	path := fmt.Sprintf("/admin/service_instances/%s/%s", url.PathEscape(instanceId), url.PathEscape(action))
+	if action == "health" {
+		method = "GET"
+	}
+
+	query := url.Values{}
+	if *job != "" {
+		query.Set("job", *job)
+	}
+	if *instance != "" {
+		query.Set("instance", *instance)
+	}
+	requestUrl := strings.TrimRight(*brokerUrl, "/") + path
+	if len(query) > 0 {
+		requestUrl = requestUrl + "?" + query.Encode()
+	}
+
+	request, err := http.NewRequest(method, requestUrl, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error in creating http request:", err)
+		return 1
+	}
+	request.SetBasicAuth(*username, *password)
+
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error in connecting to service broker:", err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading response:", err)
+		return 1
+	}
+	fmt.Println(strings.TrimSpace(string(body)))
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		fmt.Fprintf(os.Stderr, "Request failed with status %d\n", resp.StatusCode)
+		return 1
+	}
+	return 0
+}
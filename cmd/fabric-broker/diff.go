@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Lines of unchanged text shown around changes
+const diffContext = 3
+
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// Returns difference of texts a and b line by line in unified diff format
+// without file headers, or an empty string if they are equal
+func diffLines(a, b string) string {
+	lines := editScript(splitLines(a), splitLines(b))
+
+	var buffer bytes.Buffer
+	for start := 0; start < len(lines); {
+		// Find next change and extend hunk until changes are further apart
+		// than twice the context
+		first := start
+		for first < len(lines) && lines[first].op == ' ' {
+			first++
+		}
+		if first == len(lines) {
+			break
+		}
+		last := first
+		for i := first; i < len(lines) && i <= last+2*diffContext; i++ {
+			if lines[i].op != ' ' {
+				last = i
+			}
+		}
+		from := maxInt(first-diffContext, start)
+		to := minInt(last+diffContext+1, len(lines))
+
+		aLine, bLine := 1, 1
+		for _, line := range lines[:from] {
+			if line.op != '+' {
+				aLine++
+			}
+			if line.op != '-' {
+				bLine++
+			}
+		}
+		aCount, bCount := 0, 0
+		for _, line := range lines[from:to] {
+			if line.op != '+' {
+				aCount++
+			}
+			if line.op != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&buffer, "@@ -%d,%d +%d,%d @@\n", aLine, aCount, bLine, bCount)
+		for _, line := range lines[from:to] {
+			fmt.Fprintf(&buffer, "%c%s\n", line.op, line.text)
+		}
+		start = to
+	}
+	return buffer.String()
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// Returns lines of a and b marked as unchanged, removed or added based on
+// their longest common subsequence
+func editScript(a, b []string) []diffLine {
+	// common[i][j] is length of longest common subsequence of a[i:] and b[j:]
+	common := make([][]int, len(a)+1)
+	for i := range common {
+		common[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = maxInt(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+
+	lines := []diffLine{}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i]})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && common[i+1][j] >= common[i][j+1]):
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	return lines
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	. "gopkg.in/go-playground/assert.v1"
+)
+
+// Returns lines 1 to n, each followed by a line break
+func numberedLines(n int, replace map[int]string) string {
+	lines := []string{}
+	for i := 1; i <= n; i++ {
+		line, found := replace[i]
+		if !found {
+			line = strings.Repeat("x", i)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		diff string
+	}{
+		{"equal", "a\nb\n", "a\nb\n", ""},
+		{"both empty", "", "", ""},
+		{"insert", "a\nb\n", "a\nc\nb\n", "@@ -1,2 +1,3 @@\n a\n+c\n b\n"},
+		{"insert into empty", "", "a\n", "@@ -1,0 +1,1 @@\n+a\n"},
+		{"delete", "a\nb\nc\n", "a\nc\n", "@@ -1,3 +1,2 @@\n a\n-b\n c\n"},
+		{"replace", "a\nb\nc\n", "a\nd\nc\n", "@@ -1,3 +1,3 @@\n a\n-b\n+d\n c\n"},
+		{
+			"context is limited",
+			numberedLines(10, nil),
+			numberedLines(10, map[int]string{5: "five"}),
+			"@@ -2,7 +2,7 @@\n xx\n xxx\n xxxx\n-xxxxx\n+five\n xxxxxx\n xxxxxxx\n xxxxxxxx\n",
+		},
+		{
+			"close changes are merged into one hunk",
+			numberedLines(12, nil),
+			numberedLines(12, map[int]string{2: "two", 8: "eight"}),
+			"@@ -1,11 +1,11 @@\n x\n-xx\n+two\n xxx\n xxxx\n xxxxx\n xxxxxx\n xxxxxxx\n-xxxxxxxx\n+eight\n xxxxxxxxx\n xxxxxxxxxx\n xxxxxxxxxxx\n",
+		},
+		{
+			"distant changes get their own hunks",
+			numberedLines(12, nil),
+			numberedLines(12, map[int]string{1: "one", 12: "twelve"}),
+			"@@ -1,4 +1,4 @@\n-x\n+one\n xx\n xxx\n xxxx\n@@ -9,4 +9,4 @@\n xxxxxxxxx\n xxxxxxxxxx\n xxxxxxxxxxx\n-xxxxxxxxxxxx\n+twelve\n",
+		},
+	}
+
+	for _, test := range tests {
+		Equal(t, diffLines(test.a, test.b), test.diff)
+	}
+}
+
+func TestDiffLines_NoTrailingLineBreak(t *testing.T) {
+	Equal(t, diffLines("a\nb", "a\nb\n"), "")
+}
@@ -3,17 +3,23 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/cloudfoundry-community/go-cfenv"
 	"github.com/gorilla/mux"
 	"github.com/op/go-logging"
 	"github.com/predix/fabric-service-broker/bosh"
+	"github.com/predix/fabric-service-broker/credhub"
 	"github.com/predix/fabric-service-broker/db"
+	"github.com/predix/fabric-service-broker/db/encryption"
 	"github.com/predix/fabric-service-broker/db/inmemory"
 	"github.com/predix/fabric-service-broker/db/postgres"
 	"github.com/predix/fabric-service-broker/handlers"
+	"github.com/predix/fabric-service-broker/health"
 
 	_ "github.com/jinzhu/gorm/dialects/postgres"
 )
@@ -29,6 +35,8 @@ const (
 	defaultPort          = "8999"
 	defaultPeerDataDir   = "/var/vcap/data/hyperledger/production"
 	defaultDockerDataDir = "/var/vcap/data/docker"
+	idleCheckInterval    = 10 * time.Minute
+	queueCheckInterval   = 15 * time.Second
 )
 
 var defaultBoshDirectorUrl = fmt.Sprintf("%s://%s:%s@%s:%d", defaultScheme, defaultBoshUsername, defaultBoshPassword, defaultBoshAddress, defaultBoshPort)
@@ -63,6 +71,36 @@ var boshNetworks = flag.String(
 	"Comma separated list of network names configured in cloud config",
 )
 
+var boshAZs = flag.String(
+	"boshAZs",
+	os.Getenv("BOSH_AZS"),
+	"Comma separated list of AZs configured in cloud config used for all instance groups. Built-in manifests use z1,z2 if not set",
+)
+
+var deploymentPrefix = flag.String(
+	"deploymentPrefix",
+	handlers.DefaultDeploymentPrefix,
+	"Prefix of names of BOSH deployments of new instances",
+)
+
+var brokerId = flag.String(
+	"brokerId",
+	os.Getenv("BROKER_ID"),
+	"Id of this broker added to names of BOSH deployments of new instances. Set different ids for brokers sharing a director",
+)
+
+var deploymentIdFormat = flag.String(
+	"deploymentIdFormat",
+	handlers.DeploymentIdFull,
+	"Format of service instance id in names of BOSH deployments of new instances: full, truncated or hashed",
+)
+
+var deploymentIdLength = flag.Int(
+	"deploymentIdLength",
+	0,
+	"Length of truncated or hashed service instance id in deployment names. 0 uses 8 for truncated and 12 for hashed ids",
+)
+
 var peerDataDir = flag.String(
 	"peerDataDir",
 	defaultPeerDataDir,
@@ -75,13 +113,182 @@ var dockerDataDir = flag.String(
 	"Data directory used by docker to store data files",
 )
 
+var planConfig = flag.String(
+	"planConfig",
+	os.Getenv("PLAN_CONFIG"),
+	"Path to YAML file with settings of service plans keyed by plan id",
+)
+
+var directorsConfig = flag.String(
+	"directorsConfig",
+	os.Getenv("DIRECTORS_CONFIG"),
+	"Path to YAML file listing BOSH directors to deploy to and placement strategy. Only the director given by boshDirectorUrl is used if not set",
+)
+
+var credhubUrl = flag.String(
+	"credhubUrl",
+	os.Getenv("CREDHUB_URL"),
+	"Url of CredHub used as config server by BOSH director. Secrets are generated by the broker and embedded in manifests if not set",
+)
+
+var credhubUaaUrl = flag.String(
+	"credhubUaaUrl",
+	os.Getenv("CREDHUB_UAA_URL"),
+	"Url of UAA issuing tokens for CredHub",
+)
+
+var credhubClientId = flag.String(
+	"credhubClientId",
+	os.Getenv("CREDHUB_CLIENT_ID"),
+	"UAA client with read access to credentials of deployments in CredHub",
+)
+
+var credhubClientSecret = flag.String(
+	"credhubClientSecret",
+	os.Getenv("CREDHUB_CLIENT_SECRET"),
+	"Secret of UAA client for CredHub",
+)
+
+var credhubCACertFile = flag.String(
+	"credhubCACertFile",
+	os.Getenv("CREDHUB_CA_CERT_FILE"),
+	"Path to PEM encoded certificates of CAs that sign certificates of CredHub and UAA. System CAs are used if not set",
+)
+
+var credhubSkipTLSVerification = flag.Bool(
+	"credhubSkipTLSVerification",
+	false,
+	"Do not verify TLS certificates of CredHub and UAA. Only meant for development",
+)
+
+var maxInFlightTasks = flag.Int(
+	"maxInFlightTasks",
+	0,
+	"Maximum number of BOSH tasks for deployments of this broker in flight at a time. Further provisions are queued. 0 disables queueing",
+)
+
+var manifestTemplateDir = flag.String(
+	"manifestTemplateDir",
+	os.Getenv("MANIFEST_TEMPLATE_DIR"),
+	"Directory with manifest templates named <plan id>.yml. Built-in manifests are used for plans without template",
+)
+
+var opsFiles = flag.String(
+	"opsFiles",
+	os.Getenv("OPS_FILES"),
+	"Comma separated paths of BOSH ops files applied to manifests of all plans. Plan specific ops files are applied afterwards",
+)
+
+var boshLegacyManifest = flag.Bool(
+	"boshLegacyManifest",
+	false,
+	"Deploy manifests in the deprecated schema with jobs and templates for directors that do not support instance groups",
+)
+
+var boshUseDnsAddresses = flag.Bool(
+	"boshUseDnsAddresses",
+	false,
+	"Enable use_dns_addresses feature in built-in manifests. Requires BOSH DNS on the director",
+)
+
+var boshRetryAttempts = flag.Int(
+	"boshRetryAttempts",
+	bosh.DefaultRetryPolicy().MaxAttempts,
+	"Maximum number of attempts for idempotent calls to BOSH director",
+)
+
+var boshRetryInitialBackoff = flag.Duration(
+	"boshRetryInitialBackoff",
+	bosh.DefaultRetryPolicy().InitialBackoff,
+	"Delay before first retry of a failed call to BOSH director. Delay doubles with every retry",
+)
+
+var boshRetryMaxBackoff = flag.Duration(
+	"boshRetryMaxBackoff",
+	bosh.DefaultRetryPolicy().MaxBackoff,
+	"Maximum delay between retries of a failed call to BOSH director",
+)
+
+var boshCircuitBreakerThreshold = flag.Int(
+	"boshCircuitBreakerThreshold",
+	5,
+	"Number of consecutive failed calls after which BOSH director is considered down. 0 disables circuit breaker",
+)
+
+var boshCircuitBreakerResetTimeout = flag.Duration(
+	"boshCircuitBreakerResetTimeout",
+	30*time.Second,
+	"Time to wait before calling BOSH director again once it is considered down",
+)
+
+var peerHealthCheck = flag.Bool(
+	"peerHealthCheck",
+	false,
+	"Ping REST endpoint of peers in addition to BOSH process state when computing instance health",
+)
+
+var peerHealthCheckTimeout = flag.Duration(
+	"peerHealthCheckTimeout",
+	2*time.Second,
+	"Timeout for ping of a peer REST endpoint",
+)
+
+var idleSuspendAfter = flag.Duration(
+	"idleSuspendAfter",
+	0,
+	"Suspend instances without bindings after they have been idle for this long. 0 disables suspending idle instances",
+)
+
+var adminUsername = flag.String(
+	"adminUsername",
+	os.Getenv("ADMIN_USERNAME"),
+	"Username for admin endpoints. Admin endpoints are disabled if not set",
+)
+
+var adminPassword = flag.String(
+	"adminPassword",
+	os.Getenv("ADMIN_PASSWORD"),
+	"Password for admin endpoints",
+)
+
 var dbUrl = flag.String(
 	"dbUrl",
 	os.Getenv("DB_CONNECTION_STRING"),
 	"Url for DB in DB specific format. E.g. for postgres it will be postgres://__username__:__password__@__hostname__:__port__/__database__",
 )
 
+var dbEncryptionKeys = flag.String(
+	"dbEncryptionKeys",
+	os.Getenv("DB_ENCRYPTION_KEYS"),
+	"Comma separated keys in format id:base64-encoded-32-byte-key used to encrypt sensitive data in DB. The first key is used for new data",
+)
+
+var dbEncryptionKeysFile = flag.String(
+	"dbEncryptionKeysFile",
+	os.Getenv("DB_ENCRYPTION_KEYS_FILE"),
+	"Path to file with keys used to encrypt sensitive data in DB, one key per line in format id:base64-encoded-32-byte-key",
+)
+
+var dbAllowUnencrypted = flag.Bool(
+	"dbAllowUnencrypted",
+	false,
+	"Allow starting without DB encryption keys, storing sensitive data unencrypted. Only meant for development",
+)
+
+var dbReencrypt = flag.Bool(
+	"dbReencrypt",
+	false,
+	"Re-encrypt sensitive data in DB with the first key on startup. Used to rotate encryption keys",
+)
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == adminCommand {
+		os.Exit(runAdminCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == manifestCommand {
+		os.Exit(runManifestCommand(os.Args[2:]))
+	}
+
 	flag.Parse()
 	log.Debug("Starting fabric service broker")
 
@@ -113,23 +320,42 @@ func main() {
 		repo = getPostgresRepo(connectionString)
 	}
 
-	boshDetails := getBoshDetails()
-	err := boshDetails.Validate()
+	repo = getEncryptedRepo(repo)
+
+	boshDetails, err := loadBoshDetails()
 	if err != nil {
-		log.Error("Environment not setup for bosh director use", err)
+		log.Error("Unable to load BOSH settings", err)
 		os.Exit(2)
 	}
 
-	boshClient := bosh.NewBoshHttpClient(boshDetails)
-	slHandler := handlers.NewServiceLifecycleHandler(repo, boshClient, boshDetails)
+	directors, placement := getDirectors(boshDetails)
+
+	var peerPinger health.Pinger
+	if *peerHealthCheck {
+		peerPinger = health.NewPeerRestPinger(health.DefaultPeerRestPort, *peerHealthCheckTimeout)
+	}
+	slHandler, err := handlers.NewServiceLifecycleHandler(repo, directors, placement, getDeploymentNaming(), peerPinger)
+	if err != nil {
+		log.Error("Unable to initialize service broker", err)
+		os.Exit(2)
+	}
+	slHandler.ReconcileDispatches()
+	if *idleSuspendAfter > 0 {
+		go slHandler.RunIdleSuspender(*idleSuspendAfter, idleCheckInterval)
+	}
+	if *maxInFlightTasks > 0 {
+		go slHandler.RunProvisionQueue(queueCheckInterval)
+	}
 
 	r := mux.NewRouter()
 	r.HandleFunc("/v2/catalog", handlers.CatalogHandler)
 	r.HandleFunc("/v2/service_instances/{instanceId}", slHandler.Provision).Methods("PUT")
+	r.HandleFunc("/v2/service_instances/{instanceId}", slHandler.GetInstance).Methods("GET")
 	r.HandleFunc("/v2/service_instances/{instanceId}", slHandler.Deprovision).Methods("DELETE")
 	r.HandleFunc("/v2/service_instances/{instanceId}/last_operation", slHandler.LastOperation)
 	r.HandleFunc("/v2/service_instances/{instanceId}/service_bindings/{bindingId}", slHandler.Bind).Methods("PUT")
 	r.HandleFunc("/v2/service_instances/{instanceId}/service_bindings/{bindingId}", slHandler.Unbind).Methods("DELETE")
+	registerAdminRoutes(r, slHandler)
 
 	var port string
 	port = os.Getenv("PORT")
@@ -140,6 +366,16 @@ func main() {
 	http.ListenAndServe(fmt.Sprintf(":%s", port), r)
 }
 
+func registerAdminRoutes(r *mux.Router, adminHandler handlers.AdminHandler) {
+	if *adminUsername == "" || *adminPassword == "" {
+		log.Info("No admin credentials specified, admin endpoints are disabled")
+		return
+	}
+
+	r.HandleFunc("/admin/service_instances/{instanceId}/health", handlers.BasicAuth(*adminUsername, *adminPassword, adminHandler.InstanceHealth)).Methods("GET")
+	r.HandleFunc("/admin/service_instances/{instanceId}/{action}", handlers.BasicAuth(*adminUsername, *adminPassword, adminHandler.ChangeInstanceState)).Methods("POST")
+}
+
 func getBoshDetails() *bosh.Details {
 	log.Info("Getting Bosh details from environment")
 	return bosh.NewDetails(
@@ -153,6 +389,152 @@ func getBoshDetails() *bosh.Details {
 	)
 }
 
+// Returns BOSH details from flags along with plan configs, manifest templates
+// and ops files they refer to
+func loadBoshDetails() (*bosh.Details, error) {
+	boshDetails := getBoshDetails()
+	boshDetails.MaxInFlightTasks = *maxInFlightTasks
+	boshDetails.LegacyManifest = *boshLegacyManifest
+	if *boshAZs != "" {
+		boshDetails.AZs = strings.Split(strings.Replace(*boshAZs, " ", "", -1), ",")
+	}
+	boshDetails.UseDNSAddresses = *boshUseDnsAddresses
+	boshDetails.Credhub = credhub.Details{
+		Url:                 *credhubUrl,
+		UaaUrl:              *credhubUaaUrl,
+		ClientId:            *credhubClientId,
+		ClientSecret:        *credhubClientSecret,
+		SkipTLSVerification: *credhubSkipTLSVerification,
+	}
+	if *credhubCACertFile != "" {
+		caCert, err := ioutil.ReadFile(*credhubCACertFile)
+		if err != nil {
+			return nil, err
+		}
+		boshDetails.Credhub.CACert = string(caCert)
+	}
+	if *planConfig != "" {
+		plans, err := bosh.LoadPlanConfigs(*planConfig)
+		if err != nil {
+			return nil, err
+		}
+		boshDetails.Plans = plans
+	}
+	if *manifestTemplateDir != "" {
+		templates, err := bosh.LoadManifestTemplates(*manifestTemplateDir)
+		if err != nil {
+			return nil, err
+		}
+		boshDetails.ManifestTemplates = templates
+	}
+	if *opsFiles != "" {
+		ops, err := bosh.LoadOpsFiles(strings.Split(*opsFiles, ","))
+		if err != nil {
+			return nil, err
+		}
+		boshDetails.Ops = ops
+	}
+	return boshDetails, nil
+}
+
+// Returns directors to deploy to along with strategy to place new instances
+// on them. Exits if the directors are not configured properly.
+func getDirectors(boshDetails *bosh.Details) ([]bosh.Director, handlers.PlacementStrategy) {
+	directorDetails := []*bosh.Details{boshDetails}
+	placementName := handlers.PlacementLeastLoaded
+
+	if *directorsConfig != "" {
+		config, err := bosh.LoadDirectorsConfig(*directorsConfig)
+		if err != nil {
+			log.Error("Unable to load directors config", err)
+			os.Exit(2)
+		}
+		directorDetails = []*bosh.Details{}
+		for _, directorConfig := range config.Directors {
+			directorDetails = append(directorDetails, directorConfig.Details(boshDetails))
+		}
+		placementName = config.Placement
+	}
+
+	placement, err := handlers.NewPlacementStrategy(placementName)
+	if err != nil {
+		log.Error("Invalid placement strategy", err)
+		os.Exit(2)
+	}
+
+	directors := []bosh.Director{}
+	for _, details := range directorDetails {
+		err := details.Validate()
+		if err != nil {
+			log.Errorf("Environment not setup for use of bosh director %s, %s", details.Name, err)
+			os.Exit(2)
+		}
+		circuitBreaker := bosh.NewCircuitBreaker(*boshCircuitBreakerThreshold, *boshCircuitBreakerResetTimeout)
+		director := bosh.Director{
+			Details: details,
+			Client:  bosh.NewBoshHttpClient(details, getRetryPolicy(), circuitBreaker),
+		}
+		if details.UseConfigServer() {
+			director.Credhub, err = credhub.NewHttpClient(details.Credhub)
+			if err != nil {
+				log.Errorf("Unable to create CredHub client for bosh director %s, %s", details.Name, err)
+				os.Exit(2)
+			}
+		}
+		directors = append(directors, director)
+	}
+	return directors, placement
+}
+
+func getDeploymentNaming() handlers.DeploymentNaming {
+	return handlers.DeploymentNaming{
+		Prefix:   *deploymentPrefix,
+		BrokerId: *brokerId,
+		IdFormat: *deploymentIdFormat,
+		IdLength: *deploymentIdLength,
+	}
+}
+
+func getRetryPolicy() bosh.RetryPolicy {
+	retryPolicy := bosh.DefaultRetryPolicy()
+	retryPolicy.MaxAttempts = *boshRetryAttempts
+	retryPolicy.InitialBackoff = *boshRetryInitialBackoff
+	retryPolicy.MaxBackoff = *boshRetryMaxBackoff
+	return retryPolicy
+}
+
+// Wraps repo to encrypt sensitive data if encryption keys are specified
+func getEncryptedRepo(repo db.ModelsRepo) db.ModelsRepo {
+	var keyring *encryption.Keyring
+	var err error
+	switch {
+	case *dbEncryptionKeysFile != "":
+		keyring, err = encryption.LoadKeyring(*dbEncryptionKeysFile)
+	case *dbEncryptionKeys != "":
+		keyring, err = encryption.ParseKeyring(*dbEncryptionKeys)
+	case *dbAllowUnencrypted:
+		log.Warning("No DB encryption keys specified, sensitive data is stored unencrypted")
+		return repo
+	default:
+		log.Error("No DB encryption keys specified. Use --dbEncryptionKeys or --dbEncryptionKeysFile, or --dbAllowUnencrypted to store sensitive data unencrypted")
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Error("Unable to load DB encryption keys", err)
+		os.Exit(1)
+	}
+
+	repo = encryption.NewRepo(repo, keyring)
+	if *dbReencrypt {
+		err = encryption.Reencrypt(repo)
+		if err != nil {
+			log.Error("Unable to re-encrypt DB", err)
+			os.Exit(1)
+		}
+	}
+	return repo
+}
+
 func getPostgresRepo(uri string) db.ModelsRepo {
 	repo, err := postgres.New(*dbUrl, true)
 	if err != nil {
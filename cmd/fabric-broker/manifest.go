@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/predix/fabric-service-broker/bosh"
+	"github.com/predix/fabric-service-broker/db/models"
+	"github.com/predix/fabric-service-broker/handlers"
+	"github.com/predix/fabric-service-broker/rest_models"
+)
+
+const manifestCommand = "manifest"
+
+const manifestUsage = `Usage: fabric-broker manifest [options] <plan id or name>
+
+Prints the manifest the broker would deploy for a new instance of the plan
+without connecting to BOSH director. With -diffPlan or -diffParameters
+prints the difference to the manifest of another plan or other parameters
+instead and exits with code 1 if the manifests differ.
+
+Secrets generated by the broker are shown as ` + redactedSecret + `.
+
+Options:
+`
+
+const redactedSecret = "REDACTED"
+
+// Flags of the broker that settings of manifests are taken from
+var manifestDetailsFlags = []string{
+	"boshDirectorUuid",
+	"boshStemcellName",
+	"boshVmType",
+	"boshNetworks",
+	"boshAZs",
+	"peerDataDir",
+	"dockerDataDir",
+	"planConfig",
+	"directorsConfig",
+	"manifestTemplateDir",
+	"opsFiles",
+	"credhubUrl",
+	"boshLegacyManifest",
+	"boshUseDnsAddresses",
+	"deploymentPrefix",
+	"brokerId",
+	"deploymentIdFormat",
+	"deploymentIdLength",
+}
+
+// Renders manifests offline and returns the exit code
+func runManifestCommand(args []string) int {
+	flags := flag.NewFlagSet(manifestCommand, flag.ContinueOnError)
+	parameters := flags.String("parameters", "", "Provision parameters as JSON object, e.g. '{\"azs\": [\"z1\", \"z2\"]}'")
+	diffPlan := flags.String("diffPlan", "", "Show difference to manifest of this plan")
+	diffParameters := flags.String("diffParameters", "", "Show difference to manifest for these provision parameters")
+	instanceId := flags.String("instanceId", "00000000-0000-0000-0000-000000000000", "Service instance id the manifest is rendered for")
+	networkName := flags.String("network", "", "Network of the deployment. Defaults to the first network of the director")
+	directorName := flags.String("director", "", "Director from directorsConfig the manifest is rendered for. Defaults to the first director")
+	for _, name := range manifestDetailsFlags {
+		f := flag.Lookup(name)
+		flags.Var(f.Value, f.Name, f.Usage)
+	}
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, manifestUsage)
+		flags.PrintDefaults()
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	err = getDeploymentNaming().Validate()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid deployment naming:", err)
+		return 2
+	}
+	details, err := loadManifestDetails(*directorName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to load BOSH settings:", err)
+		return 2
+	}
+	if *networkName == "" {
+		*networkName = details.NetworkNames[0]
+	}
+
+	manifest, err := renderManifest(details, flags.Arg(0), *parameters, *instanceId, *networkName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to render manifest:", err)
+		return 2
+	}
+	if *diffPlan == "" && *diffParameters == "" {
+		fmt.Print(manifest)
+		return 0
+	}
+
+	otherPlan := flags.Arg(0)
+	if *diffPlan != "" {
+		otherPlan = *diffPlan
+	}
+	otherParameters := *parameters
+	if *diffParameters != "" {
+		otherParameters = *diffParameters
+	}
+	otherManifest, err := renderManifest(details, otherPlan, otherParameters, *instanceId, *networkName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to render manifest to compare with:", err)
+		return 2
+	}
+
+	diff := diffLines(manifest, otherManifest)
+	if diff == "" {
+		return 0
+	}
+	fmt.Printf("--- %s\n+++ %s\n", strings.TrimSpace(flags.Arg(0)+" "+*parameters), strings.TrimSpace(otherPlan+" "+otherParameters))
+	fmt.Print(diff)
+	return 1
+}
+
+// Returns details of the named director, or of the first one if name is
+// empty, as the broker would use them
+func loadManifestDetails(directorName string) (*bosh.Details, error) {
+	details, err := loadBoshDetails()
+	if err != nil {
+		return nil, err
+	}
+	if *directorsConfig == "" {
+		return details, nil
+	}
+
+	config, err := bosh.LoadDirectorsConfig(*directorsConfig)
+	if err != nil {
+		return nil, err
+	}
+	for _, directorConfig := range config.Directors {
+		if directorName == "" || directorConfig.Name == directorName {
+			return directorConfig.Details(details), nil
+		}
+	}
+	return nil, errors.New(fmt.Sprintf("Director %s not found in %s", directorName, *directorsConfig))
+}
+
+// Renders manifest of a new instance of plan as YAML. Secrets generated by
+// the broker are redacted so output is stable across runs.
+func renderManifest(details *bosh.Details, plan, parameters, instanceId, networkName string) (string, error) {
+	planId, err := findPlanId(plan)
+	if err != nil {
+		return "", err
+	}
+
+	context := bosh.ManifestContext{
+		InstanceId:     instanceId,
+		PlanId:         planId,
+		DeploymentName: getDeploymentNaming().Name(instanceId),
+		NetworkName:    networkName,
+		Permissioned:   planId == rest_models.PermissionedPlanId,
+		Details:        details,
+		Parameters:     map[string]interface{}{},
+		Tags: handlers.DeploymentTags(&models.ServiceInstance{
+			BaseModel: models.BaseModel{Id: instanceId},
+			PlanId:    planId,
+		}),
+	}
+	if parameters != "" {
+		err = json.Unmarshal([]byte(parameters), &context.Parameters)
+		if err != nil {
+			return "", errors.New(fmt.Sprintf("Invalid parameters %s: %s", parameters, err))
+		}
+	}
+
+	manifest, err := bosh.NewManifest(context, nil)
+	if err != nil {
+		return "", err
+	}
+	secrets := manifest.Secrets()
+	if len(secrets) == 0 {
+		return manifest.String()
+	}
+	for user := range secrets {
+		secrets[user] = redactedSecret
+	}
+	manifest, err = bosh.NewManifest(context, secrets)
+	if err != nil {
+		return "", err
+	}
+	return manifest.String()
+}
+
+// Returns id of plan given by id or name in the catalog
+func findPlanId(plan string) (string, error) {
+	for _, catalogPlan := range rest_models.GetDefaultService().Plans {
+		if strings.EqualFold(catalogPlan.Id, plan) || catalogPlan.Name == plan {
+			return catalogPlan.Id, nil
+		}
+	}
+	return "", errors.New(fmt.Sprintf("Plan %s not found in catalog", plan))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	. "gopkg.in/go-playground/assert.v1"
+)
+
+// Runs the manifest command and returns its exit code and output
+func runManifest(t *testing.T, args ...string) (int, string) {
+	reader, writer, err := os.Pipe()
+	Equal(t, err, nil)
+	stdout := os.Stdout
+	os.Stdout = writer
+	defer func() { os.Stdout = stdout }()
+
+	output := make(chan string)
+	go func() {
+		data, _ := ioutil.ReadAll(reader)
+		output <- string(data)
+	}()
+	code := runManifestCommand(args)
+	writer.Close()
+	return code, <-output
+}
+
+func TestRunManifestCommand(t *testing.T) {
+	flags := []string{
+		"-boshDirectorUuid", "1f3c2a44-0000-0000-0000-000000000000",
+		"-boshStemcellName", "bosh-warden-boshlite-ubuntu-trusty-go_agent",
+		"-boshVmType", "small",
+		"-boshNetworks", "fabric-net",
+		"-instanceId", "2A98FB4C-B774-45BD-9D5B-7C427933F812",
+	}
+
+	code, manifest := runManifest(t, append(flags, "permissioned")...)
+	Equal(t, code, 0)
+	Equal(t, strings.HasPrefix(manifest, "name: fabric-2A98FB4C-B774-45BD-9D5B-7C427933F812\ndirector_uuid: 1f3c2a44-0000-0000-0000-000000000000\n"), true)
+	Equal(t, strings.Contains(manifest, "name: bosh-warden-boshlite-ubuntu-trusty-go_agent"), true)
+	Equal(t, strings.Contains(manifest, "- name: fabric-net"), true)
+	Equal(t, strings.Contains(manifest, "secret: REDACTED"), true)
+
+	// Rendering is stable, so only changed parameters show up in diff
+	parameters := `{"peer": {"logging": {"level": "debug"}}}`
+	code, diff := runManifest(t, append(flags, "-diffParameters", parameters, "permissioned")...)
+	Equal(t, code, 1)
+	Equal(t, strings.HasPrefix(diff, "--- permissioned\n+++ permissioned "+parameters+"\n@@ "), true)
+	Equal(t, strings.Contains(diff, "\n+        logging:\n+          level: debug\n"), true)
+	// Header and the two added lines
+	Equal(t, strings.Count(diff, "\n+"), 3)
+	Equal(t, strings.Count(diff, "\n-"), 0)
+
+	// Parameters of the example in README are honoured by built-in manifests
+	parameters = `{"consensus": {"pbft": {"batch_size": 100}}, "azs": ["z1", "z2", "z3", "z4"]}`
+	code, manifest = runManifest(t, append(flags, "-parameters", parameters, "permissioned")...)
+	Equal(t, code, 0)
+	Equal(t, strings.Contains(manifest, "batch_size: 100"), true)
+	Equal(t, strings.Contains(manifest, "- z4"), true)
+
+	code, _ = runManifest(t, append(flags, "unknown-plan")...)
+	Equal(t, code, 2)
+}
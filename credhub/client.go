@@ -0,0 +1,177 @@
+package credhub
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("credhub")
+
+const (
+	requestTimeout = 30 * time.Second
+
+	// Token is refreshed this long before it expires
+	tokenExpiryMargin = 30 * time.Second
+)
+
+// Client reads credentials generated by the config server of a BOSH director
+type Client interface {
+	// GetValue returns current value of credential with given full name,
+	// e.g. /director-name/deployment-name/variable-name
+	GetValue(name string) (string, error)
+}
+
+// Details holds settings to access CredHub. Clients authenticate with UAA
+// using client credentials grant.
+type Details struct {
+	Url                 string
+	UaaUrl              string
+	ClientId            string
+	ClientSecret        string
+	SkipTLSVerification bool
+	// PEM encoded certificates of CAs that sign certificates of CredHub and
+	// UAA. System CAs are used if empty.
+	CACert string
+}
+
+type httpClient struct {
+	httpClient  *http.Client
+	details     Details
+	lock        *sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// NewHttpClient returns a client for CredHub. Returns an error if CA
+// certificates are given but none of them can be parsed.
+func NewHttpClient(details Details) (Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: details.SkipTLSVerification}
+	if details.CACert != "" {
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM([]byte(details.CACert)) {
+			return nil, errors.New("CredHub CA certificate is not a PEM encoded certificate")
+		}
+	}
+	if details.SkipTLSVerification {
+		log.Warning("TLS certificates of CredHub and UAA are not verified")
+	}
+
+	return &httpClient{
+		httpClient: &http.Client{
+			Timeout: requestTimeout,
+			Transport: &http.Transport{
+				TLSClientConfig: tlsConfig,
+			},
+		},
+		details: details,
+		lock:    &sync.Mutex{},
+	}, nil
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// Returns access token, fetching a new one from UAA if needed
+func (c *httpClient) accessToken() (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.token != "" && time.Now().Before(c.tokenExpiry) {
+		return c.token, nil
+	}
+
+	log.Debug("Fetching CredHub access token from UAA")
+	form := url.Values{"grant_type": {"client_credentials"}}
+	request, err := http.NewRequest("POST", fmt.Sprintf("%s/oauth/token", c.details.UaaUrl), strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.SetBasicAuth(c.details.ClientId, c.details.ClientSecret)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(request)
+	if err != nil {
+		log.Error("Error connecting to UAA", err)
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(fmt.Sprintf("Unable to get CredHub access token, UAA responded with %d", resp.StatusCode))
+	}
+
+	token := tokenResponse{}
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return "", err
+	}
+
+	c.token = token.AccessToken
+	c.tokenExpiry = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - tokenExpiryMargin)
+	return c.token, nil
+}
+
+type credential struct {
+	Name  string      `json:"name"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+type dataResponse struct {
+	Data []credential `json:"data"`
+}
+
+func (c *httpClient) GetValue(name string) (string, error) {
+	log.Debugf("Getting credential %s", name)
+	token, err := c.accessToken()
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{"name": {name}, "current": {"true"}}
+	request, err := http.NewRequest("GET", fmt.Sprintf("%s/api/v1/data?%s", c.details.Url, query.Encode()), nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	resp, err := c.httpClient.Do(request)
+	if err != nil {
+		log.Error("Error connecting to CredHub", err)
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", errors.New(fmt.Sprintf("Credential %s not found", name))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(fmt.Sprintf("Unable to get credential %s, CredHub responded with %d", name, resp.StatusCode))
+	}
+
+	data := dataResponse{}
+	err = json.NewDecoder(resp.Body).Decode(&data)
+	if err != nil {
+		return "", err
+	}
+	if len(data.Data) == 0 {
+		return "", errors.New(fmt.Sprintf("Credential %s not found", name))
+	}
+
+	value, ok := data.Data[0].Value.(string)
+	if !ok {
+		return "", errors.New(fmt.Sprintf("Credential %s of type %s is not a string", name, data.Data[0].Type))
+	}
+	return value, nil
+}
@@ -0,0 +1,126 @@
+package credhub_test
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/predix/fabric-service-broker/credhub"
+
+	. "gopkg.in/go-playground/assert.v1"
+)
+
+// Local stand-in for UAA and CredHub serving given password credentials
+func newCredhubStandIn(credentials map[string]string) (*httptest.Server, *int) {
+	tokenRequests := 0
+	return httptest.NewServer(credhubStandInHandler(credentials, &tokenRequests)), &tokenRequests
+}
+
+func credhubStandInHandler(credentials map[string]string, tokenRequests *int) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		clientId, clientSecret, _ := r.BasicAuth()
+		if clientId != "broker" || clientSecret != "broker-secret" || r.FormValue("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		*tokenRequests++
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "the-token", "expires_in": 3600})
+	})
+	mux.HandleFunc("/api/v1/data", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer the-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		name := r.URL.Query().Get("name")
+		value, found := credentials[name]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": []map[string]interface{}{{"name": name, "type": "password", "value": value}},
+		})
+	})
+	return mux
+}
+
+func newTestClient(t *testing.T, server *httptest.Server, clientSecret string) credhub.Client {
+	client, err := credhub.NewHttpClient(credhub.Details{
+		Url:          server.URL,
+		UaaUrl:       server.URL,
+		ClientId:     "broker",
+		ClientSecret: clientSecret,
+	})
+	Equal(t, err, nil)
+	return client
+}
+
+func TestGetValue(t *testing.T) {
+	server, tokenRequests := newCredhubStandIn(map[string]string{
+		"/bosh/fabric-1/membersrvc_admin_secret": "generated-secret",
+	})
+	defer server.Close()
+	client := newTestClient(t, server, "broker-secret")
+
+	value, err := client.GetValue("/bosh/fabric-1/membersrvc_admin_secret")
+	Equal(t, err, nil)
+	Equal(t, value, "generated-secret")
+
+	_, err = client.GetValue("/bosh/fabric-1/membersrvc_admin_secret")
+	Equal(t, err, nil)
+	Equal(t, *tokenRequests, 1)
+}
+
+func TestGetValue_NotFound(t *testing.T) {
+	server, _ := newCredhubStandIn(map[string]string{})
+	defer server.Close()
+	client := newTestClient(t, server, "broker-secret")
+
+	_, err := client.GetValue("/bosh/fabric-1/missing")
+	NotEqual(t, err, nil)
+	Equal(t, err.Error(), "Credential /bosh/fabric-1/missing not found")
+}
+
+func TestGetValue_Unauthorized(t *testing.T) {
+	server, _ := newCredhubStandIn(map[string]string{})
+	defer server.Close()
+	client := newTestClient(t, server, "wrong-secret")
+
+	_, err := client.GetValue("/bosh/fabric-1/membersrvc_admin_secret")
+	NotEqual(t, err, nil)
+}
+
+func TestNewHttpClient_CACert(t *testing.T) {
+	tokenRequests := 0
+	server := httptest.NewTLSServer(credhubStandInHandler(map[string]string{
+		"/bosh/fabric-1/membersrvc_admin_secret": "generated-secret",
+	}, &tokenRequests))
+	defer server.Close()
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.TLS.Certificates[0].Certificate[0]})
+	details := credhub.Details{
+		Url:          server.URL,
+		UaaUrl:       server.URL,
+		ClientId:     "broker",
+		ClientSecret: "broker-secret",
+	}
+
+	// Certificate of stand-in is not signed by a system CA
+	client, err := credhub.NewHttpClient(details)
+	Equal(t, err, nil)
+	_, err = client.GetValue("/bosh/fabric-1/membersrvc_admin_secret")
+	NotEqual(t, err, nil)
+
+	details.CACert = string(caCert)
+	client, err = credhub.NewHttpClient(details)
+	Equal(t, err, nil)
+	value, err := client.GetValue("/bosh/fabric-1/membersrvc_admin_secret")
+	Equal(t, err, nil)
+	Equal(t, value, "generated-secret")
+
+	details.CACert = "not a certificate"
+	_, err = credhub.NewHttpClient(details)
+	NotEqual(t, err, nil)
+}
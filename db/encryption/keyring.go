@@ -0,0 +1,194 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("encryption")
+
+const (
+	keyLength = 32
+
+	// Prefix of encrypted values, followed by key id, wrapped data key and
+	// ciphertext separated by colons. Values without the prefix are stored
+	// in plain text, e.g. written before encryption was enabled.
+	encryptedPrefix = "enc:v1:"
+)
+
+// Keyring holds key encryption keys by id. Values are encrypted with a
+// random data key, which is in turn encrypted (wrapped) with the active
+// key. Older keys are kept to decrypt values written before rotation.
+type Keyring struct {
+	keys        map[string][]byte
+	activeKeyId string
+}
+
+// ParseKeyring parses comma or newline separated list of keys in format
+// id:base64-encoded-key. The first key is active and is used for all new
+// values. Keys must be 32 bytes long.
+func ParseKeyring(spec string) (*Keyring, error) {
+	keyring := &Keyring{keys: make(map[string][]byte)}
+
+	entries := strings.FieldsFunc(spec, func(r rune) bool {
+		return r == ',' || r == '\n'
+	})
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.New("Encryption key must be in format id:base64-encoded-key")
+		}
+		keyId := parts[0]
+		if _, found := keyring.keys[keyId]; found {
+			return nil, errors.New(fmt.Sprintf("Encryption key %s specified more than once", keyId))
+		}
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Encryption key %s is not base64 encoded", keyId))
+		}
+		if len(key) != keyLength {
+			return nil, errors.New(fmt.Sprintf("Encryption key %s must be %d bytes long", keyId, keyLength))
+		}
+
+		keyring.keys[keyId] = key
+		if keyring.activeKeyId == "" {
+			keyring.activeKeyId = keyId
+		}
+	}
+
+	if keyring.activeKeyId == "" {
+		return nil, errors.New("No encryption key specified")
+	}
+	log.Infof("Loaded %d encryption keys, active key is %s", len(keyring.keys), keyring.activeKeyId)
+	return keyring, nil
+}
+
+// LoadKeyring reads keys from file, one key per line
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Error("Error reading encryption keys file", err)
+		return nil, err
+	}
+	return ParseKeyring(string(data))
+}
+
+func (k *Keyring) ActiveKeyId() string {
+	return k.activeKeyId
+}
+
+// Encrypt encrypts value using the active key
+func (k *Keyring) Encrypt(value string) (string, error) {
+	dataKey := make([]byte, keyLength)
+	_, err := io.ReadFull(rand.Reader, dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	wrappedKey, err := seal(k.keys[k.activeKeyId], dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dataKey, []byte(value))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s%s:%s:%s",
+		encryptedPrefix,
+		k.activeKeyId,
+		base64.StdEncoding.EncodeToString(wrappedKey),
+		base64.StdEncoding.EncodeToString(ciphertext),
+	), nil
+}
+
+// Decrypt decrypts value encrypted with any key of the keyring. Values that
+// are not encrypted are returned as is.
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, encryptedPrefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("Malformed encrypted value")
+	}
+	key, found := k.keys[parts[0]]
+	if !found {
+		return "", errors.New(fmt.Sprintf("Encryption key %s not available", parts[0]))
+	}
+	wrappedKey, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+
+	dataKey, err := open(key, wrappedKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataKey, ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// IsEncrypted returns true if value has been encrypted by a keyring
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// Encrypts plaintext with AES-GCM, returned data starts with the nonce
+func seal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("Malformed encrypted value")
+	}
+	nonce := data[:gcm.NonceSize()]
+	plaintext, err := gcm.Open(nil, nonce, data[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("Unable to decrypt value, wrong key or corrupted data")
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,91 @@
+package encryption_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/predix/fabric-service-broker/db/encryption"
+
+	. "gopkg.in/go-playground/assert.v1"
+)
+
+const (
+	key1 = "k1:MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+	key2 = "k2:ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA="
+)
+
+func TestKeyring_EncryptDecrypt(t *testing.T) {
+	keyring, err := encryption.ParseKeyring(key1)
+	Equal(t, err, nil)
+
+	encrypted, err := keyring.Encrypt("Xurw3yU9zI0l")
+	Equal(t, err, nil)
+	Equal(t, encryption.IsEncrypted(encrypted), true)
+	Equal(t, strings.Contains(encrypted, "Xurw3yU9zI0l"), false)
+	Equal(t, strings.HasPrefix(encrypted, "enc:v1:k1:"), true)
+
+	otherEncrypted, err := keyring.Encrypt("Xurw3yU9zI0l")
+	Equal(t, err, nil)
+	NotEqual(t, otherEncrypted, encrypted)
+
+	decrypted, err := keyring.Decrypt(encrypted)
+	Equal(t, err, nil)
+	Equal(t, decrypted, "Xurw3yU9zI0l")
+}
+
+func TestKeyring_DecryptPlainText(t *testing.T) {
+	keyring, err := encryption.ParseKeyring(key1)
+	Equal(t, err, nil)
+
+	decrypted, err := keyring.Decrypt(`{"admin":"secret"}`)
+	Equal(t, err, nil)
+	Equal(t, decrypted, `{"admin":"secret"}`)
+}
+
+func TestKeyring_Rotation(t *testing.T) {
+	oldKeyring, err := encryption.ParseKeyring(key1)
+	Equal(t, err, nil)
+	encrypted, err := oldKeyring.Encrypt("secret")
+	Equal(t, err, nil)
+
+	keyring, err := encryption.ParseKeyring(key2 + "\n" + key1)
+	Equal(t, err, nil)
+	Equal(t, keyring.ActiveKeyId(), "k2")
+
+	decrypted, err := keyring.Decrypt(encrypted)
+	Equal(t, err, nil)
+	Equal(t, decrypted, "secret")
+
+	reencrypted, err := keyring.Encrypt(decrypted)
+	Equal(t, err, nil)
+	Equal(t, strings.HasPrefix(reencrypted, "enc:v1:k2:"), true)
+
+	_, err = oldKeyring.Decrypt(reencrypted)
+	NotEqual(t, err, nil)
+	Equal(t, err.Error(), "Encryption key k2 not available")
+}
+
+func TestKeyring_Tampered(t *testing.T) {
+	keyring, err := encryption.ParseKeyring(key1)
+	Equal(t, err, nil)
+	encrypted, err := keyring.Encrypt("secret")
+	Equal(t, err, nil)
+
+	tampered := encrypted[:len(encrypted)-4] + "AAA="
+	_, err = keyring.Decrypt(tampered)
+	NotEqual(t, err, nil)
+}
+
+func TestParseKeyring_Invalid(t *testing.T) {
+	_, err := encryption.ParseKeyring("")
+	Equal(t, err.Error(), "No encryption key specified")
+
+	_, err = encryption.ParseKeyring("k1:c2hvcnQ=")
+	Equal(t, err.Error(), "Encryption key k1 must be 32 bytes long")
+
+	_, err = encryption.ParseKeyring(key1 + "," + key1)
+	Equal(t, err.Error(), "Encryption key k1 specified more than once")
+
+	_, err = encryption.ParseKeyring("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	NotEqual(t, err, nil)
+}
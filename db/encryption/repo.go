@@ -0,0 +1,174 @@
+package encryption
+
+import (
+	"reflect"
+
+	"github.com/predix/fabric-service-broker/db"
+	"github.com/predix/fabric-service-broker/db/models"
+)
+
+// Struct tag marking string fields of models that are encrypted at rest
+const encryptedTag = "encrypted"
+
+type encryptedRepo struct {
+	repo    db.ModelsRepo
+	keyring *Keyring
+}
+
+// NewRepo wraps repo so that model fields tagged `encrypted:"true"` are
+// encrypted before they are written and decrypted after they are read
+func NewRepo(repo db.ModelsRepo, keyring *Keyring) db.ModelsRepo {
+	return &encryptedRepo{
+		repo:    repo,
+		keyring: keyring,
+	}
+}
+
+// Reencrypt rewrites all service instances so that their encrypted fields
+// use the active key. Run after a new key has been added to rotate keys.
+func Reencrypt(repo db.ModelsRepo) error {
+	serviceInstances, err := repo.ListServiceInstances()
+	if err != nil {
+		return err
+	}
+	for _, serviceInstance := range serviceInstances {
+		err = repo.UpdateServiceInstance(serviceInstance)
+		if err != nil {
+			return err
+		}
+	}
+	log.Infof("Re-encrypted %d service instances", len(serviceInstances))
+	return nil
+}
+
+// Applies transform to all non empty string fields of struct value that are
+// tagged as encrypted. Embedded structs are included.
+func transformStruct(value reflect.Value, transform func(string) (string, error)) error {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		structField := value.Type().Field(i)
+
+		if structField.Anonymous && field.Kind() == reflect.Struct {
+			err := transformStruct(field, transform)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if structField.Tag.Get(encryptedTag) != "true" || field.Kind() != reflect.String || field.String() == "" {
+			continue
+		}
+
+		transformed, err := transform(field.String())
+		if err != nil {
+			return err
+		}
+		field.SetString(transformed)
+	}
+	return nil
+}
+
+// model must be a pointer to struct
+func (r *encryptedRepo) encrypt(model interface{}) error {
+	return transformStruct(reflect.ValueOf(model).Elem(), r.keyring.Encrypt)
+}
+
+// model must be a pointer to struct
+func (r *encryptedRepo) decrypt(model interface{}) error {
+	return transformStruct(reflect.ValueOf(model).Elem(), r.keyring.Decrypt)
+}
+
+func (r *encryptedRepo) CreateServiceInstance(serviceInstance models.ServiceInstance) error {
+	err := r.encrypt(&serviceInstance)
+	if err != nil {
+		return err
+	}
+	return r.repo.CreateServiceInstance(serviceInstance)
+}
+
+func (r *encryptedRepo) UpdateServiceInstance(serviceInstance models.ServiceInstance) error {
+	err := r.encrypt(&serviceInstance)
+	if err != nil {
+		return err
+	}
+	return r.repo.UpdateServiceInstance(serviceInstance)
+}
+
+func (r *encryptedRepo) FindServiceInstance(serviceInstanceId string) (*models.ServiceInstance, error) {
+	serviceInstance, err := r.repo.FindServiceInstance(serviceInstanceId)
+	if err != nil || serviceInstance == nil {
+		return serviceInstance, err
+	}
+	return serviceInstance, r.decrypt(serviceInstance)
+}
+
+func (r *encryptedRepo) ListServiceInstances() ([]models.ServiceInstance, error) {
+	serviceInstances, err := r.repo.ListServiceInstances()
+	if err != nil {
+		return nil, err
+	}
+	for i := range serviceInstances {
+		err = r.decrypt(&serviceInstances[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return serviceInstances, nil
+}
+
+func (r *encryptedRepo) DeleteServiceInstance(serviceInstanceId string) (*models.ServiceInstance, error) {
+	serviceInstance, err := r.repo.DeleteServiceInstance(serviceInstanceId)
+	if err != nil || serviceInstance == nil {
+		return serviceInstance, err
+	}
+	return serviceInstance, r.decrypt(serviceInstance)
+}
+
+func (r *encryptedRepo) CreateServiceBinding(serviceBinding models.ServiceBinding) error {
+	err := r.encrypt(&serviceBinding)
+	if err != nil {
+		return err
+	}
+	return r.repo.CreateServiceBinding(serviceBinding)
+}
+
+func (r *encryptedRepo) UpdateServiceBinding(serviceBinding models.ServiceBinding) error {
+	err := r.encrypt(&serviceBinding)
+	if err != nil {
+		return err
+	}
+	return r.repo.UpdateServiceBinding(serviceBinding)
+}
+
+func (r *encryptedRepo) FindServiceBinding(bindingId string) (*models.ServiceBinding, error) {
+	serviceBinding, err := r.repo.FindServiceBinding(bindingId)
+	if err != nil || serviceBinding == nil {
+		return serviceBinding, err
+	}
+	return serviceBinding, r.decrypt(serviceBinding)
+}
+
+func (r *encryptedRepo) DeleteServiceBinding(bindingId string) (*models.ServiceBinding, error) {
+	serviceBinding, err := r.repo.DeleteServiceBinding(bindingId)
+	if err != nil || serviceBinding == nil {
+		return serviceBinding, err
+	}
+	return serviceBinding, r.decrypt(serviceBinding)
+}
+
+func (r *encryptedRepo) AssociatedServiceBindings(serviceInstanceId string) (models.ServiceBindings, error) {
+	storedBindings, err := r.repo.AssociatedServiceBindings(serviceInstanceId)
+	if err != nil {
+		return nil, err
+	}
+	// Copied as repo might return the slice it holds the bindings in
+	serviceBindings := make(models.ServiceBindings, len(storedBindings))
+	copy(serviceBindings, storedBindings)
+	for i := range serviceBindings {
+		err = r.decrypt(&serviceBindings[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return serviceBindings, nil
+}
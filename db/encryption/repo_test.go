@@ -0,0 +1,75 @@
+package encryption_test
+
+import (
+	"testing"
+
+	"github.com/predix/fabric-service-broker/db/encryption"
+	"github.com/predix/fabric-service-broker/db/inmemory"
+	"github.com/predix/fabric-service-broker/db/models"
+
+	. "gopkg.in/go-playground/assert.v1"
+)
+
+func newServiceInstance(id string) models.ServiceInstance {
+	return models.ServiceInstance{
+		BaseModel:           models.BaseModel{Id: id},
+		ServiceId:           "service-id",
+		PlanId:              "plan-id",
+		OrganizationGuid:    "org-guid",
+		SpaceGuid:           "space-guid",
+		DeploymentName:      "fabric-" + id,
+		NetworkName:         "net1",
+		BlockchainNetworkId: id,
+		Secrets:             `{"admin":"secret"}`,
+	}
+}
+
+func TestRepo_EncryptsTaggedFields(t *testing.T) {
+	keyring, err := encryption.ParseKeyring(key1)
+	Equal(t, err, nil)
+	store := inmemory.Get()
+	repo := encryption.NewRepo(store, keyring)
+
+	err = repo.CreateServiceInstance(newServiceInstance("encrypted-1"))
+	Equal(t, err, nil)
+	defer repo.DeleteServiceInstance("encrypted-1")
+
+	stored, err := store.FindServiceInstance("encrypted-1")
+	Equal(t, err, nil)
+	Equal(t, encryption.IsEncrypted(stored.Secrets), true)
+	Equal(t, stored.NetworkName, "net1")
+
+	found, err := repo.FindServiceInstance("encrypted-1")
+	Equal(t, err, nil)
+	Equal(t, found.Secrets, `{"admin":"secret"}`)
+
+	list, err := repo.ListServiceInstances()
+	Equal(t, err, nil)
+	for _, serviceInstance := range list {
+		if serviceInstance.Id == "encrypted-1" {
+			Equal(t, serviceInstance.Secrets, `{"admin":"secret"}`)
+		}
+	}
+}
+
+func TestRepo_Reencrypt(t *testing.T) {
+	oldKeyring, err := encryption.ParseKeyring(key1)
+	Equal(t, err, nil)
+	store := inmemory.Get()
+	err = encryption.NewRepo(store, oldKeyring).CreateServiceInstance(newServiceInstance("encrypted-2"))
+	Equal(t, err, nil)
+	defer store.DeleteServiceInstance("encrypted-2")
+
+	keyring, err := encryption.ParseKeyring(key2 + "," + key1)
+	Equal(t, err, nil)
+	repo := encryption.NewRepo(store, keyring)
+	err = encryption.Reencrypt(repo)
+	Equal(t, err, nil)
+
+	_, err = encryption.NewRepo(store, oldKeyring).FindServiceInstance("encrypted-2")
+	NotEqual(t, err, nil)
+
+	found, err := repo.FindServiceInstance("encrypted-2")
+	Equal(t, err, nil)
+	Equal(t, found.Secrets, `{"admin":"secret"}`)
+}
@@ -67,7 +67,7 @@ func (d *inMemoryDb) FindServiceInstance(serviceInstanceId string) (*models.Serv
 func (d *inMemoryDb) ListServiceInstances() ([]models.ServiceInstance, error) {
 	log.Infof("ListServiceInstances")
 
-	list := make([]models.ServiceInstance, len(d.serviceInstanceRepo))
+	list := make([]models.ServiceInstance, 0, len(d.serviceInstanceRepo))
 	for _, serviceInstance := range d.serviceInstanceRepo {
 		list = append(list, serviceInstance)
 	}
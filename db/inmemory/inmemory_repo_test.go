@@ -0,0 +1,38 @@
+package inmemory_test
+
+import (
+	"testing"
+
+	"github.com/predix/fabric-service-broker/db/inmemory"
+	"github.com/predix/fabric-service-broker/db/models"
+
+	. "gopkg.in/go-playground/assert.v1"
+)
+
+func newServiceInstance(id string) models.ServiceInstance {
+	return models.ServiceInstance{
+		BaseModel:           models.BaseModel{Id: id},
+		ServiceId:           "service-id",
+		PlanId:              "plan-id",
+		OrganizationGuid:    "org-guid",
+		SpaceGuid:           "space-guid",
+		DeploymentName:      "fabric-" + id,
+		NetworkName:         "net-" + id,
+		BlockchainNetworkId: "fabric-" + id,
+	}
+}
+
+func TestListServiceInstances(t *testing.T) {
+	repo := inmemory.Get()
+	for _, id := range []string{"instance-1", "instance-2"} {
+		err := repo.CreateServiceInstance(newServiceInstance(id))
+		Equal(t, err, nil)
+	}
+
+	serviceInstances, err := repo.ListServiceInstances()
+	Equal(t, err, nil)
+	Equal(t, len(serviceInstances), 2)
+	for _, serviceInstance := range serviceInstances {
+		NotEqual(t, serviceInstance.Id, "")
+	}
+}
@@ -1,6 +1,36 @@
 package models
 
-import "errors"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// State of instance whose VMs are stopped to save capacity. Persistent
+	// disks are kept so that the instance can be resumed.
+	InstanceStateSuspended = "suspended"
+	// State of instance whose VMs are being stopped. The instance becomes
+	// suspended once the BOSH task succeeds and active again if it fails.
+	InstanceStateSuspending = "suspending"
+	// State of suspended instance whose VMs are being started. The instance
+	// becomes active once the BOSH task succeeds and suspended again if it
+	// fails.
+	InstanceStateResuming = "resuming"
+	// State of instance waiting for capacity on BOSH director. Deployment
+	// has not been created yet.
+	InstanceStateQueued = "queued"
+	// State of queued instance whose deployment is being created. Keeps the
+	// instance from being dispatched twice if its provision task cannot be
+	// saved.
+	InstanceStateDispatching = "dispatching"
+	// State of queued instance that could not be dispatched as its manifest
+	// could not be generated. Deployment has not been created, see
+	// DispatchError for the reason.
+	InstanceStateDispatchFailed = "dispatch_failed"
+)
 
 type ServiceInstance struct {
 	BaseModel
@@ -8,11 +38,118 @@ type ServiceInstance struct {
 	PlanId              string
 	OrganizationGuid    string
 	SpaceGuid           string
+	DirectorName        string
 	DeploymentName      string
 	NetworkName         string
 	BlockchainNetworkId string
 	ProvisionTaskId     string
 	DeprovisionTaskId   string
+	CancelledTaskId     string
+	PostDeployErrand    string
+	ErrandTaskId        string
+	State               string
+	SuspendTaskId       string
+	ResumeTaskId        string
+	LastActiveAt        time.Time
+	QueuedAt            time.Time
+	DispatchError       string
+	// Names given by platform, empty if the platform did not send them
+	OrganizationName string
+	SpaceName        string
+	InstanceName     string
+	// JSON encoded parameters given on provision
+	Parameters string
+	// Secrets of membership service users, see bosh.Secrets
+	Secrets string `encrypted:"true"`
+	// Comma separated names of membership service clients included in
+	// bindings. Empty for instances provisioned before they were recorded.
+	MemberServiceClients string
+}
+
+func (s ServiceInstance) IsSuspended() bool {
+	return s.State == InstanceStateSuspended
+}
+
+func (s ServiceInstance) IsSuspending() bool {
+	return s.State == InstanceStateSuspending
+}
+
+func (s ServiceInstance) IsResuming() bool {
+	return s.State == InstanceStateResuming
+}
+
+func (s ServiceInstance) IsQueued() bool {
+	return s.State == InstanceStateQueued
+}
+
+func (s ServiceInstance) IsDispatching() bool {
+	return s.State == InstanceStateDispatching
+}
+
+func (s ServiceInstance) IsDispatchFailed() bool {
+	return s.State == InstanceStateDispatchFailed
+}
+
+// IsBeingDeleted returns true if the deployment of the instance is being
+// deleted, or its provision is being cancelled before that
+func (s ServiceInstance) IsBeingDeleted() bool {
+	return s.DeprovisionTaskId != "" || s.CancelledTaskId != ""
+}
+
+// DecodeParameters returns parameters given on provision
+func (s ServiceInstance) DecodeParameters() (map[string]interface{}, error) {
+	parameters := map[string]interface{}{}
+	if s.Parameters == "" {
+		return parameters, nil
+	}
+	err := json.Unmarshal([]byte(s.Parameters), &parameters)
+	if err != nil {
+		return nil, err
+	}
+	return parameters, nil
+}
+
+// EncodeParameters records parameters given on provision
+func (s *ServiceInstance) EncodeParameters(parameters map[string]interface{}) error {
+	if len(parameters) == 0 {
+		s.Parameters = ""
+		return nil
+	}
+	data, err := json.Marshal(parameters)
+	if err != nil {
+		return err
+	}
+	s.Parameters = string(data)
+	return nil
+}
+
+// Shown in place of secrets and membership service clients when an
+// instance is printed
+const redacted = "REDACTED"
+
+// Instance with secrets and membership service clients redacted, printed by
+// String and GoString
+type redactedServiceInstance ServiceInstance
+
+func (s ServiceInstance) redacted() redactedServiceInstance {
+	r := redactedServiceInstance(s)
+	if r.Secrets != "" {
+		r.Secrets = redacted
+	}
+	if r.MemberServiceClients != "" {
+		r.MemberServiceClients = redacted
+	}
+	return r
+}
+
+// String keeps secrets out of logs when the instance is printed with %v
+func (s ServiceInstance) String() string {
+	return fmt.Sprintf("%+v", s.redacted())
+}
+
+// GoString keeps secrets out of logs when the instance is printed with %#v
+func (s ServiceInstance) GoString() string {
+	return strings.Replace(fmt.Sprintf("%#v", s.redacted()), "models.redactedServiceInstance", "models.ServiceInstance", 1)
 }
 
 func (s ServiceInstance) Validate() error {
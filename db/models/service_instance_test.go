@@ -1,6 +1,8 @@
 package models_test
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
 	dbmodels "github.com/predix/fabric-service-broker/db/models"
@@ -33,3 +35,21 @@ func TestServiceInstance_Validate(t *testing.T) {
 	err := serviceInstance.Validate()
 	Equal(t, err, nil)
 }
+
+func TestServiceInstance_PrintedWithoutSecrets(t *testing.T) {
+	serviceInstance := dbmodels.ServiceInstance{
+		BaseModel:            dbmodels.BaseModel{Id: serviceInstanceId},
+		DeploymentName:       deploymentName,
+		Secrets:              `{"alice":"alice-secret"}`,
+		MemberServiceClients: "alice",
+	}
+	for _, format := range []string{"%v", "%+v", "%#v", "%s"} {
+		printed := fmt.Sprintf(format, serviceInstance)
+		Equal(t, strings.Contains(printed, "alice"), false)
+		Equal(t, strings.Contains(printed, deploymentName), true)
+	}
+	printed := fmt.Sprintf("%#v", &serviceInstance)
+	Equal(t, strings.Contains(printed, "alice"), false)
+	Equal(t, strings.HasPrefix(printed, "models.ServiceInstance{"), true)
+	Equal(t, serviceInstance.Secrets, `{"alice":"alice-secret"}`)
+}
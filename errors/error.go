@@ -18,6 +18,12 @@ const ErrManifestGeneration = `
   "description": "Unable to generate manifest for deployment"
 }
 `
+const ErrInvalidManifest = `
+{
+  "error": "InvalidManifest",
+  "description": "Manifest generated for the plan is invalid. Please contact the operator"
+}
+`
 const ErrHttpRequest = `
 {
   "error": "HttpRequestCreate",
@@ -30,6 +36,24 @@ const ErrBoshConnect = `
   "description": "Unable to connect to Bosh"
 }
 `
+const ErrBoshUnavailable = `
+{
+  "error": "BoshUnavailable",
+  "description": "Bosh director is currently unavailable. Please retry later"
+}
+`
+const ErrBoshTimeout = `
+{
+  "error": "BoshTimeout",
+  "description": "Bosh director is busy and did not respond in time. Please retry later"
+}
+`
+const ErrConcurrency = `
+{
+  "error": "ConcurrencyError",
+  "description": "Another operation for this service instance is in progress. Please retry later"
+}
+`
 const ErrDBSave = `
 {
   "error": "DBSave",
@@ -65,6 +89,13 @@ const ErrResourceAlreadyExists = `
 }
 `
 
+const ErrDeploymentNameInUse = `
+{
+  "error": "DeploymentNameInUse",
+  "description": "The BOSH deployment name generated for this service instance is already used by another instance. Retrying will not help, provision with a different instance id or ask the operator to change the deployment naming"
+}
+`
+
 const ErrProvisionInFlight = `
 {
   "error": "ProvisionInFlight",
@@ -72,6 +103,62 @@ const ErrProvisionInFlight = `
 }
 `
 
+const ErrProvisionFailed = `
+{
+  "error": "ProvisionFailed",
+  "description": "Service instance could not be deployed. Delete it and create a new one"
+}
+`
+
+const ErrInstanceUnhealthy = `
+{
+  "error": "InstanceUnhealthy",
+  "description": "Majority of validating peers of service instance are failing"
+}
+`
+
+const ErrInstanceSuspended = `
+{
+  "error": "InstanceSuspended",
+  "description": "Service instance is already suspended"
+}
+`
+
+const ErrInstanceSuspendedVMs = `
+{
+  "error": "InstanceSuspended",
+  "description": "Service instance is suspended. Resume it before changing state of its VMs"
+}
+`
+
+const ErrDeprovisionInFlight = `
+{
+  "error": "DeprovisionInFlight",
+  "description": "Service instance is being deleted"
+}
+`
+
+const ErrSuspendInFlight = `
+{
+  "error": "SuspendInFlight",
+  "description": "Service instance is being suspended or resumed. Please retry once the operation has finished"
+}
+`
+
+const ErrInstanceResuming = `
+{
+  "error": "InstanceResuming",
+  "description": "Service instance was suspended and is being resumed. Please retry in a few minutes"
+}
+`
+
+const ErrUnauthorized = `
+{
+  "error": "Unauthorized",
+  "description": "Valid credentials are required"
+}
+`
+
 const ErrBindingsExist = `
 {
   "error": "BindingExist",
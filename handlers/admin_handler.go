@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/predix/fabric-service-broker/bosh"
+)
+
+// AdminHandler serves operator facing endpoints under /admin. These are not
+// part of the service broker API and are protected by basic auth.
+type AdminHandler interface {
+	InstanceHealth(w http.ResponseWriter, r *http.Request)
+	ChangeInstanceState(w http.ResponseWriter, r *http.Request)
+}
+
+// Admin actions and BOSH job states they map to
+var instanceActions = map[string]bosh.JobState{
+	"start":    bosh.JobStateStarted,
+	"stop":     bosh.JobStateStopped,
+	"restart":  bosh.JobStateRestart,
+	"recreate": bosh.JobStateRecreate,
+}
+
+const (
+	actionSuspend = "suspend"
+	actionResume  = "resume"
+)
+
+// BasicAuth wraps handler to require given credentials
+func BasicAuth(username, password string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestUsername, requestPassword, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(requestUsername), []byte(username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(requestPassword), []byte(password)) != 1 {
+			log.Infof("Unauthorized request for %s", r.URL.Path)
+			handleUnauthorized(w)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (s *slHandler) InstanceHealth(w http.ResponseWriter, r *http.Request) {
+	log.Info("Handling GET /admin/service_instances/:instanceId/health")
+
+	vars := mux.Vars(r)
+	instanceId := vars["instanceId"]
+	serviceInstance, err := s.modelsRepo.FindServiceInstance(instanceId)
+	if err != nil {
+		handleDBReadError(err, w)
+		return
+	}
+	if serviceInstance == nil {
+		handleNotFound("instance not found", w)
+		return
+	}
+
+	provisionState, err := s.provisionTaskState(serviceInstance)
+	if err != nil {
+		handleBoshConnectError(err, w)
+		return
+	}
+	if !provisionState.IsTerminal() {
+		handleServiceInstanceInflight(instanceId, w)
+		return
+	}
+	if !provisionState.IsSuccess() {
+		handleServiceInstanceProvisionFailed(instanceId, provisionState, w)
+		return
+	}
+
+	healthReport, err := s.healthReport(serviceInstance)
+	if err != nil {
+		handleBoshError(err, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.Encode(healthReport)
+}
+
+// ChangeInstanceState starts, stops, restarts or recreates VMs of a service
+// instance. Suspend stops all VMs keeping persistent disks and resume starts
+// them again. Optional query parameters job and instance restrict the action
+// to a single job or a single instance (index or id) of that job.
+func (s *slHandler) ChangeInstanceState(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	log.Info("Handling POST /admin/service_instances/:instanceId/:action")
+
+	vars := mux.Vars(r)
+	instanceId := vars["instanceId"]
+	action := vars["action"]
+
+	state, found := instanceActions[action]
+	isSuspendOrResume := action == actionSuspend || action == actionResume
+	if !found && !isSuspendOrResume {
+		handleBadRequest(fmt.Sprintf("Invalid action %s", action), w)
+		return
+	}
+
+	query := r.URL.Query()
+	jobName := query.Get("job")
+	jobInstance := query.Get("instance")
+	if jobInstance != "" && jobName == "" {
+		handleBadRequest("job must be specified along with instance", w)
+		return
+	}
+	if isSuspendOrResume && jobName != "" {
+		handleBadRequest(fmt.Sprintf("%s applies to all VMs of the instance", action), w)
+		return
+	}
+
+	serviceInstance, err := s.modelsRepo.FindServiceInstance(instanceId)
+	if err != nil {
+		handleDBReadError(err, w)
+		return
+	}
+	if serviceInstance == nil {
+		handleNotFound("instance not found", w)
+		return
+	}
+
+	if serviceInstance.IsBeingDeleted() {
+		handleServiceInstanceDeprovisionInFlight(instanceId, w)
+		return
+	}
+
+	provisionState, err := s.provisionTaskState(serviceInstance)
+	if err != nil {
+		handleBoshConnectError(err, w)
+		return
+	}
+	if !provisionState.IsTerminal() {
+		handleServiceInstanceInflight(instanceId, w)
+		return
+	}
+
+	err = s.updateSuspendState(serviceInstance)
+	if err != nil {
+		handleBoshError(err, w)
+		return
+	}
+	if serviceInstance.IsSuspending() || serviceInstance.IsResuming() {
+		handleServiceInstanceSuspendInFlight(instanceId, w)
+		return
+	}
+
+	var task *bosh.Task
+	switch action {
+	case actionSuspend:
+		if serviceInstance.IsSuspended() {
+			handleServiceInstanceSuspended(instanceId, w)
+			return
+		}
+		task, err = s.suspendInstance(serviceInstance)
+	case actionResume:
+		task, err = s.resumeInstance(serviceInstance)
+	default:
+		if serviceInstance.IsSuspended() {
+			handleServiceInstanceSuspendedVMs(instanceId, w)
+			return
+		}
+		log.Infof("Changing state of instance:%s job:%s instance:%s to %s", instanceId, jobName, jobInstance, state)
+		task, err = s.clientFor(serviceInstance).ChangeJobState(serviceInstance.DeploymentName, jobName, jobInstance, state)
+	}
+	if err != nil {
+		handleBoshError(err, w)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte(fmt.Sprintf(asyncResponse, strconv.Itoa(task.Id))))
+}
@@ -0,0 +1,46 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/predix/fabric-service-broker/bosh"
+	"github.com/predix/fabric-service-broker/db/inmemory"
+	"github.com/predix/fabric-service-broker/handlers"
+
+	. "gopkg.in/go-playground/assert.v1"
+)
+
+func TestChangeInstanceState_BeingDeleted(t *testing.T) {
+	deleted := permissionedInstance("admin-1", "admin-net-1")
+	deleted.DeprovisionTaskId = "60"
+	cancelled := permissionedInstance("admin-2", "admin-net-2")
+	cancelled.CancelledTaskId = "12"
+	err := inmemory.Get().CreateServiceInstance(deleted)
+	Equal(t, err, nil)
+	defer inmemory.Get().DeleteServiceInstance(deleted.Id)
+	err = inmemory.Get().CreateServiceInstance(cancelled)
+	Equal(t, err, nil)
+	defer inmemory.Get().DeleteServiceInstance(cancelled.Id)
+
+	director := bosh.Director{
+		Details: &bosh.Details{Name: "bosh-lite", NetworkNames: []string{"admin-net-1", "admin-net-2"}},
+		Client:  fakeBoshClient{},
+	}
+	placement, err := handlers.NewPlacementStrategy(handlers.PlacementLeastLoaded)
+	Equal(t, err, nil)
+	handler, err := handlers.NewServiceLifecycleHandler(inmemory.Get(), []bosh.Director{director}, placement, handlers.DefaultDeploymentNaming(), nil)
+	Equal(t, err, nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/admin/service_instances/{instanceId}/{action}", handler.ChangeInstanceState).Methods("POST")
+	for _, path := range []string{"/admin/service_instances/admin-1/stop", "/admin/service_instances/admin-2/recreate"} {
+		request, err := http.NewRequest("POST", path, nil)
+		Equal(t, err, nil)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		Equal(t, recorder.Code, 422)
+	}
+}
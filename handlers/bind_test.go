@@ -0,0 +1,154 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/predix/fabric-service-broker/bosh"
+	"github.com/predix/fabric-service-broker/credhub"
+	"github.com/predix/fabric-service-broker/db/inmemory"
+	"github.com/predix/fabric-service-broker/db/models"
+	"github.com/predix/fabric-service-broker/handlers"
+	"github.com/predix/fabric-service-broker/rest_models"
+
+	. "gopkg.in/go-playground/assert.v1"
+)
+
+// Deployed manifest of a permissioned instance provisioned before names of
+// membership service clients were recorded
+const deployedManifest = `
+name: fabric-bind-2
+instance_groups:
+- name: membersrvc
+  jobs:
+  - name: member_service
+    properties:
+      membersrvc:
+        clients:
+        - name: carol
+          secret: ((membersrvc_carol_secret))
+`
+
+// Director with a successfully provisioned deployment of two running peers.
+// Calls not needed to bind are not implemented.
+type fakeBoshClient struct {
+	bosh.Client
+}
+
+func (fakeBoshClient) GetInfo() (*bosh.Info, error) {
+	return &bosh.Info{Name: "bosh-lite"}, nil
+}
+
+func (fakeBoshClient) GetTask(taskId string) (*bosh.Task, error) {
+	return &bosh.Task{State: bosh.BoshStateDone}, nil
+}
+
+func (fakeBoshClient) GetInstanceDetails(deploymentName string) (bosh.Instances, error) {
+	return bosh.Instances{
+		{JobName: "peer", IPs: []string{"10.0.0.1"}, ProcessState: bosh.ProcessStateRunning},
+		{JobName: "peer", IPs: []string{"10.0.0.2"}, ProcessState: bosh.ProcessStateRunning},
+	}, nil
+}
+
+func (fakeBoshClient) GetDeploymentManifest(deploymentName string) (string, error) {
+	return deployedManifest, nil
+}
+
+// Local stand-in for UAA and CredHub serving given password credentials
+func newCredhubStandIn(credentials map[string]string) *httptest.Server {
+	router := http.NewServeMux()
+	router.HandleFunc("/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "the-token", "expires_in": 3600})
+	})
+	router.HandleFunc("/api/v1/data", func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("name")
+		value, found := credentials[name]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": []map[string]interface{}{{"name": name, "type": "password", "value": value}},
+		})
+	})
+	return httptest.NewServer(router)
+}
+
+func bind(t *testing.T, credhubServer *httptest.Server, serviceInstance models.ServiceInstance) rest_models.BindCredentials {
+	credhubClient, err := credhub.NewHttpClient(credhub.Details{Url: credhubServer.URL, UaaUrl: credhubServer.URL, ClientId: "broker"})
+	Equal(t, err, nil)
+	director := bosh.Director{
+		Details: &bosh.Details{Name: "bosh-lite", NetworkNames: []string{"net-1", "net-2"}},
+		Client:  fakeBoshClient{},
+		Credhub: credhubClient,
+	}
+	placement, err := handlers.NewPlacementStrategy(handlers.PlacementLeastLoaded)
+	Equal(t, err, nil)
+
+	repo := inmemory.Get()
+	err = repo.CreateServiceInstance(serviceInstance)
+	Equal(t, err, nil)
+	handler, err := handlers.NewServiceLifecycleHandler(repo, []bosh.Director{director}, placement, handlers.DefaultDeploymentNaming(), nil)
+	Equal(t, err, nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/v2/service_instances/{instanceId}/service_bindings/{bindingId}", handler.Bind).Methods("PUT")
+	body := `{"service_id": "` + rest_models.DefaultServiceId + `", "plan_id": "` + rest_models.PermissionedPlanId + `", "app_guid": "app-1"}`
+	request, err := http.NewRequest("PUT", "/v2/service_instances/"+serviceInstance.Id+"/service_bindings/binding-"+serviceInstance.Id, strings.NewReader(body))
+	Equal(t, err, nil)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	Equal(t, recorder.Code, http.StatusCreated)
+
+	credentials := rest_models.BindCredentials{}
+	err = json.NewDecoder(recorder.Body).Decode(&credentials)
+	Equal(t, err, nil)
+	return credentials
+}
+
+func permissionedInstance(id, network string) models.ServiceInstance {
+	return models.ServiceInstance{
+		BaseModel:           models.BaseModel{Id: id},
+		ServiceId:           rest_models.DefaultServiceId,
+		PlanId:              rest_models.PermissionedPlanId,
+		OrganizationGuid:    "org-1",
+		SpaceGuid:           "space-1",
+		DeploymentName:      "fabric-" + id,
+		NetworkName:         network,
+		BlockchainNetworkId: "fabric-" + id,
+		ProvisionTaskId:     "12",
+	}
+}
+
+func TestBind_MemberServiceUsersFromCredhub(t *testing.T) {
+	server := newCredhubStandIn(map[string]string{
+		"/bosh-lite/fabric-bind-1/membersrvc_alice_secret": "alice-secret",
+		"/bosh-lite/fabric-bind-1/membersrvc_bob_secret":   "bob-secret",
+	})
+	defer server.Close()
+	serviceInstance := permissionedInstance("bind-1", "net-1")
+	serviceInstance.MemberServiceClients = "alice,bob"
+
+	credentials := bind(t, server, serviceInstance)
+	Equal(t, credentials.Credentials.PeerEndpoints, []string{"10.0.0.1:5000", "10.0.0.2:5000"})
+	Equal(t, credentials.Credentials.MemberServiceUsers, []rest_models.MemberServiceUser{
+		{EnrollId: "alice", EnrollSecret: "alice-secret"},
+		{EnrollId: "bob", EnrollSecret: "bob-secret"},
+	})
+}
+
+func TestBind_MemberServiceClientsFromDeployedManifest(t *testing.T) {
+	server := newCredhubStandIn(map[string]string{
+		"/bosh-lite/fabric-bind-2/membersrvc_carol_secret": "carol-secret",
+	})
+	defer server.Close()
+
+	credentials := bind(t, server, permissionedInstance("bind-2", "net-2"))
+	Equal(t, credentials.Credentials.MemberServiceUsers, []rest_models.MemberServiceUser{
+		{EnrollId: "carol", EnrollSecret: "carol-secret"},
+	})
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// Formats of the service instance id in deployment names
+const (
+	DeploymentIdFull      = "full"
+	DeploymentIdTruncated = "truncated"
+	DeploymentIdHashed    = "hashed"
+
+	DefaultDeploymentPrefix = "fabric-"
+
+	defaultTruncatedIdLength = 8
+	defaultHashedIdLength    = 12
+	// Deployment names are used in BOSH DNS names and have to fit a label
+	maxDeploymentNameLength = 63
+)
+
+var deploymentNamePattern = regexp.MustCompile("^[a-zA-Z0-9][a-zA-Z0-9_-]*$")
+
+// DeploymentNaming generates names of BOSH deployments for new service
+// instances as <prefix><broker id>-<instance id>. Broker id distinguishes
+// brokers sharing a director. Names of existing instances are stored with
+// them and do not change with the naming.
+type DeploymentNaming struct {
+	Prefix   string
+	BrokerId string
+	IdFormat string
+	// Length of truncated or hashed id. 0 uses the default for the format.
+	IdLength int
+}
+
+func DefaultDeploymentNaming() DeploymentNaming {
+	return DeploymentNaming{Prefix: DefaultDeploymentPrefix, IdFormat: DeploymentIdFull}
+}
+
+// Validate checks that the naming generates valid names
+func (n DeploymentNaming) Validate() error {
+	switch n.IdFormat {
+	case DeploymentIdFull, DeploymentIdTruncated, DeploymentIdHashed:
+	default:
+		return errors.New(fmt.Sprintf("Unknown deployment id format %s, must be %s, %s or %s", n.IdFormat, DeploymentIdFull, DeploymentIdTruncated, DeploymentIdHashed))
+	}
+	if n.IdLength < 0 {
+		return errors.New("Deployment id length cannot be negative")
+	}
+	if n.IdFormat == DeploymentIdHashed && n.IdLength > sha256.Size*2 {
+		return errors.New(fmt.Sprintf("Hashed deployment id can be at most %d characters", sha256.Size*2))
+	}
+	return ValidateDeploymentName(n.Name("2a98fb4c-b774-45bd-9d5b-7c427933f812"))
+}
+
+// Name returns name of deployment of service instance
+func (n DeploymentNaming) Name(instanceId string) string {
+	return n.NamePrefix() + n.formatId(instanceId)
+}
+
+// NamePrefix returns the part all deployment names of this broker start with
+func (n DeploymentNaming) NamePrefix() string {
+	if n.BrokerId == "" {
+		return n.Prefix
+	}
+	return n.Prefix + n.BrokerId + "-"
+}
+
+func (n DeploymentNaming) formatId(instanceId string) string {
+	switch n.IdFormat {
+	case DeploymentIdTruncated:
+		return truncate(instanceId, n.idLength(defaultTruncatedIdLength))
+	case DeploymentIdHashed:
+		hash := sha256.Sum256([]byte(instanceId))
+		return truncate(hex.EncodeToString(hash[:]), n.idLength(defaultHashedIdLength))
+	}
+	return instanceId
+}
+
+func (n DeploymentNaming) idLength(defaultLength int) int {
+	if n.IdLength > 0 {
+		return n.IdLength
+	}
+	return defaultLength
+}
+
+func truncate(value string, length int) string {
+	if len(value) <= length {
+		return value
+	}
+	return value[:length]
+}
+
+// ValidateDeploymentName checks name against the rules of BOSH for names
+// that are also used in DNS names
+func ValidateDeploymentName(name string) error {
+	if len(name) > maxDeploymentNameLength {
+		return errors.New(fmt.Sprintf("Deployment name %s is longer than %d characters", name, maxDeploymentNameLength))
+	}
+	if !deploymentNamePattern.MatchString(name) {
+		return errors.New(fmt.Sprintf("Deployment name %s must start with a letter or digit and only contain letters, digits, dashes and underscores", name))
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package handlers_test
+
+import (
+	"testing"
+
+	"github.com/predix/fabric-service-broker/handlers"
+
+	. "gopkg.in/go-playground/assert.v1"
+)
+
+const namingInstanceId = "2A98FB4C-B774-45BD-9D5B-7C427933F812"
+
+func TestDeploymentNaming_Default(t *testing.T) {
+	naming := handlers.DefaultDeploymentNaming()
+	Equal(t, naming.Validate(), nil)
+	Equal(t, naming.Name(namingInstanceId), "fabric-"+namingInstanceId)
+	Equal(t, naming.NamePrefix(), "fabric-")
+}
+
+func TestDeploymentNaming_BrokerIdAndTruncatedId(t *testing.T) {
+	naming := handlers.DeploymentNaming{Prefix: "fabric-", BrokerId: "staging", IdFormat: handlers.DeploymentIdTruncated}
+	Equal(t, naming.Validate(), nil)
+	Equal(t, naming.Name(namingInstanceId), "fabric-staging-2A98FB4C")
+	Equal(t, naming.NamePrefix(), "fabric-staging-")
+
+	naming.IdLength = 4
+	Equal(t, naming.Name(namingInstanceId), "fabric-staging-2A98")
+}
+
+func TestDeploymentNaming_HashedId(t *testing.T) {
+	naming := handlers.DeploymentNaming{Prefix: "bc-", IdFormat: handlers.DeploymentIdHashed}
+	Equal(t, naming.Validate(), nil)
+	name := naming.Name(namingInstanceId)
+	Equal(t, len(name), len("bc-")+12)
+	Equal(t, name, naming.Name(namingInstanceId))
+	NotEqual(t, name, naming.Name("other-instance"))
+
+	naming.IdLength = 65
+	NotEqual(t, naming.Validate(), nil)
+}
+
+func TestDeploymentNaming_Invalid(t *testing.T) {
+	NotEqual(t, handlers.DeploymentNaming{Prefix: "fabric-", IdFormat: "short"}.Validate(), nil)
+	NotEqual(t, handlers.DeploymentNaming{Prefix: "fabric ", IdFormat: handlers.DeploymentIdFull}.Validate(), nil)
+	NotEqual(t, handlers.DeploymentNaming{Prefix: "-", IdFormat: handlers.DeploymentIdFull}.Validate(), nil)
+	NotEqual(t, handlers.DeploymentNaming{Prefix: "a-very-long-prefix-for-deployments-", IdFormat: handlers.DeploymentIdFull}.Validate(), nil)
+}
+
+func TestValidateDeploymentName(t *testing.T) {
+	Equal(t, handlers.ValidateDeploymentName("fabric-1_a"), nil)
+	NotEqual(t, handlers.ValidateDeploymentName("fabric/1"), nil)
+	NotEqual(t, handlers.ValidateDeploymentName("fabric.1"), nil)
+	NotEqual(t, handlers.ValidateDeploymentName(""), nil)
+}
@@ -2,10 +2,20 @@ package handlers
 
 import (
 	"net/http"
+	"strconv"
 
+	"github.com/predix/fabric-service-broker/bosh"
 	sberrors "github.com/predix/fabric-service-broker/errors"
+	"github.com/predix/fabric-service-broker/health"
 )
 
+// Seconds after which client should retry an operation on locked deployment
+const deploymentLockedRetryAfter = 60
+
+// Seconds after which client should retry a request that timed out waiting
+// on a BOSH task
+const boshTaskTimeoutRetryAfter = 30
+
 func handleDBReadError(err error, w http.ResponseWriter) {
 	log.Error("Error in reading from DB", err)
 	w.WriteHeader(http.StatusInternalServerError)
@@ -30,6 +40,14 @@ func handleServiceInstanceAlreadyExists(instanceId string, w http.ResponseWriter
 	w.Write([]byte(sberrors.ErrResourceAlreadyExists))
 }
 
+// Names of deployments derived from truncated or hashed instance ids can
+// collide. The same name is generated on every retry of the provision.
+func handleDeploymentNameInUse(deploymentName string, w http.ResponseWriter) {
+	log.Errorf("Deployment name %s is already used by another instance", deploymentName)
+	w.WriteHeader(http.StatusConflict)
+	w.Write([]byte(sberrors.ErrDeploymentNameInUse))
+}
+
 func handleServiceInstanceGone(instanceId string, w http.ResponseWriter) {
 	log.Infof("Service instance:%s not found in DB", instanceId)
 	w.WriteHeader(http.StatusGone)
@@ -48,6 +66,50 @@ func handleServiceInstanceInflight(instanceId string, w http.ResponseWriter) {
 	w.Write([]byte(sberrors.ErrProvisionInFlight))
 }
 
+func handleServiceInstanceProvisionFailed(instanceId string, state bosh.TaskState, w http.ResponseWriter) {
+	log.Infof("Provisioning of service instance:%s ended in state %s", instanceId, state)
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write([]byte(sberrors.ErrProvisionFailed))
+}
+
+func handleServiceInstanceUnhealthy(instanceId string, healthReport health.Report, w http.ResponseWriter) {
+	log.Errorf("Majority of validators of service instance:%s are failing (%d of %d)", instanceId, healthReport.FailingValidators, healthReport.Validators)
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write([]byte(sberrors.ErrInstanceUnhealthy))
+}
+
+func handleServiceInstanceSuspended(instanceId string, w http.ResponseWriter) {
+	log.Infof("Service instance:%s is suspended", instanceId)
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write([]byte(sberrors.ErrInstanceSuspended))
+}
+
+// VMs of suspended instance are changed by resume only, so that state of
+// the instance matches its VMs
+func handleServiceInstanceSuspendedVMs(instanceId string, w http.ResponseWriter) {
+	log.Infof("Service instance:%s is suspended, not changing state of its VMs", instanceId)
+	w.WriteHeader(422)
+	w.Write([]byte(sberrors.ErrInstanceSuspendedVMs))
+}
+
+func handleServiceInstanceDeprovisionInFlight(instanceId string, w http.ResponseWriter) {
+	log.Infof("Service instance:%s is being deleted", instanceId)
+	w.WriteHeader(422)
+	w.Write([]byte(sberrors.ErrDeprovisionInFlight))
+}
+
+func handleServiceInstanceSuspendInFlight(instanceId string, w http.ResponseWriter) {
+	log.Infof("Service instance:%s is being suspended or resumed", instanceId)
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write([]byte(sberrors.ErrSuspendInFlight))
+}
+
+func handleServiceInstanceResuming(instanceId string, w http.ResponseWriter) {
+	log.Infof("Service instance:%s is being resumed", instanceId)
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write([]byte(sberrors.ErrInstanceResuming))
+}
+
 func handleOutOfNetworks(w http.ResponseWriter) {
 	log.Error("No networks available for deployment")
 	w.WriteHeader(http.StatusServiceUnavailable)
@@ -55,6 +117,18 @@ func handleOutOfNetworks(w http.ResponseWriter) {
 }
 
 func handleManifestGenerationError(err error, w http.ResponseWriter) {
+	if bosh.IsParameterError(err) {
+		log.Error("Invalid provision parameters", err)
+		handleBadRequest(err.Error(), w)
+		return
+	}
+	if bosh.IsManifestError(err) {
+		// Details refer to templates and ops files of the operator
+		log.Error("Generated manifest is invalid", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(sberrors.ErrInvalidManifest))
+		return
+	}
 	log.Error("Error in generating manifest for deployment", err)
 	w.WriteHeader(http.StatusInternalServerError)
 	w.Write([]byte(sberrors.ErrManifestGeneration))
@@ -66,7 +140,52 @@ func handleInternalServerError(err error, w http.ResponseWriter) {
 	w.Write([]byte(err.Error()))
 }
 
+// Handles errors returned by bosh client. Bosh director being unavailable
+// is reported as 503 so that clients can retry later.
+func handleBoshError(err error, w http.ResponseWriter) {
+	if err.Error() == sberrors.ErrBoshUnavailable {
+		handleBoshUnavailable(w)
+		return
+	}
+	if bosh.IsLockError(err) {
+		handleDeploymentLocked(err, w)
+		return
+	}
+	if bosh.IsTaskTimeoutError(err) {
+		handleBoshTaskTimeout(err, w)
+		return
+	}
+	handleInternalServerError(err, w)
+}
+
+func handleBoshTaskTimeout(err error, w http.ResponseWriter) {
+	log.Error("Bosh director did not finish task in time", err)
+	w.Header().Set("Retry-After", strconv.Itoa(boshTaskTimeoutRetryAfter))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write([]byte(sberrors.ErrBoshTimeout))
+}
+
+// Another BOSH task (e.g. operator running bosh CLI) holds the lock on
+// deployment. Reported as ConcurrencyError so that cloud controller tells
+// the user to retry.
+func handleDeploymentLocked(err error, w http.ResponseWriter) {
+	log.Error("Deployment is locked by another BOSH task", err)
+	w.Header().Set("Retry-After", strconv.Itoa(deploymentLockedRetryAfter))
+	w.WriteHeader(422)
+	w.Write([]byte(sberrors.ErrConcurrency))
+}
+
+func handleBoshUnavailable(w http.ResponseWriter) {
+	log.Error("Bosh director is unavailable")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write([]byte(sberrors.ErrBoshUnavailable))
+}
+
 func handleBoshConnectError(err error, w http.ResponseWriter) {
+	if err.Error() == sberrors.ErrBoshUnavailable {
+		handleBoshUnavailable(w)
+		return
+	}
 	log.Error("Error connecting to Bosh", err)
 	w.WriteHeader(http.StatusInternalServerError)
 	w.Write([]byte(sberrors.ErrBoshConnect))
@@ -93,3 +212,9 @@ func handleInstanceAlreadyBound(w http.ResponseWriter) {
 	w.WriteHeader(http.StatusBadRequest)
 	w.Write([]byte(sberrors.ErrBindingsExist))
 }
+
+func handleUnauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="fabric-broker-admin"`)
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write([]byte(sberrors.ErrUnauthorized))
+}
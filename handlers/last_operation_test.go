@@ -0,0 +1,98 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/predix/fabric-service-broker/bosh"
+	"github.com/predix/fabric-service-broker/db"
+	"github.com/predix/fabric-service-broker/db/inmemory"
+	"github.com/predix/fabric-service-broker/db/models"
+	"github.com/predix/fabric-service-broker/handlers"
+
+	. "gopkg.in/go-playground/assert.v1"
+)
+
+// Repo in which the instance is deleted right after it is first read
+type deletingRepo struct {
+	db.ModelsRepo
+	finds int
+}
+
+func (r *deletingRepo) FindServiceInstance(serviceInstanceId string) (*models.ServiceInstance, error) {
+	r.finds++
+	if r.finds > 1 {
+		return nil, nil
+	}
+	return r.ModelsRepo.FindServiceInstance(serviceInstanceId)
+}
+
+// Director on which all tasks have finished without events
+type finishedTaskBoshClient struct {
+	fakeBoshClient
+}
+
+func (finishedTaskBoshClient) GetTaskEvents(taskId string) (bosh.TaskEvents, error) {
+	return nil, nil
+}
+
+func TestLastOperation_SuspendedInstanceDeleted(t *testing.T) {
+	serviceInstance := permissionedInstance("lastop-1", "lastop-net")
+	serviceInstance.State = models.InstanceStateSuspending
+	serviceInstance.SuspendTaskId = "50"
+	err := inmemory.Get().CreateServiceInstance(serviceInstance)
+	Equal(t, err, nil)
+	defer inmemory.Get().DeleteServiceInstance(serviceInstance.Id)
+
+	director := bosh.Director{
+		Details: &bosh.Details{Name: "bosh-lite", NetworkNames: []string{"lastop-net"}},
+		Client:  finishedTaskBoshClient{},
+	}
+	placement, err := handlers.NewPlacementStrategy(handlers.PlacementLeastLoaded)
+	Equal(t, err, nil)
+	handler, err := handlers.NewServiceLifecycleHandler(&deletingRepo{ModelsRepo: inmemory.Get()}, []bosh.Director{director}, placement, handlers.DefaultDeploymentNaming(), nil)
+	Equal(t, err, nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/v2/service_instances/{instanceId}/last_operation", handler.LastOperation)
+	request, err := http.NewRequest("GET", "/v2/service_instances/lastop-1/last_operation?operation=50", nil)
+	Equal(t, err, nil)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	Equal(t, recorder.Code, http.StatusGone)
+}
+
+func TestLastOperation_NoErrandWhileDeleting(t *testing.T) {
+	serviceInstance := permissionedInstance("lastop-2", "lastop-net-2")
+	serviceInstance.PostDeployErrand = "smoke-tests"
+	serviceInstance.DeprovisionTaskId = "60"
+	err := inmemory.Get().CreateServiceInstance(serviceInstance)
+	Equal(t, err, nil)
+	defer inmemory.Get().DeleteServiceInstance(serviceInstance.Id)
+
+	// Running the errand would panic as the director does not implement it
+	director := bosh.Director{
+		Details: &bosh.Details{Name: "bosh-lite", NetworkNames: []string{"lastop-net-2"}},
+		Client:  finishedTaskBoshClient{},
+	}
+	placement, err := handlers.NewPlacementStrategy(handlers.PlacementLeastLoaded)
+	Equal(t, err, nil)
+	handler, err := handlers.NewServiceLifecycleHandler(inmemory.Get(), []bosh.Director{director}, placement, handlers.DefaultDeploymentNaming(), nil)
+	Equal(t, err, nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/v2/service_instances/{instanceId}/last_operation", handler.LastOperation)
+	request, err := http.NewRequest("GET", "/v2/service_instances/lastop-2/last_operation?operation=12", nil)
+	Equal(t, err, nil)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	Equal(t, recorder.Code, http.StatusOK)
+	Equal(t, strings.Contains(recorder.Body.String(), "Errand smoke-tests not run as service instance is being deleted"), true)
+
+	saved, err := inmemory.Get().FindServiceInstance(serviceInstance.Id)
+	Equal(t, err, nil)
+	Equal(t, saved.ErrandTaskId, "")
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/predix/fabric-service-broker/bosh"
+)
+
+// Strategies to choose the director a new service instance is deployed to
+const (
+	PlacementLeastLoaded   = "least-loaded"
+	PlacementRoundRobin    = "round-robin"
+	PlacementLabelAffinity = "label-affinity"
+)
+
+// DirectorLoad describes a director that has capacity for a new instance
+type DirectorLoad struct {
+	Details   *bosh.Details
+	Instances int
+}
+
+// PlacementStrategy chooses the director for a new service instance among
+// candidates, which are in order of configuration. Returns -1 if none of
+// the candidates is suitable for the plan. Placed is called once the
+// provision of an instance has started on the chosen director, so that a
+// failed provision does not count as a placement.
+type PlacementStrategy interface {
+	Choose(candidates []DirectorLoad, plan bosh.PlanConfig) int
+	Placed(directorName string)
+}
+
+func NewPlacementStrategy(name string) (PlacementStrategy, error) {
+	switch name {
+	case PlacementLeastLoaded, "":
+		return leastLoaded{}, nil
+	case PlacementRoundRobin:
+		return &roundRobin{}, nil
+	case PlacementLabelAffinity:
+		return labelAffinity{}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("Unknown placement strategy %s", name))
+}
+
+// Places instance on the director with the lowest share of its capacity used
+type leastLoaded struct{}
+
+func (leastLoaded) Choose(candidates []DirectorLoad, plan bosh.PlanConfig) int {
+	chosen := -1
+	for i, candidate := range candidates {
+		if chosen == -1 || usedShare(candidate) < usedShare(candidates[chosen]) {
+			chosen = i
+		}
+	}
+	return chosen
+}
+
+func (leastLoaded) Placed(directorName string) {}
+
+func usedShare(load DirectorLoad) float64 {
+	capacity := load.Details.Capacity()
+	if capacity == 0 {
+		return 1
+	}
+	return float64(load.Instances) / float64(capacity)
+}
+
+// Places instances on directors in turn, in order of their names. Turns are
+// kept by name so that directors without capacity are skipped without
+// changing the order of the others.
+type roundRobin struct {
+	last string
+}
+
+func (r *roundRobin) Choose(candidates []DirectorLoad, plan bosh.PlanConfig) int {
+	first := -1
+	next := -1
+	for i, candidate := range candidates {
+		name := candidate.Details.Name
+		if first == -1 || name < candidates[first].Details.Name {
+			first = i
+		}
+		if name > r.last && (next == -1 || name < candidates[next].Details.Name) {
+			next = i
+		}
+	}
+	if next == -1 {
+		next = first
+	}
+	return next
+}
+
+func (r *roundRobin) Placed(directorName string) {
+	r.last = directorName
+}
+
+// Places instance on the least loaded of the directors having all director
+// labels of the plan
+type labelAffinity struct{}
+
+func (labelAffinity) Choose(candidates []DirectorLoad, plan bosh.PlanConfig) int {
+	chosen := -1
+	for i, candidate := range candidates {
+		if !candidate.Details.HasLabels(plan.DirectorLabels) {
+			continue
+		}
+		if chosen == -1 || usedShare(candidate) < usedShare(candidates[chosen]) {
+			chosen = i
+		}
+	}
+	return chosen
+}
+
+func (labelAffinity) Placed(directorName string) {}
@@ -0,0 +1,103 @@
+package handlers_test
+
+import (
+	"testing"
+
+	"github.com/predix/fabric-service-broker/bosh"
+	"github.com/predix/fabric-service-broker/handlers"
+
+	. "gopkg.in/go-playground/assert.v1"
+)
+
+func directorLoad(name string, networks, instances int, labels ...string) handlers.DirectorLoad {
+	details := &bosh.Details{Name: name, Labels: labels}
+	for i := 0; i < networks; i++ {
+		details.NetworkNames = append(details.NetworkNames, name)
+	}
+	return handlers.DirectorLoad{Details: details, Instances: instances}
+}
+
+func TestNewPlacementStrategy_Unknown(t *testing.T) {
+	_, err := handlers.NewPlacementStrategy("random")
+	NotEqual(t, err, nil)
+}
+
+func TestLeastLoaded(t *testing.T) {
+	placement, err := handlers.NewPlacementStrategy(handlers.PlacementLeastLoaded)
+	Equal(t, err, nil)
+
+	candidates := []handlers.DirectorLoad{
+		directorLoad("a", 4, 2),
+		directorLoad("b", 10, 3),
+		directorLoad("c", 2, 0),
+	}
+	Equal(t, placement.Choose(candidates, bosh.PlanConfig{}), 2)
+	Equal(t, placement.Choose(candidates[:2], bosh.PlanConfig{}), 1)
+	Equal(t, placement.Choose(nil, bosh.PlanConfig{}), -1)
+}
+
+func choose(placement handlers.PlacementStrategy, candidates []handlers.DirectorLoad) int {
+	chosen := placement.Choose(candidates, bosh.PlanConfig{})
+	if chosen != -1 {
+		placement.Placed(candidates[chosen].Details.Name)
+	}
+	return chosen
+}
+
+func TestRoundRobin(t *testing.T) {
+	placement, err := handlers.NewPlacementStrategy(handlers.PlacementRoundRobin)
+	Equal(t, err, nil)
+
+	candidates := []handlers.DirectorLoad{
+		directorLoad("a", 4, 0),
+		directorLoad("b", 4, 0),
+	}
+	Equal(t, choose(placement, candidates), 0)
+	Equal(t, choose(placement, candidates), 1)
+	Equal(t, choose(placement, candidates), 0)
+	Equal(t, choose(placement, nil), -1)
+}
+
+func TestRoundRobin_SkipsDirectorsWithoutCapacity(t *testing.T) {
+	placement, err := handlers.NewPlacementStrategy(handlers.PlacementRoundRobin)
+	Equal(t, err, nil)
+
+	a := directorLoad("a", 4, 0)
+	b := directorLoad("b", 4, 0)
+	c := directorLoad("c", 4, 0)
+	Equal(t, choose(placement, []handlers.DirectorLoad{a, b, c}), 0)
+	// b is out of networks
+	Equal(t, choose(placement, []handlers.DirectorLoad{a, c}), 1)
+	Equal(t, choose(placement, []handlers.DirectorLoad{a, b, c}), 0)
+	Equal(t, choose(placement, []handlers.DirectorLoad{a, b, c}), 1)
+}
+
+func TestRoundRobin_FailedProvisionKeepsTurn(t *testing.T) {
+	placement, err := handlers.NewPlacementStrategy(handlers.PlacementRoundRobin)
+	Equal(t, err, nil)
+
+	candidates := []handlers.DirectorLoad{
+		directorLoad("a", 4, 0),
+		directorLoad("b", 4, 0),
+	}
+	Equal(t, choose(placement, candidates), 0)
+	// Provision on b failed, so b is chosen again
+	Equal(t, placement.Choose(candidates, bosh.PlanConfig{}), 1)
+	Equal(t, choose(placement, candidates), 1)
+	Equal(t, choose(placement, candidates), 0)
+}
+
+func TestLabelAffinity(t *testing.T) {
+	placement, err := handlers.NewPlacementStrategy(handlers.PlacementLabelAffinity)
+	Equal(t, err, nil)
+
+	candidates := []handlers.DirectorLoad{
+		directorLoad("a", 4, 0, "us"),
+		directorLoad("b", 4, 3, "eu", "gpu"),
+		directorLoad("c", 4, 1, "eu"),
+	}
+	Equal(t, placement.Choose(candidates, bosh.PlanConfig{DirectorLabels: []string{"eu"}}), 2)
+	Equal(t, placement.Choose(candidates, bosh.PlanConfig{DirectorLabels: []string{"eu", "gpu"}}), 1)
+	Equal(t, placement.Choose(candidates, bosh.PlanConfig{DirectorLabels: []string{"asia"}}), -1)
+	Equal(t, placement.Choose(candidates, bosh.PlanConfig{}), 0)
+}
@@ -0,0 +1,341 @@
+package handlers
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/predix/fabric-service-broker/bosh"
+	"github.com/predix/fabric-service-broker/db/models"
+)
+
+// Operation id returned for provisions that have been queued. Last operation
+// for this id reports queue position until the provision is dispatched and
+// then the state of provision task.
+const queuedOperation = "queued"
+
+type byQueuedAt []models.ServiceInstance
+
+func (b byQueuedAt) Len() int           { return len(b) }
+func (b byQueuedAt) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byQueuedAt) Less(i, j int) bool { return b[i].QueuedAt.Before(b[j].QueuedAt) }
+
+// Returns instances queued on the director, oldest first. Caller is
+// expected to hold the lock.
+func (s *slHandler) queuedInstances(director *director) ([]models.ServiceInstance, error) {
+	serviceInstances, err := s.modelsRepo.ListServiceInstances()
+	if err != nil {
+		return nil, err
+	}
+
+	queued := []models.ServiceInstance{}
+	for i := range serviceInstances {
+		if serviceInstances[i].IsQueued() && s.directorFor(&serviceInstances[i]) == director {
+			queued = append(queued, serviceInstances[i])
+		}
+	}
+	sort.Sort(byQueuedAt(queued))
+	return queued, nil
+}
+
+// Returns number of additional BOSH tasks that can be started on the
+// director for deployments of this broker. Tasks are matched by the exact
+// deployment names of instances on the director, so tasks of other brokers
+// sharing the director are not counted even if their names start with the
+// same prefix. Caller is expected to hold the lock.
+func (s *slHandler) availableTaskCapacity(director *director) (int, error) {
+	serviceInstances, err := s.modelsRepo.ListServiceInstances()
+	if err != nil {
+		return 0, err
+	}
+	deploymentNames := make(map[string]struct{})
+	for i := range serviceInstances {
+		if s.directorFor(&serviceInstances[i]) == director {
+			deploymentNames[serviceInstances[i].DeploymentName] = struct{}{}
+		}
+	}
+
+	tasks, err := director.client.GetInFlightTasks()
+	if err != nil {
+		return 0, err
+	}
+
+	inFlight := 0
+	for _, task := range tasks {
+		if _, found := deploymentNames[task.Deployment]; found {
+			inFlight++
+		}
+	}
+	log.Debugf("%d of %d BOSH tasks in flight on director %s", inFlight, director.details.MaxInFlightTasks, director.details.Name)
+	return director.details.MaxInFlightTasks - inFlight, nil
+}
+
+// Returns true if a new provision on the director has to wait in queue.
+// Provisions are dispatched in order so a new provision is queued as long as
+// there are older ones waiting. Caller is expected to hold the lock.
+func (s *slHandler) mustQueueProvision(director *director) (bool, error) {
+	if director.details.MaxInFlightTasks <= 0 {
+		return false, nil
+	}
+
+	queued, err := s.queuedInstances(director)
+	if err != nil {
+		return false, err
+	}
+	if len(queued) > 0 {
+		return true, nil
+	}
+
+	capacity, err := s.availableTaskCapacity(director)
+	if err != nil {
+		return false, err
+	}
+	return capacity <= 0, nil
+}
+
+// Returns 1 based position of instance in queue of its director and length
+// of the queue. Position is 0 if the instance is not queued.
+func (s *slHandler) queuePosition(serviceInstance *models.ServiceInstance) (int, int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	queued, err := s.queuedInstances(s.directorFor(serviceInstance))
+	if err != nil {
+		return 0, 0, err
+	}
+	for i := range queued {
+		if queued[i].Id == serviceInstance.Id {
+			return i + 1, len(queued), nil
+		}
+	}
+	return 0, len(queued), nil
+}
+
+// Generates manifest for the service instance reusing its secrets. Secrets
+// generated for the manifest and names of membership service clients are
+// recorded with the instance, which the caller is expected to save.
+func (s *slHandler) newManifest(serviceInstance *models.ServiceInstance) (*bosh.Manifest, error) {
+	secrets, err := bosh.ParseSecrets(serviceInstance.Secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	parameters, err := serviceInstance.DecodeParameters()
+	if err != nil {
+		return nil, err
+	}
+
+	context := bosh.ManifestContext{
+		InstanceId:     serviceInstance.Id,
+		PlanId:         serviceInstance.PlanId,
+		DeploymentName: serviceInstance.DeploymentName,
+		NetworkName:    serviceInstance.NetworkName,
+		Permissioned:   s.isPermissioned(serviceInstance.PlanId),
+		Details:        s.directorFor(serviceInstance).details,
+		Parameters:     parameters,
+		Tags:           DeploymentTags(serviceInstance),
+	}
+	manifest, err := bosh.NewManifest(context, secrets)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Manifest generated for deployment")
+
+	serviceInstance.Secrets, err = manifest.Secrets().Encode()
+	if err != nil {
+		return nil, err
+	}
+	serviceInstance.MemberServiceClients = strings.Join(manifest.MemberServiceClientNames(), ",")
+	return manifest, nil
+}
+
+// Creates the deployment of service instance and records the provision task.
+// Caller is expected to hold the lock.
+func (s *slHandler) startDeployment(serviceInstance *models.ServiceInstance, manifest *bosh.Manifest) (*bosh.Task, error) {
+	task, err := s.clientFor(serviceInstance).CreateDeployment(*manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceInstance.State = ""
+	serviceInstance.ProvisionTaskId = strconv.Itoa(task.Id)
+	return task, nil
+}
+
+// RunProvisionQueue dispatches queued provisions every checkInterval as
+// long as in-flight BOSH tasks on their director stay below the configured
+// maximum. It never returns and is expected to be run in its own go routine.
+func (s *slHandler) RunProvisionQueue(checkInterval time.Duration) {
+	for _, director := range s.directors {
+		log.Infof("Limiting in-flight BOSH tasks on director %s to %d", director.details.Name, director.details.MaxInFlightTasks)
+	}
+	for {
+		time.Sleep(checkInterval)
+		for _, director := range s.directors {
+			s.dispatchQueuedProvisions(director)
+		}
+	}
+}
+
+func (s *slHandler) dispatchQueuedProvisions(director *director) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.reconcileDispatches(director) {
+		return
+	}
+
+	queued, err := s.queuedInstances(director)
+	if err != nil {
+		log.Error("Unable to fetch service instances from db", err)
+		return
+	}
+	if len(queued) == 0 {
+		return
+	}
+
+	capacity, err := s.availableTaskCapacity(director)
+	if err != nil {
+		log.Errorf("Unable to get in-flight tasks from director %s, %s", director.details.Name, err)
+		return
+	}
+	log.Debugf("%d provisions queued on director %s, capacity for %d", len(queued), director.details.Name, capacity)
+	if capacity <= 0 {
+		return
+	}
+
+	// AZs may have been removed from cloud config while the provisions
+	// waited in queue
+	cloudConfig, err := director.client.GetCloudConfig()
+	if err != nil {
+		log.Errorf("Unable to get cloud config from director %s, %s", director.details.Name, err)
+		return
+	}
+
+	dispatched := 0
+	for i := 0; dispatched < capacity && i < len(queued); i++ {
+		serviceInstance := &queued[i]
+		manifest, err := s.newManifest(serviceInstance)
+		if err == nil {
+			err = manifest.ValidateAZs(cloudConfig)
+		}
+		if err != nil {
+			// Would fail again next time, take the instance out of queue so
+			// that it does not block the ones behind it
+			log.Errorf("Unable to generate manifest for queued instance:%s, %s", serviceInstance.Id, err)
+			serviceInstance.State = models.InstanceStateDispatchFailed
+			serviceInstance.DispatchError = err.Error()
+			err = s.modelsRepo.UpdateServiceInstance(*serviceInstance)
+			if err != nil {
+				log.Errorf("Unable to save failed dispatch of instance:%s, %s", serviceInstance.Id, err)
+				return
+			}
+			continue
+		}
+
+		// Saved before the deployment is created so that the instance is not
+		// dispatched again if its provision task cannot be saved. Instances
+		// left in this state are resolved by reconcileDispatches.
+		serviceInstance.State = models.InstanceStateDispatching
+		err = s.modelsRepo.UpdateServiceInstance(*serviceInstance)
+		if err != nil {
+			log.Errorf("Unable to save dispatch of instance:%s, %s", serviceInstance.Id, err)
+			return
+		}
+
+		task, err := s.startDeployment(serviceInstance, manifest)
+		if err != nil {
+			// Director is likely overloaded or down, try again next time
+			log.Errorf("Unable to dispatch queued provision of instance:%s, %s", serviceInstance.Id, err)
+			serviceInstance.State = models.InstanceStateQueued
+			err = s.modelsRepo.UpdateServiceInstance(*serviceInstance)
+			if err != nil {
+				log.Errorf("Unable to put instance:%s back in queue, %s", serviceInstance.Id, err)
+			}
+			return
+		}
+		log.Infof("Dispatched queued provision of instance:%s as task:%d", serviceInstance.Id, task.Id)
+		dispatched++
+
+		err = s.modelsRepo.UpdateServiceInstance(*serviceInstance)
+		if err != nil {
+			log.Errorf("Unable to save provision task of instance:%s, trying again later, %s", serviceInstance.Id, err)
+			s.unsavedDispatches[serviceInstance.Id] = *serviceInstance
+			return
+		}
+	}
+}
+
+// ReconcileDispatches resolves instances left in dispatching state on all
+// directors, for instance by a restart of the broker during dispatch. It is
+// expected to be called on startup before serving requests.
+func (s *slHandler) ReconcileDispatches() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, director := range s.directors {
+		s.reconcileDispatches(director)
+	}
+}
+
+// Resolves instances on the director which were marked as dispatching but
+// whose provision task was never saved. Returns false if any of them could
+// not be resolved. Caller is expected to hold the lock.
+func (s *slHandler) reconcileDispatches(director *director) bool {
+	serviceInstances, err := s.modelsRepo.ListServiceInstances()
+	if err != nil {
+		log.Error("Unable to fetch service instances from db", err)
+		return false
+	}
+
+	for i := range serviceInstances {
+		serviceInstance := &serviceInstances[i]
+		if !serviceInstance.IsDispatching() || s.directorFor(serviceInstance) != director {
+			continue
+		}
+		err = s.reconcileDispatch(serviceInstance)
+		if err != nil {
+			log.Errorf("Unable to resolve dispatch of instance:%s, %s", serviceInstance.Id, err)
+			return false
+		}
+	}
+	return true
+}
+
+// Resolves dispatch of a single instance. The provision task is taken from
+// unsaved dispatches if known, otherwise it is looked up on the director by
+// deployment name. If the director has no task for the deployment it was
+// never created and the instance is put back in queue, keeping its position.
+// Caller is expected to hold the lock.
+func (s *slHandler) reconcileDispatch(serviceInstance *models.ServiceInstance) error {
+	if dispatched, found := s.unsavedDispatches[serviceInstance.Id]; found {
+		*serviceInstance = dispatched
+	} else {
+		tasks, err := s.clientFor(serviceInstance).GetDeploymentTasks(serviceInstance.DeploymentName)
+		if err != nil {
+			return err
+		}
+		if len(tasks) == 0 {
+			log.Infof("No task for deployment %s, putting instance:%s back in queue", serviceInstance.DeploymentName, serviceInstance.Id)
+			serviceInstance.State = models.InstanceStateQueued
+		} else {
+			latest := tasks[0]
+			for _, task := range tasks {
+				if task.Id > latest.Id {
+					latest = task
+				}
+			}
+			log.Infof("Found provision task:%d of dispatched instance:%s", latest.Id, serviceInstance.Id)
+			serviceInstance.State = ""
+			serviceInstance.ProvisionTaskId = strconv.Itoa(latest.Id)
+		}
+	}
+
+	err := s.modelsRepo.UpdateServiceInstance(*serviceInstance)
+	if err != nil {
+		return err
+	}
+	delete(s.unsavedDispatches, serviceInstance.Id)
+	return nil
+}
@@ -0,0 +1,187 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/predix/fabric-service-broker/bosh"
+	"github.com/predix/fabric-service-broker/db/inmemory"
+	"github.com/predix/fabric-service-broker/db/models"
+	"github.com/predix/fabric-service-broker/handlers"
+	"github.com/predix/fabric-service-broker/rest_models"
+
+	. "gopkg.in/go-playground/assert.v1"
+)
+
+// Director with tasks of other deployments in flight that accepts new
+// deployments as task 42
+type provisionBoshClient struct {
+	fakeBoshClient
+	inFlight []bosh.Task
+}
+
+func (provisionBoshClient) GetCloudConfig() (*bosh.CloudConfig, error) {
+	return &bosh.CloudConfig{}, nil
+}
+
+func (c provisionBoshClient) GetInFlightTasks() ([]bosh.Task, error) {
+	return c.inFlight, nil
+}
+
+func (provisionBoshClient) CreateDeployment(manifest bosh.Manifest) (*bosh.Task, error) {
+	return &bosh.Task{Id: 42, State: bosh.BoshStateQueued, Deployment: manifest.Name}, nil
+}
+
+func provision(t *testing.T, client bosh.Client, naming handlers.DeploymentNaming, instanceId string) *httptest.ResponseRecorder {
+	// Instances of other tests share the in-memory DB and count against
+	// capacity of the director
+	networkNames := []string{}
+	for _, suffix := range []string{"a", "b", "c", "d", "e", "f"} {
+		networkNames = append(networkNames, "provision-net-"+suffix)
+	}
+	details := bosh.NewDetails("stemcell", "uuid", "vm", strings.Join(networkNames, ","), "https://director", "/peer", "/docker")
+	details.MaxInFlightTasks = 1
+	director := bosh.Director{Details: details, Client: client}
+	placement, err := handlers.NewPlacementStrategy(handlers.PlacementLeastLoaded)
+	Equal(t, err, nil)
+	handler, err := handlers.NewServiceLifecycleHandler(inmemory.Get(), []bosh.Director{director}, placement, naming, nil)
+	Equal(t, err, nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/v2/service_instances/{instanceId}", handler.Provision).Methods("PUT")
+	body := `{"service_id": "` + rest_models.DefaultServiceId + `", "plan_id": "` + rest_models.PermissionlessPlanId + `", "organization_guid": "org-1", "space_guid": "space-1"}`
+	request, err := http.NewRequest("PUT", "/v2/service_instances/"+instanceId+"?accepts_incomplete=true", strings.NewReader(body))
+	Equal(t, err, nil)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestProvision_DeploymentNameInUse(t *testing.T) {
+	naming := handlers.DeploymentNaming{Prefix: "fabric-", IdFormat: handlers.DeploymentIdTruncated, IdLength: 7}
+	serviceInstance := permissionedInstance("collide-1", "provision-net-a")
+	serviceInstance.DeploymentName = naming.Name("collide-1")
+	err := inmemory.Get().CreateServiceInstance(serviceInstance)
+	Equal(t, err, nil)
+
+	recorder := provision(t, provisionBoshClient{}, naming, "collide-2")
+	Equal(t, recorder.Code, http.StatusConflict)
+	Equal(t, strings.Contains(recorder.Body.String(), `"error": "DeploymentNameInUse"`), true)
+}
+
+func TestProvision_IgnoresTasksOfOtherBrokers(t *testing.T) {
+	// Deployment of a broker with broker id staging starts with the same
+	// prefix as the deployments of this broker
+	client := provisionBoshClient{inFlight: []bosh.Task{{Id: 7, State: bosh.BoshStateProcessing, Deployment: "fabric-staging-0123abcd"}}}
+
+	recorder := provision(t, client, handlers.DefaultDeploymentNaming(), "provision-1")
+	Equal(t, recorder.Code, http.StatusAccepted)
+	response := map[string]string{}
+	err := json.NewDecoder(recorder.Body).Decode(&response)
+	Equal(t, err, nil)
+	Equal(t, response["operation"], "42")
+}
+
+// Director on which the deployments of given tasks were created and new
+// deployments can be deleted as task 45
+type dispatchBoshClient struct {
+	provisionBoshClient
+	deploymentTasks map[string][]bosh.Task
+}
+
+func (c dispatchBoshClient) GetDeploymentTasks(deploymentName string) ([]bosh.Task, error) {
+	return c.deploymentTasks[deploymentName], nil
+}
+
+func (dispatchBoshClient) DeleteDeployment(deploymentName string) (*bosh.Task, error) {
+	return &bosh.Task{Id: 45, State: bosh.BoshStateQueued, Deployment: deploymentName}, nil
+}
+
+func TestReconcileDispatches_AfterRestart(t *testing.T) {
+	// Broker stopped after creating the deployment of dispatch-1 but before
+	// saving its task, and before creating the deployment of dispatch-2
+	for _, instanceId := range []string{"dispatch-1", "dispatch-2", "dispatch-3"} {
+		serviceInstance := permissionedInstance(instanceId, "dispatch-net-"+instanceId)
+		serviceInstance.ProvisionTaskId = ""
+		serviceInstance.State = models.InstanceStateDispatching
+		err := inmemory.Get().CreateServiceInstance(serviceInstance)
+		Equal(t, err, nil)
+		defer inmemory.Get().DeleteServiceInstance(instanceId)
+	}
+	client := dispatchBoshClient{deploymentTasks: map[string][]bosh.Task{
+		"fabric-dispatch-1": {{Id: 43, State: bosh.BoshStateProcessing, Deployment: "fabric-dispatch-1"}},
+		"fabric-dispatch-3": {{Id: 44, State: bosh.BoshStateDone, Deployment: "fabric-dispatch-3"}},
+	}}
+
+	details := bosh.NewDetails("stemcell", "uuid", "vm", "dispatch-net-a", "https://director", "/peer", "/docker")
+	details.MaxInFlightTasks = 1
+	placement, err := handlers.NewPlacementStrategy(handlers.PlacementLeastLoaded)
+	Equal(t, err, nil)
+	handler, err := handlers.NewServiceLifecycleHandler(inmemory.Get(), []bosh.Director{{Details: details, Client: client}}, placement, handlers.DefaultDeploymentNaming(), nil)
+	Equal(t, err, nil)
+
+	// Deprovision before the dispatch was resolved deletes the deployment
+	router := mux.NewRouter()
+	router.HandleFunc("/v2/service_instances/{instanceId}", handler.Deprovision).Methods("DELETE")
+	request, err := http.NewRequest("DELETE", "/v2/service_instances/dispatch-3?accepts_incomplete=true", nil)
+	Equal(t, err, nil)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	Equal(t, recorder.Code, http.StatusAccepted)
+	response := map[string]string{}
+	err = json.NewDecoder(recorder.Body).Decode(&response)
+	Equal(t, err, nil)
+	Equal(t, response["operation"], "45")
+
+	handler.ReconcileDispatches()
+
+	serviceInstance, err := inmemory.Get().FindServiceInstance("dispatch-1")
+	Equal(t, err, nil)
+	Equal(t, serviceInstance.IsDispatching(), false)
+	Equal(t, serviceInstance.ProvisionTaskId, "43")
+
+	serviceInstance, err = inmemory.Get().FindServiceInstance("dispatch-2")
+	Equal(t, err, nil)
+	Equal(t, serviceInstance.IsQueued(), true)
+	Equal(t, serviceInstance.ProvisionTaskId, "")
+}
+
+func TestDirectorsConfigAddedToSingleDirector(t *testing.T) {
+	recorder := provision(t, provisionBoshClient{}, handlers.DefaultDeploymentNaming(), "upgrade-1")
+	Equal(t, recorder.Code, http.StatusAccepted)
+	defer inmemory.Get().DeleteServiceInstance("upgrade-1")
+	provisioned, err := inmemory.Get().FindServiceInstance("upgrade-1")
+	Equal(t, err, nil)
+	Equal(t, provisioned.DirectorName, "")
+
+	// Saved with the name of the default director by earlier versions
+	legacy := permissionedInstance("upgrade-2", "upgrade-net")
+	legacy.DirectorName = bosh.DefaultDirectorName
+	err = inmemory.Get().CreateServiceInstance(legacy)
+	Equal(t, err, nil)
+	defer inmemory.Get().DeleteServiceInstance(legacy.Id)
+
+	// Only the first director can delete deployments
+	directors := []bosh.Director{
+		{Details: &bosh.Details{Name: "site-a", NetworkNames: []string{"site-a-net"}}, Client: dispatchBoshClient{}},
+		{Details: &bosh.Details{Name: "site-b", NetworkNames: []string{"site-b-net"}}, Client: fakeBoshClient{}},
+	}
+	placement, err := handlers.NewPlacementStrategy(handlers.PlacementLeastLoaded)
+	Equal(t, err, nil)
+	handler, err := handlers.NewServiceLifecycleHandler(inmemory.Get(), directors, placement, handlers.DefaultDeploymentNaming(), nil)
+	Equal(t, err, nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/v2/service_instances/{instanceId}", handler.Deprovision).Methods("DELETE")
+	for _, instanceId := range []string{"upgrade-1", "upgrade-2"} {
+		request, err := http.NewRequest("DELETE", "/v2/service_instances/"+instanceId+"?accepts_incomplete=true", nil)
+		Equal(t, err, nil)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		Equal(t, recorder.Code, http.StatusAccepted)
+	}
+}
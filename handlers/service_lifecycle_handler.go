@@ -2,21 +2,27 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/predix/fabric-service-broker/bosh"
+	"github.com/predix/fabric-service-broker/credhub"
 	"github.com/predix/fabric-service-broker/db"
 	"github.com/predix/fabric-service-broker/db/models"
 	sberrors "github.com/predix/fabric-service-broker/errors"
+	"github.com/predix/fabric-service-broker/health"
 	"github.com/predix/fabric-service-broker/rest_models"
 )
 
 type ServiceLifecycleHandler interface {
 	Provision(w http.ResponseWriter, r *http.Request)
+	GetInstance(w http.ResponseWriter, r *http.Request)
 	Deprovision(w http.ResponseWriter, r *http.Request)
 	LastOperation(w http.ResponseWriter, r *http.Request)
 	Bind(w http.ResponseWriter, r *http.Request)
@@ -25,55 +31,188 @@ type ServiceLifecycleHandler interface {
 
 var asyncResponse = `
 {
- "operation": "%d"
+ "operation": "%s"
 }
 `
 
-type slHandler struct {
-	boshDetails       *bosh.Details
-	modelsRepo        db.ModelsRepo
+const (
+	peerJobName = "peer"
+
+	taskPollInterval = 5 * time.Second
+	cancelTimeout    = 30 * time.Minute
+)
+
+// director holds the networks of a BOSH director not used by any service
+// instance yet
+type director struct {
+	details           *bosh.Details
+	client            bosh.Client
+	credhub           credhub.Client
 	availableNetworks map[string]struct{}
-	boshClient        bosh.Client
-	lock              *sync.Mutex
 }
 
-func NewServiceLifecycleHandler(repo db.ModelsRepo, boshClient bosh.Client, boshDetails *bosh.Details) ServiceLifecycleHandler {
+type slHandler struct {
+	directors       []*director
+	directorsByName map[string]*director
+	placement       PlacementStrategy
+	naming          DeploymentNaming
+	modelsRepo      db.ModelsRepo
+	peerPinger      health.Pinger
+	lock            *sync.Mutex
+	// Dispatched instances whose provision task could not be saved yet
+	unsavedDispatches map[string]models.ServiceInstance
+}
+
+// Returned handler implements both ServiceLifecycleHandler and AdminHandler.
+// New instances are placed on one of the directors using placement and
+// their deployments are named using naming. The first director is used for
+// instances created before multiple directors were supported. peerPinger can
+// be nil in which case peer health is determined from BOSH instance state
+// only.
+func NewServiceLifecycleHandler(repo db.ModelsRepo, directors []bosh.Director, placement PlacementStrategy, naming DeploymentNaming, peerPinger health.Pinger) (*slHandler, error) {
+	if len(directors) == 0 {
+		return nil, errors.New("At least one director is required")
+	}
+	err := naming.Validate()
+	if err != nil {
+		return nil, err
+	}
 
 	s := &slHandler{
-		boshDetails:       boshDetails,
+		directorsByName:   make(map[string]*director),
+		placement:         placement,
+		naming:            naming,
 		modelsRepo:        repo,
-		boshClient:        boshClient,
-		availableNetworks: make(map[string]struct{}),
+		peerPinger:        peerPinger,
 		lock:              &sync.Mutex{},
+		unsavedDispatches: make(map[string]models.ServiceInstance),
+	}
+	for _, d := range directors {
+		director := &director{
+			details:           d.Details,
+			client:            d.Client,
+			credhub:           d.Credhub,
+			availableNetworks: make(map[string]struct{}),
+		}
+		s.directors = append(s.directors, director)
+		s.directorsByName[d.Details.Name] = director
 	}
 
-	s.RefreshAvailableNetworks()
+	err = s.RefreshAvailableNetworks()
+	if err != nil {
+		return nil, err
+	}
 
-	return s
+	return s, nil
 }
 
-func (s *slHandler) RefreshAvailableNetworks() {
+// Refreshes networks available on each director. Returns an error if
+// service instances cannot be read or if one was deployed by a director that
+// is not configured.
+func (s *slHandler) RefreshAvailableNetworks() error {
 	log.Debug("Refreshing available networks")
 
 	s.lock.Lock()
-	defer func() {
-		s.lock.Unlock()
-		log.Debugf("Available networks: %s", s.availableNetworks)
-	}()
+	defer s.lock.Unlock()
+
+	// Read first so that networks in use are not made available if the DB
+	// cannot be read
+	serviceInstances, err := s.modelsRepo.ListServiceInstances()
+	if err != nil {
+		log.Error("Unable to fetch service instances from db", err)
+		return err
+	}
+
+	for _, director := range s.directors {
+		director.availableNetworks = make(map[string]struct{})
+		for _, networkName := range director.details.NetworkNames {
+			director.availableNetworks[networkName] = struct{}{}
+		}
+	}
 
-	for _, networkName := range s.boshDetails.NetworkNames {
-		s.availableNetworks[networkName] = struct{}{}
+	for i := range serviceInstances {
+		director := s.directorFor(&serviceInstances[i])
+		if director == nil {
+			return errors.New(fmt.Sprintf("Director %s of instance:%s is not configured", serviceInstances[i].DirectorName, serviceInstances[i].Id))
+		}
+		delete(director.availableNetworks, serviceInstances[i].NetworkName)
 	}
 
+	for _, director := range s.directors {
+		log.Debugf("Available networks on director %s: %s", director.details.Name, director.availableNetworks)
+	}
+	return nil
+}
+
+// Returns the director that deployed the service instance
+func (s *slHandler) directorFor(serviceInstance *models.ServiceInstance) *director {
+	// Instances created without directors config have no director name, or
+	// the name of the default director if saved by earlier versions. They
+	// belong to the first director unless one is named like the default.
+	name := serviceInstance.DirectorName
+	if name == "" {
+		name = bosh.DefaultDirectorName
+	}
+	director, found := s.directorsByName[name]
+	if found {
+		return director
+	}
+	if name == bosh.DefaultDirectorName {
+		return s.directors[0]
+	}
+	return nil
+}
+
+// Returns name of director recorded with instances it deploys. Instances of
+// the default director used without directors config get no name so that
+// they move to the first director once directors are configured.
+func (s *slHandler) directorName(director *director) string {
+	if director.details.Name == bosh.DefaultDirectorName {
+		return ""
+	}
+	return director.details.Name
+}
+
+// Returns client for the director that deployed the service instance
+func (s *slHandler) clientFor(serviceInstance *models.ServiceInstance) bosh.Client {
+	return s.directorFor(serviceInstance).client
+}
+
+// Plan settings are the same for all directors
+func (s *slHandler) planConfig(planId string) bosh.PlanConfig {
+	return s.directors[0].details.PlanConfig(planId)
+}
+
+// Chooses director for a new instance of the plan among those having a free
+// network and capacity left. Returns nil if there is none. Caller is
+// expected to hold the lock.
+func (s *slHandler) placeInstance(plan bosh.PlanConfig) (*director, error) {
 	serviceInstances, err := s.modelsRepo.ListServiceInstances()
 	if err != nil {
-		log.Error("Unable to fetch service instances from db", err)
-		return
+		return nil, err
 	}
 
-	for _, serviceInstance := range serviceInstances {
-		delete(s.availableNetworks, serviceInstance.NetworkName)
+	instanceCounts := make(map[*director]int)
+	for i := range serviceInstances {
+		instanceCounts[s.directorFor(&serviceInstances[i])]++
+	}
+
+	candidates := []DirectorLoad{}
+	candidateDirectors := []*director{}
+	for _, director := range s.directors {
+		instances := instanceCounts[director]
+		if len(director.availableNetworks) == 0 || instances >= director.details.Capacity() {
+			continue
+		}
+		candidates = append(candidates, DirectorLoad{Details: director.details, Instances: instances})
+		candidateDirectors = append(candidateDirectors, director)
+	}
+
+	chosen := s.placement.Choose(candidates, plan)
+	if chosen < 0 {
+		return nil, nil
 	}
+	return candidateDirectors[chosen], nil
 }
 
 // We are locking the mutex for entire operation essentially serializing
@@ -119,33 +258,40 @@ func (s *slHandler) Provision(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get the first available network
-	var networkName string
-	for netName, _ := range s.availableNetworks {
-		networkName = netName
-	}
-
-	if networkName == "" {
-		handleOutOfNetworks(w)
+	deploymentName := s.naming.Name(instanceId)
+	err = ValidateDeploymentName(deploymentName)
+	if err != nil {
+		handleBadRequest(err.Error(), w)
 		return
 	}
-	log.Debugf("Network name selected for this deployment: %s", networkName)
-
-	deploymentName := fmt.Sprintf("fabric-%s", instanceId)
-	permissioned := s.isPermissioned(serviceProvisionRequest.PlanId)
-
-	manifest, err := bosh.NewManifest(deploymentName, networkName, permissioned, s.boshDetails)
+	inUse, err := s.isDeploymentNameInUse(deploymentName)
 	if err != nil {
-		handleManifestGenerationError(err, w)
+		handleDBReadError(err, w)
+		return
+	}
+	if inUse {
+		handleDeploymentNameInUse(deploymentName, w)
 		return
 	}
-	log.Debugf("Manifest generated for deployment")
 
-	task, err := s.boshClient.CreateDeployment(*manifest)
+	plan := s.planConfig(serviceProvisionRequest.PlanId)
+	director, err := s.placeInstance(plan)
 	if err != nil {
-		handleInternalServerError(err, w)
+		handleDBReadError(err, w)
 		return
 	}
+	if director == nil {
+		handleOutOfNetworks(w)
+		return
+	}
+	log.Debugf("Director selected for this deployment: %s", director.details.Name)
+
+	// Get the first available network
+	var networkName string
+	for netName, _ := range director.availableNetworks {
+		networkName = netName
+	}
+	log.Debugf("Network name selected for this deployment: %s", networkName)
 
 	serviceInstance := models.ServiceInstance{
 		BaseModel:           models.BaseModel{Id: instanceId},
@@ -153,23 +299,139 @@ func (s *slHandler) Provision(w http.ResponseWriter, r *http.Request) {
 		PlanId:              serviceProvisionRequest.PlanId,
 		OrganizationGuid:    serviceProvisionRequest.OrganizationGuid,
 		SpaceGuid:           serviceProvisionRequest.SpaceGuid,
+		DirectorName:        s.directorName(director),
 		DeploymentName:      deploymentName,
 		NetworkName:         networkName,
 		BlockchainNetworkId: instanceId,
-		ProvisionTaskId:     strconv.Itoa(task.Id),
 		DeprovisionTaskId:   "",
+		LastActiveAt:        time.Now(),
+		PostDeployErrand:    plan.PostDeployErrand,
+	}
+
+	if provisionContext := serviceProvisionRequest.Context; provisionContext != nil {
+		if serviceInstance.OrganizationGuid == "" {
+			serviceInstance.OrganizationGuid = provisionContext.OrganizationGuid
+		}
+		if serviceInstance.SpaceGuid == "" {
+			serviceInstance.SpaceGuid = provisionContext.SpaceGuid
+		}
+		serviceInstance.OrganizationName = provisionContext.OrganizationName
+		serviceInstance.SpaceName = provisionContext.SpaceName
+		serviceInstance.InstanceName = provisionContext.InstanceName
+	}
+
+	err = serviceInstance.EncodeParameters(serviceProvisionRequest.Parameters)
+	if err != nil {
+		handleBadRequest(err.Error(), w)
+		return
+	}
+
+	// Generated even if the provision gets queued to reject invalid
+	// requests right away
+	manifest, err := s.newManifest(&serviceInstance)
+	if err != nil {
+		handleManifestGenerationError(err, w)
+		return
+	}
+	cloudConfig, err := director.client.GetCloudConfig()
+	if err != nil {
+		handleBoshError(err, w)
+		return
+	}
+	err = manifest.ValidateAZs(cloudConfig)
+	if err != nil {
+		handleManifestGenerationError(err, w)
+		return
 	}
+
+	queue, err := s.mustQueueProvision(director)
+	if err != nil {
+		handleBoshError(err, w)
+		return
+	}
+
+	operation := queuedOperation
+	if queue {
+		log.Infof("Too many BOSH tasks in flight, queueing provision of instance:%s", instanceId)
+		serviceInstance.State = models.InstanceStateQueued
+		serviceInstance.QueuedAt = time.Now()
+	} else {
+		task, err := s.startDeployment(&serviceInstance, manifest)
+		if err != nil {
+			handleBoshError(err, w)
+			return
+		}
+		operation = strconv.Itoa(task.Id)
+	}
+
 	err = s.modelsRepo.CreateServiceInstance(serviceInstance)
 	if err != nil {
 		handleDBSaveError(err, w)
 		return
 	}
 	log.Debugf("Service instance saved to DB")
-	delete(s.availableNetworks, networkName)
-	log.Debugf("Network %s deleted from available networks", networkName)
+	s.placement.Placed(director.details.Name)
+	delete(director.availableNetworks, networkName)
+	log.Debugf("Network %s deleted from available networks of director %s", networkName, director.details.Name)
 
 	w.WriteHeader(http.StatusAccepted)
-	w.Write([]byte(fmt.Sprintf(asyncResponse, task.Id)))
+	w.Write([]byte(fmt.Sprintf(asyncResponse, operation)))
+}
+
+func (s *slHandler) GetInstance(w http.ResponseWriter, r *http.Request) {
+	log.Info("Handling GET /v2/service_instances/:instanceId")
+
+	vars := mux.Vars(r)
+	instanceId := vars["instanceId"]
+	serviceInstance, err := s.modelsRepo.FindServiceInstance(instanceId)
+	if err != nil {
+		handleDBReadError(err, w)
+		return
+	}
+	if serviceInstance == nil {
+		handleNotFound("{}", w)
+		return
+	}
+
+	parameters, err := serviceInstance.DecodeParameters()
+	if err != nil {
+		handleDBReadError(err, w)
+		return
+	}
+
+	instanceResponse := rest_models.ServiceInstanceResponse{
+		ServiceId:  serviceInstance.ServiceId,
+		PlanId:     serviceInstance.PlanId,
+		Parameters: parameters,
+	}
+
+	provisionState, err := s.provisionTaskState(serviceInstance)
+	if err != nil {
+		handleBoshConnectError(err, w)
+		return
+	}
+	if provisionState.IsSuccess() {
+		healthReport, err := s.healthReport(serviceInstance)
+		if err != nil {
+			handleBoshError(err, w)
+			return
+		}
+		instanceResponse.Health = healthReport
+	}
+
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.Encode(instanceResponse)
+}
+
+func (s *slHandler) healthReport(serviceInstance *models.ServiceInstance) (*health.Report, error) {
+	instances, err := s.clientFor(serviceInstance).GetInstanceDetails(serviceInstance.DeploymentName)
+	if err != nil {
+		return nil, err
+	}
+	healthReport := health.NewReport(instances, peerJobName, s.peerPinger)
+	log.Debugf("Health of instance:%s is %s", serviceInstance.Id, healthReport.Status)
+	return &healthReport, nil
 }
 
 func (s *slHandler) Deprovision(w http.ResponseWriter, r *http.Request) {
@@ -194,13 +456,34 @@ func (s *slHandler) Deprovision(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	isProvisionComplete, err := s.isProvisionComplete(serviceInstance)
+	if serviceInstance.IsDispatching() {
+		// Left in dispatching state by an interrupted dispatch, resolve the
+		// provision task so that its deployment gets deleted below
+		err = s.reconcileDispatch(serviceInstance)
+		if err != nil {
+			handleBoshError(err, w)
+			return
+		}
+	}
+	if serviceInstance.IsQueued() || serviceInstance.IsDispatchFailed() {
+		log.Infof("Provision of instance:%s was not dispatched, removing service instance", instanceId)
+		err = s.removeServiceInstance(serviceInstance)
+		if err != nil {
+			handleDBDeleteError(err, w)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("{}"))
+		return
+	}
+
+	provisionState, err := s.provisionTaskState(serviceInstance)
 	if err != nil {
 		handleBoshConnectError(err, w)
 		return
 	}
-	if !isProvisionComplete {
-		handleServiceInstanceInflight(instanceId, w)
+	if !provisionState.IsTerminal() {
+		s.cancelProvisionAndDeprovision(serviceInstance, w)
 		return
 	}
 
@@ -216,9 +499,20 @@ func (s *slHandler) Deprovision(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Debugf("No bindings for service instance :%d", instanceId)
 
-	task, err := s.boshClient.DeleteDeployment(serviceInstance.DeploymentName)
+	task, err := s.clientFor(serviceInstance).DeleteDeployment(serviceInstance.DeploymentName)
+	if bosh.IsNotFoundError(err) {
+		log.Infof("Deployment %s does not exist, removing service instance", serviceInstance.DeploymentName)
+		err = s.removeServiceInstance(serviceInstance)
+		if err != nil {
+			handleDBDeleteError(err, w)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("{}"))
+		return
+	}
 	if err != nil {
-		handleInternalServerError(err, w)
+		handleBoshError(err, w)
 		return
 	}
 
@@ -232,7 +526,7 @@ func (s *slHandler) Deprovision(w http.ResponseWriter, r *http.Request) {
 	log.Debug("Saved service instance to DB")
 
 	w.WriteHeader(http.StatusAccepted)
-	w.Write([]byte(fmt.Sprintf(asyncResponse, task.Id)))
+	w.Write([]byte(fmt.Sprintf(asyncResponse, strconv.Itoa(task.Id))))
 }
 
 func (s *slHandler) LastOperation(w http.ResponseWriter, r *http.Request) {
@@ -256,38 +550,252 @@ func (s *slHandler) LastOperation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Debugf("Checking status of task:%s", taskId[0])
+	operationTaskId := taskId[0]
+	if operationTaskId == queuedOperation {
+		if serviceInstance.IsQueued() {
+			position, queueLength, err := s.queuePosition(serviceInstance)
+			if err != nil {
+				handleDBReadError(err, w)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			encoder := json.NewEncoder(w)
+			encoder.Encode(rest_models.NewQueuedOperationResponse(position, queueLength))
+			return
+		}
+		if serviceInstance.IsDispatching() || serviceInstance.IsDispatchFailed() {
+			w.WriteHeader(http.StatusOK)
+			encoder := json.NewEncoder(w)
+			encoder.Encode(rest_models.NewDispatchOperationResponse(serviceInstance.DispatchError))
+			return
+		}
+		log.Debugf("Queued provision was dispatched as task:%s", serviceInstance.ProvisionTaskId)
+		operationTaskId = serviceInstance.ProvisionTaskId
+	}
+	if serviceInstance.CancelledTaskId != "" && operationTaskId == serviceInstance.CancelledTaskId {
+		if serviceInstance.DeprovisionTaskId == "" {
+			lastOperationResponse, err := s.cancelledProvisionStatus(serviceInstance)
+			if err != nil {
+				handleBoshError(err, w)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			encoder := json.NewEncoder(w)
+			encoder.Encode(lastOperationResponse)
+			return
+		}
+		log.Debugf("Cancelled task:%s was followed by delete task:%s", operationTaskId, serviceInstance.DeprovisionTaskId)
+		operationTaskId = serviceInstance.DeprovisionTaskId
+	}
+
+	log.Debugf("Checking status of task:%s", operationTaskId)
 
-	task, err := s.boshClient.GetTask(taskId[0])
+	task, err := s.clientFor(serviceInstance).GetTask(operationTaskId)
 	if err != nil {
-		handleInternalServerError(err, w)
+		handleBoshError(err, w)
 		return
 	}
 
 	operation := rest_models.OpProvision
-	if serviceInstance.DeprovisionTaskId == taskId[0] {
+	switch operationTaskId {
+	case serviceInstance.DeprovisionTaskId:
 		operation = rest_models.OpDeprovision
+	case serviceInstance.SuspendTaskId:
+		operation = rest_models.OpSuspend
+	case serviceInstance.ResumeTaskId:
+		operation = rest_models.OpResume
+	}
+
+	events, err := s.clientFor(serviceInstance).GetTaskEvents(operationTaskId)
+	if err != nil {
+		log.Warningf("Unable to get events for task:%s, %s", operationTaskId, err)
 	}
 
-	lastOperationResponse := rest_models.GetLastOperationResponse(operation, task.State)
+	lastOperationResponse := rest_models.NewLastOperationResponse(operation, task, events)
 	if lastOperationResponse.State == rest_models.StateSucceeded &&
-		taskId[0] == serviceInstance.DeprovisionTaskId {
+		operationTaskId == serviceInstance.ProvisionTaskId &&
+		serviceInstance.PostDeployErrand != "" {
+		lastOperationResponse, err = s.postDeployErrandStatus(serviceInstance)
+		if err != nil {
+			handleBoshError(err, w)
+			return
+		}
+	}
+	if task.State.IsTerminal() &&
+		(operation == rest_models.OpSuspend || operation == rest_models.OpResume) {
+		// Instance is read again as it can have changed before the lock was
+		// acquired
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		serviceInstance, err = s.modelsRepo.FindServiceInstance(instanceId)
+		if err != nil {
+			handleDBReadError(err, w)
+			return
+		}
+		if serviceInstance == nil {
+			handleServiceInstanceGone(instanceId, w)
+			return
+		}
+		err = s.updateSuspendState(serviceInstance)
+		if err != nil {
+			handleBoshError(err, w)
+			return
+		}
+	}
+	if lastOperationResponse.State == rest_models.StateSucceeded &&
+		operationTaskId == serviceInstance.DeprovisionTaskId {
 		log.Info("Delete operation succeeded. Removing entry from DB")
 		s.lock.Lock()
 		defer s.lock.Unlock()
-		_, err := s.modelsRepo.DeleteServiceInstance(serviceInstance.Id)
+		err := s.removeServiceInstance(serviceInstance)
 		if err != nil {
 			handleDBDeleteError(err, w)
 			return
 		}
-		log.Infof("Returning network %s back to available pool", serviceInstance.NetworkName)
-		s.availableNetworks[serviceInstance.NetworkName] = struct{}{}
 	}
 	w.WriteHeader(http.StatusOK)
 	encoder := json.NewEncoder(w)
 	encoder.Encode(lastOperationResponse)
 }
 
+// Cancels the in-flight provision task and responds with the id of cancelled
+// task as operation. The deployment is deleted in background once the
+// cancellation completes and last operation for the cancelled task id then
+// reports the state of delete task.
+func (s *slHandler) cancelProvisionAndDeprovision(serviceInstance *models.ServiceInstance, w http.ResponseWriter) {
+	taskId := serviceInstance.ProvisionTaskId
+	if serviceInstance.ErrandTaskId != "" {
+		taskId = serviceInstance.ErrandTaskId
+	}
+	if serviceInstance.CancelledTaskId == taskId {
+		log.Infof("Provision task:%s for instance:%s is already being cancelled", taskId, serviceInstance.Id)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(fmt.Sprintf(asyncResponse, taskId)))
+		return
+	}
+
+	task, err := s.clientFor(serviceInstance).GetTask(taskId)
+	if err != nil {
+		handleBoshError(err, w)
+		return
+	}
+
+	// Task can already be terminal if post deploy errand has not started yet
+	if !task.State.IsTerminal() && task.State != bosh.BoshStateCancelling {
+		log.Infof("Cancelling in-flight provision task:%s for instance:%s", taskId, serviceInstance.Id)
+		err := s.clientFor(serviceInstance).CancelTask(taskId)
+		if err != nil {
+			handleBoshError(err, w)
+			return
+		}
+	}
+
+	serviceInstance.CancelledTaskId = taskId
+	err = s.modelsRepo.UpdateServiceInstance(*serviceInstance)
+	if err != nil {
+		handleDBSaveError(err, w)
+		return
+	}
+	log.Debug("Saved service instance to DB")
+
+	go s.deleteAfterCancel(s.clientFor(serviceInstance), serviceInstance.Id, taskId)
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte(fmt.Sprintf(asyncResponse, taskId)))
+}
+
+func (s *slHandler) deleteAfterCancel(boshClient bosh.Client, instanceId, taskId string) {
+	task, err := bosh.WaitForTask(boshClient, taskId, taskPollInterval, cancelTimeout)
+	if err != nil {
+		log.Error("Error waiting for cancellation of provision task", err)
+		return
+	}
+	log.Infof("Cancelled provision task:%s ended in state %s", taskId, task.State)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, err = s.deleteCancelledDeployment(instanceId)
+	if err != nil {
+		log.Error("Error deleting deployment after cancelling provision", err)
+	}
+}
+
+// Starts deletion of deployment for service instance whose provision task
+// was cancelled. If the task was cancelled before the deployment got created
+// the service instance is removed right away and true is returned.
+// Caller is expected to hold the lock.
+func (s *slHandler) deleteCancelledDeployment(instanceId string) (bool, error) {
+	serviceInstance, err := s.modelsRepo.FindServiceInstance(instanceId)
+	if err != nil {
+		return false, err
+	}
+	if serviceInstance == nil {
+		log.Debugf("Instance:%s already deleted", instanceId)
+		return true, nil
+	}
+	if serviceInstance.DeprovisionTaskId != "" {
+		log.Debugf("Deletion of instance:%s already started", instanceId)
+		return false, nil
+	}
+
+	task, err := s.clientFor(serviceInstance).DeleteDeployment(serviceInstance.DeploymentName)
+	if bosh.IsNotFoundError(err) {
+		log.Infof("Deployment %s was never created", serviceInstance.DeploymentName)
+		return true, s.removeServiceInstance(serviceInstance)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	serviceInstance.DeprovisionTaskId = strconv.Itoa(task.Id)
+	return false, s.modelsRepo.UpdateServiceInstance(*serviceInstance)
+}
+
+// Removes service instance from DB and returns its network back to the
+// available pool. Caller is expected to hold the lock.
+func (s *slHandler) removeServiceInstance(serviceInstance *models.ServiceInstance) error {
+	_, err := s.modelsRepo.DeleteServiceInstance(serviceInstance.Id)
+	if err != nil {
+		return err
+	}
+	director := s.directorFor(serviceInstance)
+	log.Infof("Returning network %s back to available pool of director %s", serviceInstance.NetworkName, director.details.Name)
+	director.availableNetworks[serviceInstance.NetworkName] = struct{}{}
+	return nil
+}
+
+// Reports progress of deprovision whose provision task is still being
+// cancelled. If cancellation has completed but the background deletion has
+// not started (e.g. after a restart of broker), the deletion is started here.
+func (s *slHandler) cancelledProvisionStatus(serviceInstance *models.ServiceInstance) (rest_models.LastOperationResponse, error) {
+	lastOperationResponse := rest_models.LastOperationResponse{
+		State:       rest_models.StateInProgress,
+		Description: "Cancelling in-flight deployment of block chain before deleting it",
+	}
+
+	task, err := s.clientFor(serviceInstance).GetTask(serviceInstance.CancelledTaskId)
+	if err != nil {
+		return lastOperationResponse, err
+	}
+	if !task.State.IsTerminal() {
+		return lastOperationResponse, nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	deleted, err := s.deleteCancelledDeployment(serviceInstance.Id)
+	if err != nil {
+		return lastOperationResponse, err
+	}
+	if deleted {
+		return rest_models.GetLastOperationResponse(rest_models.OpDeprovision, bosh.BoshStateDone), nil
+	}
+
+	lastOperationResponse.Description = "Still working to delete that block chain"
+	return lastOperationResponse, nil
+}
+
 func (s *slHandler) Bind(w http.ResponseWriter, r *http.Request) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
@@ -333,21 +841,48 @@ func (s *slHandler) Bind(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Debugf("Deployment name for instance:%s is %s", instanceId, serviceInstance.DeploymentName)
 
-	isProvisionComplete, err := s.isProvisionComplete(serviceInstance)
+	provisionState, err := s.provisionTaskState(serviceInstance)
 	if err != nil {
 		handleBoshConnectError(err, w)
 		return
 	}
 
-	if !isProvisionComplete {
+	if !provisionState.IsTerminal() {
 		handleServiceInstanceInflight(instanceId, w)
 		return
 	}
+	if !provisionState.IsSuccess() {
+		handleServiceInstanceProvisionFailed(instanceId, provisionState, w)
+		return
+	}
+
+	resuming, err := s.resumeIfSuspended(serviceInstance)
+	if err != nil {
+		handleBoshError(err, w)
+		return
+	}
+	if resuming {
+		handleServiceInstanceResuming(instanceId, w)
+		return
+	}
 
-	vmsIps, err := s.boshClient.GetVmIps(serviceInstance.DeploymentName)
+	instances, err := s.clientFor(serviceInstance).GetInstanceDetails(serviceInstance.DeploymentName)
 	if err != nil {
 		log.Error("Error in getting VM details", err)
-		handleInternalServerError(err, w)
+		handleBoshError(err, w)
+		return
+	}
+
+	healthReport := health.NewReport(instances, peerJobName, s.peerPinger)
+	if healthReport.MajorityFailing() {
+		handleServiceInstanceUnhealthy(instanceId, healthReport, w)
+		return
+	}
+
+	memberServiceUsers, err := s.memberServiceUsers(serviceInstance)
+	if err != nil {
+		log.Error("Error in getting membership service users", err)
+		handleBoshError(err, w)
 		return
 	}
 
@@ -363,7 +898,7 @@ func (s *slHandler) Bind(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.writeBindingResponse(vmsIps, w)
+	s.writeBindingResponse(instances, memberServiceUsers, w)
 }
 
 func (s *slHandler) Unbind(w http.ResponseWriter, r *http.Request) {
@@ -403,12 +938,88 @@ func (s *slHandler) Unbind(w http.ResponseWriter, r *http.Request) {
 		handleDBSaveError(err, w)
 		return
 	}
+
+	serviceInstance.LastActiveAt = time.Now()
+	err = s.modelsRepo.UpdateServiceInstance(*serviceInstance)
+	if err != nil {
+		handleDBSaveError(err, w)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("{}"))
 }
 
-func (s *slHandler) writeBindingResponse(vmsIps map[string][]string, w http.ResponseWriter) {
-	peerIps := vmsIps["peer"]
+// Returns membership service clients of permissioned instance along with
+// their secrets. Secrets are read from config server of the director unless
+// they were generated by the broker.
+func (s *slHandler) memberServiceUsers(serviceInstance *models.ServiceInstance) ([]rest_models.MemberServiceUser, error) {
+	if !s.isPermissioned(serviceInstance.PlanId) {
+		return nil, nil
+	}
+
+	secrets, err := bosh.ParseSecrets(serviceInstance.Secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	director := s.directorFor(serviceInstance)
+	if len(secrets) == 0 && director.credhub == nil {
+		log.Warningf("No secrets available for instance:%s", serviceInstance.Id)
+		return nil, nil
+	}
+
+	var directorName string
+	if len(secrets) == 0 {
+		info, err := director.client.GetInfo()
+		if err != nil {
+			return nil, err
+		}
+		directorName = info.Name
+	}
+
+	names, err := s.memberServiceClientNames(serviceInstance)
+	if err != nil {
+		return nil, err
+	}
+
+	users := []rest_models.MemberServiceUser{}
+	for _, name := range names {
+		secret, found := secrets[name]
+		if !found && directorName != "" {
+			secret, err = director.credhub.GetValue(bosh.SecretVariablePath(directorName, serviceInstance.DeploymentName, name))
+			if err != nil {
+				return nil, err
+			}
+			found = true
+		}
+		if found {
+			users = append(users, rest_models.MemberServiceUser{EnrollId: name, EnrollSecret: secret})
+		}
+	}
+	return users, nil
+}
+
+// Returns names of membership service clients of the instance, taken from
+// its deployed manifest if they were not recorded on provision
+func (s *slHandler) memberServiceClientNames(serviceInstance *models.ServiceInstance) ([]string, error) {
+	if serviceInstance.MemberServiceClients != "" {
+		return strings.Split(serviceInstance.MemberServiceClients, ","), nil
+	}
+
+	log.Debugf("Reading membership service clients of instance:%s from deployed manifest", serviceInstance.Id)
+	document, err := s.clientFor(serviceInstance).GetDeploymentManifest(serviceInstance.DeploymentName)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := bosh.ParseManifest(document)
+	if err != nil {
+		return nil, err
+	}
+	return manifest.MemberServiceClientNames(), nil
+}
+
+func (s *slHandler) writeBindingResponse(instances bosh.Instances, memberServiceUsers []rest_models.MemberServiceUser, w http.ResponseWriter) {
+	peerIps := instances.ByJob(peerJobName).IPs()
 
 	peerEndpoints := make([]string, 0)
 
@@ -418,10 +1029,12 @@ func (s *slHandler) writeBindingResponse(vmsIps map[string][]string, w http.Resp
 
 	bindCredentials := rest_models.BindCredentials{
 		Credentials: rest_models.BlockChainCredentials{
-			PeerEndpoints: peerEndpoints,
+			PeerEndpoints:      peerEndpoints,
+			MemberServiceUsers: memberServiceUsers,
 		},
 	}
-	log.Debugf("Created binding crendentials:%#v", bindCredentials)
+	// Not logging credentials as they contain secrets
+	log.Debugf("Created binding credentials with %d peers and %d membership service users", len(peerEndpoints), len(memberServiceUsers))
 
 	w.WriteHeader(http.StatusCreated)
 	encoder := json.NewEncoder(w)
@@ -440,15 +1053,120 @@ func (s *slHandler) isAsyncRequest(w http.ResponseWriter, r *http.Request) bool
 	return true
 }
 
-func (s *slHandler) isProvisionComplete(serviceInstance *models.ServiceInstance) (bool, error) {
-	task, err := s.boshClient.GetTask(serviceInstance.ProvisionTaskId)
+// Returns state of provisioning of the instance. If a post deploy errand
+// is configured for the instance, provisioning is only complete once the
+// errand has succeeded. Queued provisions are reported as queued until they
+// are dispatched and as failed if they could not be dispatched.
+func (s *slHandler) provisionTaskState(serviceInstance *models.ServiceInstance) (bosh.TaskState, error) {
+	if serviceInstance.IsQueued() || serviceInstance.IsDispatching() {
+		return bosh.BoshStateQueued, nil
+	}
+	if serviceInstance.IsDispatchFailed() {
+		return bosh.BoshStateError, nil
+	}
+
+	task, err := s.clientFor(serviceInstance).GetTask(serviceInstance.ProvisionTaskId)
 	if err != nil {
-		return false, err
+		return "", err
 	}
-	if task.State != bosh.BoshStateDone {
-		return false, nil
+	log.Debugf("Provision task:%s is in state %s", serviceInstance.ProvisionTaskId, task.State)
+	if !task.State.IsSuccess() || serviceInstance.PostDeployErrand == "" {
+		return task.State, nil
+	}
+
+	if serviceInstance.ErrandTaskId == "" {
+		log.Debugf("Errand %s not started yet", serviceInstance.PostDeployErrand)
+		return bosh.BoshStateProcessing, nil
+	}
+
+	errandTask, err := s.clientFor(serviceInstance).GetTask(serviceInstance.ErrandTaskId)
+	if err != nil {
+		return "", err
+	}
+	log.Debugf("Errand task:%s is in state %s", serviceInstance.ErrandTaskId, errandTask.State)
+	if !errandTask.State.IsSuccess() {
+		return errandTask.State, nil
+	}
+
+	result, err := s.clientFor(serviceInstance).GetErrandResult(serviceInstance.ErrandTaskId)
+	if err != nil {
+		return "", err
+	}
+	if result.ExitCode != 0 {
+		return bosh.BoshStateError, nil
+	}
+	return errandTask.State, nil
+}
+
+// Starts post deploy errand unless it has been started already. Returns nil
+// task if the instance is being deleted and the errand is not run.
+func (s *slHandler) startPostDeployErrand(instanceId string) (*bosh.Task, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Re-read as a concurrent request might have started the errand already
+	serviceInstance, err := s.modelsRepo.FindServiceInstance(instanceId)
+	if err != nil {
+		return nil, err
+	}
+	if serviceInstance == nil {
+		return nil, errors.New(fmt.Sprintf("Service instance:%s not found", instanceId))
+	}
+	if serviceInstance.ErrandTaskId != "" {
+		return s.clientFor(serviceInstance).GetTask(serviceInstance.ErrandTaskId)
+	}
+	if serviceInstance.IsBeingDeleted() {
+		// Errand would race the delete task on the deployment
+		log.Infof("Not running errand on instance:%s as it is being deleted", instanceId)
+		return nil, nil
+	}
+
+	task, err := s.clientFor(serviceInstance).RunErrand(serviceInstance.DeploymentName, serviceInstance.PostDeployErrand)
+	if err != nil {
+		return nil, err
+	}
+	serviceInstance.ErrandTaskId = strconv.Itoa(task.Id)
+	err = s.modelsRepo.UpdateServiceInstance(*serviceInstance)
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// Reports the state of post deploy errand run after successful deployment,
+// starting the errand if it has not been started yet.
+func (s *slHandler) postDeployErrandStatus(serviceInstance *models.ServiceInstance) (rest_models.LastOperationResponse, error) {
+	errandName := serviceInstance.PostDeployErrand
+
+	if serviceInstance.ErrandTaskId == "" {
+		task, err := s.startPostDeployErrand(serviceInstance.Id)
+		if err != nil {
+			return rest_models.LastOperationResponse{}, err
+		}
+		if task == nil {
+			return rest_models.NewErrandSkippedResponse(errandName), nil
+		}
+		return rest_models.NewErrandOperationResponse(errandName, task, nil, nil), nil
+	}
+
+	task, err := s.clientFor(serviceInstance).GetTask(serviceInstance.ErrandTaskId)
+	if err != nil {
+		return rest_models.LastOperationResponse{}, err
+	}
+
+	events, err := s.clientFor(serviceInstance).GetTaskEvents(serviceInstance.ErrandTaskId)
+	if err != nil {
+		log.Warningf("Unable to get events for task:%s, %s", serviceInstance.ErrandTaskId, err)
+	}
+
+	var result *bosh.ErrandResult
+	if task.State.IsSuccess() {
+		result, err = s.clientFor(serviceInstance).GetErrandResult(serviceInstance.ErrandTaskId)
+		if err != nil {
+			return rest_models.LastOperationResponse{}, err
+		}
 	}
-	return true, nil
+	return rest_models.NewErrandOperationResponse(errandName, task, events, result), nil
 }
 
 func (s *slHandler) isValidServiceIdAndPlanId(serviceId, planId string, w http.ResponseWriter) bool {
@@ -465,6 +1183,50 @@ func (s *slHandler) isValidServiceIdAndPlanId(serviceId, planId string, w http.R
 	return true
 }
 
+// Returns true if an existing instance has a deployment with given name.
+// Names can collide when instance ids are truncated or hashed.
+func (s *slHandler) isDeploymentNameInUse(deploymentName string) (bool, error) {
+	serviceInstances, err := s.modelsRepo.ListServiceInstances()
+	if err != nil {
+		return false, err
+	}
+	for _, serviceInstance := range serviceInstances {
+		if serviceInstance.DeploymentName == deploymentName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// DeploymentTags returns tags of BOSH deployment of a service instance which
+// attribute costs of its VMs and disks to the org and space owning it
+func DeploymentTags(serviceInstance *models.ServiceInstance) map[string]string {
+	tags := map[string]string{
+		"service":             rest_models.GetDefaultService().Name,
+		"service_instance_id": serviceInstance.Id,
+		"plan_id":             serviceInstance.PlanId,
+	}
+	for _, plan := range rest_models.GetDefaultService().Plans {
+		if plan.Id == serviceInstance.PlanId {
+			tags["plan_name"] = plan.Name
+		}
+	}
+
+	optional := map[string]string{
+		"organization_guid": serviceInstance.OrganizationGuid,
+		"space_guid":        serviceInstance.SpaceGuid,
+		"organization_name": serviceInstance.OrganizationName,
+		"space_name":        serviceInstance.SpaceName,
+		"instance_name":     serviceInstance.InstanceName,
+	}
+	for name, value := range optional {
+		if value != "" {
+			tags[name] = value
+		}
+	}
+	return tags
+}
+
 func (s *slHandler) isPermissioned(planId string) bool {
 	if planId == rest_models.PermissionedPlanId {
 		return true
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/predix/fabric-service-broker/bosh"
+	"github.com/predix/fabric-service-broker/db/models"
+)
+
+// Stops all VMs of the instance keeping their persistent disks. The instance
+// is marked suspended once the BOSH task succeeds, see updateSuspendState.
+// Caller is expected to hold the lock.
+func (s *slHandler) suspendInstance(serviceInstance *models.ServiceInstance) (*bosh.Task, error) {
+	log.Infof("Suspending instance:%s", serviceInstance.Id)
+	task, err := s.clientFor(serviceInstance).ChangeJobState(serviceInstance.DeploymentName, "", "", bosh.JobStateDetached)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceInstance.State = models.InstanceStateSuspending
+	serviceInstance.SuspendTaskId = strconv.Itoa(task.Id)
+	err = s.modelsRepo.UpdateServiceInstance(*serviceInstance)
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// Starts all VMs of a suspended instance. The instance is marked active once
+// the BOSH task succeeds, see updateSuspendState. Caller is expected to hold
+// the lock.
+func (s *slHandler) resumeInstance(serviceInstance *models.ServiceInstance) (*bosh.Task, error) {
+	log.Infof("Resuming instance:%s", serviceInstance.Id)
+	task, err := s.clientFor(serviceInstance).ChangeJobState(serviceInstance.DeploymentName, "", "", bosh.JobStateStarted)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceInstance.State = models.InstanceStateResuming
+	serviceInstance.ResumeTaskId = strconv.Itoa(task.Id)
+	err = s.modelsRepo.UpdateServiceInstance(*serviceInstance)
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// Updates state of an instance being suspended or resumed once its BOSH task
+// has finished. A failed suspend leaves the instance active and a failed
+// resume leaves it suspended, so that either is tried again. Caller is
+// expected to hold the lock.
+func (s *slHandler) updateSuspendState(serviceInstance *models.ServiceInstance) error {
+	taskId := ""
+	switch {
+	case serviceInstance.IsSuspending():
+		taskId = serviceInstance.SuspendTaskId
+	case serviceInstance.IsResuming():
+		taskId = serviceInstance.ResumeTaskId
+	default:
+		return nil
+	}
+
+	task, err := s.clientFor(serviceInstance).GetTask(taskId)
+	if err != nil {
+		return err
+	}
+	if !task.State.IsTerminal() {
+		return nil
+	}
+
+	switch {
+	case serviceInstance.IsSuspending() && task.State.IsSuccess():
+		log.Infof("Instance:%s is suspended", serviceInstance.Id)
+		serviceInstance.State = models.InstanceStateSuspended
+	case serviceInstance.IsSuspending():
+		log.Errorf("Suspending instance:%s ended in state %s, instance stays active", serviceInstance.Id, task.State)
+		serviceInstance.State = ""
+	case task.State.IsSuccess():
+		log.Infof("Instance:%s is resumed", serviceInstance.Id)
+		serviceInstance.State = ""
+		serviceInstance.LastActiveAt = time.Now()
+	default:
+		log.Errorf("Resuming instance:%s ended in state %s, instance stays suspended", serviceInstance.Id, task.State)
+		serviceInstance.State = models.InstanceStateSuspended
+	}
+	return s.modelsRepo.UpdateServiceInstance(*serviceInstance)
+}
+
+// Resumes a suspended instance. Returns true if the instance is suspended,
+// or is still being suspended or resumed, and hence cannot be used yet.
+// Caller is expected to hold the lock.
+func (s *slHandler) resumeIfSuspended(serviceInstance *models.ServiceInstance) (bool, error) {
+	err := s.updateSuspendState(serviceInstance)
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case serviceInstance.IsSuspended():
+		_, err := s.resumeInstance(serviceInstance)
+		return true, err
+	case serviceInstance.IsSuspending(), serviceInstance.IsResuming():
+		return true, nil
+	}
+	return false, nil
+}
+
+// RunIdleSuspender checks every checkInterval for instances without bindings
+// that have not been active for idleAfter and suspends them. It never
+// returns and is expected to be run in its own go routine.
+func (s *slHandler) RunIdleSuspender(idleAfter, checkInterval time.Duration) {
+	log.Infof("Suspending instances idle for %s", idleAfter)
+	for {
+		time.Sleep(checkInterval)
+		s.suspendIdleInstances(idleAfter)
+	}
+}
+
+func (s *slHandler) suspendIdleInstances(idleAfter time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	serviceInstances, err := s.modelsRepo.ListServiceInstances()
+	if err != nil {
+		log.Error("Unable to fetch service instances from db", err)
+		return
+	}
+
+	for i := range serviceInstances {
+		serviceInstance := &serviceInstances[i]
+		err := s.updateSuspendState(serviceInstance)
+		if err != nil {
+			log.Errorf("Unable to update suspend state of instance:%s, %s", serviceInstance.Id, err)
+			continue
+		}
+		if !s.isIdle(serviceInstance, idleAfter) {
+			continue
+		}
+
+		_, err = s.suspendInstance(serviceInstance)
+		if err != nil {
+			log.Errorf("Unable to suspend idle instance:%s, %s", serviceInstance.Id, err)
+		}
+	}
+}
+
+func (s *slHandler) isIdle(serviceInstance *models.ServiceInstance, idleAfter time.Duration) bool {
+	// Suspended, being suspended or resumed, or queued
+	if serviceInstance.State != "" || serviceInstance.IsBeingDeleted() {
+		return false
+	}
+
+	lastActiveAt := serviceInstance.LastActiveAt
+	if lastActiveAt.IsZero() {
+		lastActiveAt = serviceInstance.UpdatedAt
+	}
+	if time.Since(lastActiveAt) < idleAfter {
+		return false
+	}
+
+	bindings, err := s.modelsRepo.AssociatedServiceBindings(serviceInstance.Id)
+	if err != nil {
+		log.Error("Unable to fetch service bindings from db", err)
+		return false
+	}
+	if len(bindings) > 0 {
+		return false
+	}
+
+	provisionState, err := s.provisionTaskState(serviceInstance)
+	if err != nil {
+		log.Errorf("Unable to get provision state of instance:%s, %s", serviceInstance.Id, err)
+		return false
+	}
+	if !provisionState.IsSuccess() {
+		return false
+	}
+
+	log.Infof("Instance:%s has been idle since %s", serviceInstance.Id, lastActiveAt)
+	return true
+}
@@ -0,0 +1,121 @@
+package health
+
+import (
+	"github.com/op/go-logging"
+	"github.com/predix/fabric-service-broker/bosh"
+)
+
+var log = logging.MustGetLogger("health")
+
+type Status string
+
+const (
+	// All instances are running
+	StatusHealthy Status = "healthy"
+	// Some instances are failing but validators can still reach consensus
+	StatusDegraded Status = "degraded"
+	// More validators are failing than pbft can tolerate
+	StatusUnhealthy Status = "unhealthy"
+)
+
+type InstanceHealth struct {
+	Job          string   `json:"job"`
+	Index        *int     `json:"index"`
+	Id           string   `json:"id"`
+	AZ           string   `json:"az"`
+	IPs          []string `json:"ips"`
+	ProcessState string   `json:"process_state"`
+	Healthy      bool     `json:"healthy"`
+	Reason       string   `json:"reason,omitempty"`
+}
+
+type Report struct {
+	Status            Status           `json:"status"`
+	Validators        int              `json:"validators"`
+	FailingValidators int              `json:"failing_validators"`
+	Instances         []InstanceHealth `json:"instances"`
+}
+
+// NewReport computes health of a block chain network from the state of its
+// BOSH instances. Instances of validatorJob are the pbft validators. If
+// pinger is not nil, running validators are additionally pinged on their
+// REST endpoint.
+func NewReport(instances bosh.Instances, validatorJob string, pinger Pinger) Report {
+	report := Report{
+		Status:    StatusHealthy,
+		Instances: []InstanceHealth{},
+	}
+
+	failingOthers := 0
+	for _, instance := range instances {
+		instanceHealth := newInstanceHealth(instance)
+		isValidator := instance.JobName == validatorJob
+
+		if instanceHealth.Healthy && isValidator && pinger != nil {
+			err := pinger.Ping(instance.IP())
+			if err != nil {
+				log.Infof("Ping of %s/%s failed: %s", instance.JobName, instance.Id, err)
+				instanceHealth.Healthy = false
+				instanceHealth.Reason = err.Error()
+			}
+		}
+
+		if isValidator {
+			report.Validators++
+			if !instanceHealth.Healthy {
+				report.FailingValidators++
+			}
+		} else if !instanceHealth.Healthy {
+			failingOthers++
+		}
+		report.Instances = append(report.Instances, instanceHealth)
+	}
+
+	switch {
+	case report.FailingValidators > FaultTolerance(report.Validators):
+		report.Status = StatusUnhealthy
+	case report.FailingValidators > 0 || failingOthers > 0:
+		report.Status = StatusDegraded
+	}
+	return report
+}
+
+// MajorityFailing returns true if more than half of validators are failing
+func (r Report) MajorityFailing() bool {
+	return r.FailingValidators*2 > r.Validators
+}
+
+// FaultTolerance returns the number of validators that can fail in a pbft
+// network of n validators without halting it i.e. f for n >= 3f+1
+func FaultTolerance(validators int) int {
+	if validators < 1 {
+		return 0
+	}
+	return (validators - 1) / 3
+}
+
+func newInstanceHealth(instance bosh.InstanceDetails) InstanceHealth {
+	instanceHealth := InstanceHealth{
+		Job:          instance.JobName,
+		Index:        instance.Index,
+		Id:           instance.Id,
+		AZ:           instance.AZ,
+		IPs:          instance.IPs,
+		ProcessState: instance.ProcessState,
+		Healthy:      true,
+	}
+
+	if instance.ProcessState != bosh.ProcessStateRunning {
+		instanceHealth.Healthy = false
+		instanceHealth.Reason = "process state is " + instance.ProcessState
+		return instanceHealth
+	}
+	for _, process := range instance.Processes {
+		if process.State != bosh.ProcessStateRunning {
+			instanceHealth.Healthy = false
+			instanceHealth.Reason = "process " + process.Name + " is " + process.State
+			return instanceHealth
+		}
+	}
+	return instanceHealth
+}
@@ -0,0 +1,92 @@
+package health_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/predix/fabric-service-broker/bosh"
+	"github.com/predix/fabric-service-broker/health"
+
+	. "gopkg.in/go-playground/assert.v1"
+)
+
+type fakePinger struct {
+	failing map[string]bool
+}
+
+func (p *fakePinger) Ping(ip string) error {
+	if p.failing[ip] {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func peer(ip, state string) bosh.InstanceDetails {
+	return bosh.InstanceDetails{
+		JobName:      "peer",
+		IPs:          []string{ip},
+		ProcessState: state,
+	}
+}
+
+func TestNewReport_Healthy(t *testing.T) {
+	instances := bosh.Instances{
+		peer("10.0.0.1", bosh.ProcessStateRunning),
+		peer("10.0.0.2", bosh.ProcessStateRunning),
+		peer("10.0.0.3", bosh.ProcessStateRunning),
+		peer("10.0.0.4", bosh.ProcessStateRunning),
+	}
+	report := health.NewReport(instances, "peer", nil)
+	Equal(t, report.Status, health.StatusHealthy)
+	Equal(t, report.Validators, 4)
+	Equal(t, report.FailingValidators, 0)
+	Equal(t, report.MajorityFailing(), false)
+}
+
+func TestNewReport_Degraded(t *testing.T) {
+	instances := bosh.Instances{
+		peer("10.0.0.1", bosh.ProcessStateRunning),
+		peer("10.0.0.2", bosh.ProcessStateRunning),
+		peer("10.0.0.3", bosh.ProcessStateRunning),
+		peer("10.0.0.4", bosh.ProcessStateFailing),
+	}
+	report := health.NewReport(instances, "peer", nil)
+	Equal(t, report.Status, health.StatusDegraded)
+	Equal(t, report.FailingValidators, 1)
+	Equal(t, report.Instances[3].Healthy, false)
+	Equal(t, report.Instances[3].Reason, "process state is failing")
+}
+
+func TestNewReport_UnhealthyWithPinger(t *testing.T) {
+	instances := bosh.Instances{
+		peer("10.0.0.1", bosh.ProcessStateRunning),
+		peer("10.0.0.2", bosh.ProcessStateRunning),
+		peer("10.0.0.3", bosh.ProcessStateRunning),
+		peer("10.0.0.4", bosh.ProcessStateFailing),
+	}
+	pinger := &fakePinger{failing: map[string]bool{"10.0.0.2": true}}
+	report := health.NewReport(instances, "peer", pinger)
+	Equal(t, report.Status, health.StatusUnhealthy)
+	Equal(t, report.FailingValidators, 2)
+	Equal(t, report.Instances[1].Reason, "connection refused")
+	Equal(t, report.MajorityFailing(), false)
+}
+
+func TestNewReport_MajorityFailing(t *testing.T) {
+	instances := bosh.Instances{
+		peer("10.0.0.1", bosh.ProcessStateRunning),
+		peer("10.0.0.2", bosh.ProcessStateStopped),
+		peer("10.0.0.3", bosh.ProcessStateUnresponsive),
+		peer("10.0.0.4", bosh.ProcessStateFailing),
+	}
+	report := health.NewReport(instances, "peer", nil)
+	Equal(t, report.Status, health.StatusUnhealthy)
+	Equal(t, report.MajorityFailing(), true)
+}
+
+func TestFaultTolerance(t *testing.T) {
+	Equal(t, health.FaultTolerance(0), 0)
+	Equal(t, health.FaultTolerance(1), 0)
+	Equal(t, health.FaultTolerance(4), 1)
+	Equal(t, health.FaultTolerance(7), 2)
+}
@@ -0,0 +1,46 @@
+package health
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const DefaultPeerRestPort = 5000
+
+// Pinger checks that a peer responds on given IP
+type Pinger interface {
+	Ping(ip string) error
+}
+
+type peerRestPinger struct {
+	httpClient *http.Client
+	port       int
+}
+
+// NewPeerRestPinger returns a pinger that queries the /chain endpoint of
+// peer REST API
+func NewPeerRestPinger(port int, timeout time.Duration) Pinger {
+	return &peerRestPinger{
+		httpClient: &http.Client{Timeout: timeout},
+		port:       port,
+	}
+}
+
+func (p *peerRestPinger) Ping(ip string) error {
+	if ip == "" {
+		return errors.New("no IP to ping")
+	}
+
+	resp, err := p.httpClient.Get(fmt.Sprintf("http://%s:%d/chain", ip, p.port))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(fmt.Sprintf("peer REST API responded with status %d", resp.StatusCode))
+	}
+	return nil
+}
@@ -5,5 +5,13 @@ type BindCredentials struct {
 }
 
 type BlockChainCredentials struct {
-	PeerEndpoints []string `json:"peers"`
+	PeerEndpoints      []string            `json:"peers"`
+	MemberServiceUsers []MemberServiceUser `json:"member_service_users,omitempty"`
+}
+
+// MemberServiceUser is a client of the membership service of permissioned
+// block chains used to enroll with the network
+type MemberServiceUser struct {
+	EnrollId     string `json:"enroll_id"`
+	EnrollSecret string `json:"enroll_secret"`
 }
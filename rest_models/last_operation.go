@@ -1,6 +1,11 @@
 package rest_models
 
-import "github.com/predix/fabric-service-broker/bosh"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/predix/fabric-service-broker/bosh"
+)
 
 const (
 	StateInProgress = "in progress"
@@ -9,39 +14,173 @@ const (
 
 	OpProvision   = "provision"
 	OpDeprovision = "deprovision"
+	OpSuspend     = "suspend"
+	OpResume      = "resume"
+
+	// Longest errand output included in description of failed operation
+	maxErrandOutputLength = 500
 )
 
+// Descriptions of an operation while its BOSH task runs, once it succeeded
+// and once it failed, and the action shown along with task events
+type operationDescription struct {
+	inProgress string
+	succeeded  string
+	failed     string
+	action     string
+}
+
+var operationDescriptions = map[string]operationDescription{
+	OpProvision: {
+		inProgress: "Still working to get that block chain deployed",
+		succeeded:  "Yipee, block chain is deployed",
+		failed:     "Ooops, could not deploy block chain",
+		action:     "Deploying block chain",
+	},
+	OpDeprovision: {
+		inProgress: "Still working to delete that block chain",
+		succeeded:  "Block chain gone :( Please come back and create another one",
+		failed:     "No we could not delete the block chain...",
+		action:     "Deleting block chain",
+	},
+	OpSuspend: {
+		inProgress: "Still working to suspend that block chain",
+		succeeded:  "Block chain is suspended, binding to it resumes it",
+		failed:     "Could not suspend block chain, it stays active",
+		action:     "Suspending block chain",
+	},
+	OpResume: {
+		inProgress: "Still working to resume that block chain",
+		succeeded:  "Block chain is resumed",
+		failed:     "Could not resume block chain, it stays suspended",
+		action:     "Resuming block chain",
+	},
+}
+
 type LastOperationResponse struct {
 	State       string `json:"state"`
 	Description string `json:"description"`
 }
 
-func GetLastOperationResponse(operation, boshState string) LastOperationResponse {
+func GetLastOperationResponse(operation string, boshState bosh.TaskState) LastOperationResponse {
+	description := operationDescriptions[operation]
 	lastOperation := LastOperationResponse{}
-	switch boshState {
-	case bosh.BoshStateProcessing:
-		fallthrough
-	case bosh.BoshStateQueued:
+	switch {
+	case !boshState.IsTerminal():
 		lastOperation.State = StateInProgress
-		if operation == OpProvision {
-			lastOperation.Description = "Still working to get that block chain deployed"
-		} else {
-			lastOperation.Description = "Still working to delete that block chain"
+		lastOperation.Description = description.inProgress
+		if boshState == bosh.BoshStateCancelling {
+			lastOperation.Description = "BOSH task is being cancelled"
 		}
-	case bosh.BoshStateDone:
+	case boshState.IsSuccess():
 		lastOperation.State = StateSucceeded
-		if operation == OpProvision {
-			lastOperation.Description = "Yipee, block chain is deployed"
-		} else {
-			lastOperation.Description = "Block chain gone :( Please come back and create another one"
-		}
+		lastOperation.Description = description.succeeded
 	default:
 		lastOperation.State = StateFailed
-		if operation == OpProvision {
-			lastOperation.Description = "Ooops, could not deploy block chain"
-		} else {
-			lastOperation.Description = "No we could not delete the block chain..."
+		lastOperation.Description = description.failed
+		switch boshState {
+		case bosh.BoshStateCancelled:
+			lastOperation.Description = fmt.Sprintf("%s, BOSH task was cancelled", lastOperation.Description)
+		case bosh.BoshStateTimeout:
+			lastOperation.Description = fmt.Sprintf("%s, BOSH task timed out", lastOperation.Description)
+		}
+	}
+	return lastOperation
+}
+
+// NewLastOperationResponse builds the response for a BOSH task using the task
+// events to describe current stage and progress or the reason of failure.
+func NewLastOperationResponse(operation string, task *bosh.Task, events bosh.TaskEvents) LastOperationResponse {
+	lastOperation := GetLastOperationResponse(operation, task.State)
+
+	action := operationDescriptions[operation].action
+
+	switch lastOperation.State {
+	case StateInProgress:
+		stage := events.StageDescription()
+		if stage != "" {
+			lastOperation.Description = fmt.Sprintf("%s: %s", action, stage)
+		}
+	case StateFailed:
+		reason := events.ErrorMessage()
+		if reason == "" {
+			reason = task.Result
+		}
+		if reason != "" {
+			lastOperation.Description = fmt.Sprintf("%s failed (BOSH task %d): %s", action, task.Id, reason)
+		}
+	}
+	return lastOperation
+}
+
+// NewQueuedOperationResponse builds the response for provision waiting in
+// queue for capacity on BOSH director. position starts at 1.
+func NewQueuedOperationResponse(position, queueLength int) LastOperationResponse {
+	return LastOperationResponse{
+		State:       StateInProgress,
+		Description: fmt.Sprintf("Waiting for capacity on BOSH director, position %d of %d in queue", position, queueLength),
+	}
+}
+
+// NewDispatchOperationResponse builds the response for queued provision
+// that is being dispatched to BOSH director, or that could not be
+// dispatched if dispatchError is set.
+func NewDispatchOperationResponse(dispatchError string) LastOperationResponse {
+	if dispatchError != "" {
+		return LastOperationResponse{
+			State:       StateFailed,
+			Description: fmt.Sprintf("%s: %s", operationDescriptions[OpProvision].failed, dispatchError),
+		}
+	}
+	return LastOperationResponse{
+		State:       StateInProgress,
+		Description: "Dispatching provision to BOSH director",
+	}
+}
+
+// NewErrandSkippedResponse builds the response for provision whose post
+// deploy errand was not run as the instance is being deleted
+func NewErrandSkippedResponse(errandName string) LastOperationResponse {
+	return LastOperationResponse{
+		State:       StateFailed,
+		Description: fmt.Sprintf("Errand %s not run as service instance is being deleted", errandName),
+	}
+}
+
+// NewErrandOperationResponse builds the response for post deploy errand run
+// as the final step of provision. result is only used once the errand task
+// is done.
+func NewErrandOperationResponse(errandName string, task *bosh.Task, events bosh.TaskEvents, result *bosh.ErrandResult) LastOperationResponse {
+	lastOperation := LastOperationResponse{}
+	action := fmt.Sprintf("Block chain deployed, running errand %s", errandName)
+
+	switch {
+	case !task.State.IsTerminal():
+		lastOperation.State = StateInProgress
+		lastOperation.Description = action
+		stage := events.StageDescription()
+		if stage != "" {
+			lastOperation.Description = fmt.Sprintf("%s: %s", action, stage)
+		}
+	case !task.State.IsSuccess():
+		lastOperation.State = StateFailed
+		reason := events.ErrorMessage()
+		if reason == "" {
+			reason = task.Result
+		}
+		lastOperation.Description = fmt.Sprintf("Errand %s failed (BOSH task %d): %s", errandName, task.Id, reason)
+	case result != nil && result.ExitCode != 0:
+		lastOperation.State = StateFailed
+		output := strings.TrimSpace(result.Stderr)
+		if output == "" {
+			output = strings.TrimSpace(result.Stdout)
 		}
+		if len(output) > maxErrandOutputLength {
+			output = "..." + output[len(output)-maxErrandOutputLength:]
+		}
+		lastOperation.Description = fmt.Sprintf("Errand %s exited with code %d (BOSH task %d): %s", errandName, result.ExitCode, task.Id, output)
+	default:
+		lastOperation = GetLastOperationResponse(OpProvision, task.State)
 	}
 	return lastOperation
 }
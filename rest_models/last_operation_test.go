@@ -28,3 +28,105 @@ func TestGetLastOperationResponse_Failed(t *testing.T) {
 	lastOperationResponse := rest_models.GetLastOperationResponse(rest_models.OpProvision, "failed")
 	Equal(t, lastOperationResponse.State, rest_models.StateFailed)
 }
+
+func TestNewLastOperationResponse_InProgress(t *testing.T) {
+	task := &bosh.Task{Id: 12, State: bosh.BoshStateProcessing}
+	events := bosh.TaskEvents{
+		{Stage: "Updating instance", Tags: []string{"peer"}, Total: 4, State: "finished"},
+		{Stage: "Updating instance", Tags: []string{"peer"}, Total: 4, State: "started"},
+	}
+	lastOperationResponse := rest_models.NewLastOperationResponse(rest_models.OpProvision, task, events)
+	Equal(t, lastOperationResponse.State, rest_models.StateInProgress)
+	Equal(t, lastOperationResponse.Description, "Deploying block chain: Updating instance peer (25%)")
+}
+
+func TestNewLastOperationResponse_FailedWithEventError(t *testing.T) {
+	task := &bosh.Task{Id: 12, State: "error", Result: "some result"}
+	events := bosh.TaskEvents{
+		{Error: &bosh.TaskEventError{Code: 100, Message: "Failed to acquire lock"}},
+	}
+	lastOperationResponse := rest_models.NewLastOperationResponse(rest_models.OpDeprovision, task, events)
+	Equal(t, lastOperationResponse.State, rest_models.StateFailed)
+	Equal(t, lastOperationResponse.Description, "Deleting block chain failed (BOSH task 12): Failed to acquire lock")
+}
+
+func TestNewLastOperationResponse_FailedWithTaskResult(t *testing.T) {
+	task := &bosh.Task{Id: 12, State: "error", Result: "Stemcell not found"}
+	lastOperationResponse := rest_models.NewLastOperationResponse(rest_models.OpProvision, task, nil)
+	Equal(t, lastOperationResponse.State, rest_models.StateFailed)
+	Equal(t, lastOperationResponse.Description, "Deploying block chain failed (BOSH task 12): Stemcell not found")
+}
+
+func TestGetLastOperationResponse_Cancelling(t *testing.T) {
+	lastOperationResponse := rest_models.GetLastOperationResponse(rest_models.OpProvision, bosh.BoshStateCancelling)
+	Equal(t, lastOperationResponse.State, rest_models.StateInProgress)
+}
+
+func TestGetLastOperationResponse_Cancelled(t *testing.T) {
+	lastOperationResponse := rest_models.GetLastOperationResponse(rest_models.OpProvision, bosh.BoshStateCancelled)
+	Equal(t, lastOperationResponse.State, rest_models.StateFailed)
+}
+
+func TestGetLastOperationResponse_Error(t *testing.T) {
+	lastOperationResponse := rest_models.GetLastOperationResponse(rest_models.OpDeprovision, bosh.BoshStateError)
+	Equal(t, lastOperationResponse.State, rest_models.StateFailed)
+}
+
+func TestGetLastOperationResponse_Timeout(t *testing.T) {
+	lastOperationResponse := rest_models.GetLastOperationResponse(rest_models.OpProvision, bosh.BoshStateTimeout)
+	Equal(t, lastOperationResponse.State, rest_models.StateFailed)
+}
+
+func TestNewErrandOperationResponse_Running(t *testing.T) {
+	task := &bosh.Task{Id: 13, State: bosh.BoshStateProcessing}
+	lastOperationResponse := rest_models.NewErrandOperationResponse("smoke-tests", task, nil, nil)
+	Equal(t, lastOperationResponse.State, rest_models.StateInProgress)
+	Equal(t, lastOperationResponse.Description, "Block chain deployed, running errand smoke-tests")
+}
+
+func TestNewErrandOperationResponse_NonZeroExitCode(t *testing.T) {
+	task := &bosh.Task{Id: 13, State: bosh.BoshStateDone}
+	result := &bosh.ErrandResult{ExitCode: 2, Stderr: "no consensus\n"}
+	lastOperationResponse := rest_models.NewErrandOperationResponse("smoke-tests", task, nil, result)
+	Equal(t, lastOperationResponse.State, rest_models.StateFailed)
+	Equal(t, lastOperationResponse.Description, "Errand smoke-tests exited with code 2 (BOSH task 13): no consensus")
+}
+
+func TestNewErrandOperationResponse_Succeeded(t *testing.T) {
+	task := &bosh.Task{Id: 13, State: bosh.BoshStateDone}
+	result := &bosh.ErrandResult{ExitCode: 0}
+	lastOperationResponse := rest_models.NewErrandOperationResponse("smoke-tests", task, nil, result)
+	Equal(t, lastOperationResponse.State, rest_models.StateSucceeded)
+}
+
+func TestNewQueuedOperationResponse(t *testing.T) {
+	lastOperationResponse := rest_models.NewQueuedOperationResponse(2, 5)
+	Equal(t, lastOperationResponse.State, rest_models.StateInProgress)
+	Equal(t, lastOperationResponse.Description, "Waiting for capacity on BOSH director, position 2 of 5 in queue")
+}
+
+func TestGetLastOperationResponse_Suspend(t *testing.T) {
+	lastOperationResponse := rest_models.GetLastOperationResponse(rest_models.OpSuspend, bosh.BoshStateDone)
+	Equal(t, lastOperationResponse.State, rest_models.StateSucceeded)
+	Equal(t, lastOperationResponse.Description, "Block chain is suspended, binding to it resumes it")
+
+	lastOperationResponse = rest_models.GetLastOperationResponse(rest_models.OpSuspend, "error")
+	Equal(t, lastOperationResponse.State, rest_models.StateFailed)
+	Equal(t, lastOperationResponse.Description, "Could not suspend block chain, it stays active")
+}
+
+func TestNewLastOperationResponse_ResumeFailed(t *testing.T) {
+	task := &bosh.Task{Id: 14, State: "error", Result: "Timed out pinging VM"}
+	lastOperationResponse := rest_models.NewLastOperationResponse(rest_models.OpResume, task, nil)
+	Equal(t, lastOperationResponse.State, rest_models.StateFailed)
+	Equal(t, lastOperationResponse.Description, "Resuming block chain failed (BOSH task 14): Timed out pinging VM")
+}
+
+func TestNewDispatchOperationResponse(t *testing.T) {
+	lastOperationResponse := rest_models.NewDispatchOperationResponse("")
+	Equal(t, lastOperationResponse.State, rest_models.StateInProgress)
+
+	lastOperationResponse = rest_models.NewDispatchOperationResponse("Invalid parameter peer: must be an object")
+	Equal(t, lastOperationResponse.State, rest_models.StateFailed)
+	Equal(t, lastOperationResponse.Description, "Ooops, could not deploy block chain: Invalid parameter peer: must be an object")
+}
@@ -13,14 +13,15 @@ type ServiceCatalog struct {
 type Services []Service
 
 type Service struct {
-	Name          string          `json:"name"`
-	Id            string          `json:"id"`
-	Description   string          `json:"description"`
-	Tags          []string        `json:"tags"`
-	Bindable      bool            `json:"bindable"`
-	MetaData      ServiceMetaData `json:"metadata"`
-	PlanUpdatable bool            `json:"plan_updateable"`
-	Plans         []Plan          `json:"plans"`
+	Name                 string          `json:"name"`
+	Id                   string          `json:"id"`
+	Description          string          `json:"description"`
+	Tags                 []string        `json:"tags"`
+	Bindable             bool            `json:"bindable"`
+	InstancesRetrievable bool            `json:"instances_retrievable"`
+	MetaData             ServiceMetaData `json:"metadata"`
+	PlanUpdatable        bool            `json:"plan_updateable"`
+	Plans                []Plan          `json:"plans"`
 }
 
 type ServiceMetaData struct {
@@ -56,7 +57,8 @@ func GetDefaultService() Service {
 			DisplayName: "Hyperledger fabric block chain",
 			Description: "Permissioned block chain implementation",
 		},
-		PlanUpdatable: false,
+		PlanUpdatable:        false,
+		InstancesRetrievable: true,
 		Plans: []Plan{
 			Plan{
 				Id:          PermissionlessPlanId,
@@ -0,0 +1,10 @@
+package rest_models
+
+import "github.com/predix/fabric-service-broker/health"
+
+type ServiceInstanceResponse struct {
+	ServiceId  string                 `json:"service_id"`
+	PlanId     string                 `json:"plan_id"`
+	Parameters map[string]interface{} `json:"parameters"`
+	Health     *health.Report         `json:"health,omitempty"`
+}
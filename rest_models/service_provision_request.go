@@ -5,4 +5,18 @@ type ServiceProvisionRequest struct {
 	PlanId           string `json:"plan_id"`
 	ServiceId        string `json:"service_id"`
 	SpaceGuid        string `json:"space_guid"`
+	// Passed to manifest templates
+	Parameters map[string]interface{} `json:"parameters"`
+	// Platform specific details of the instance, e.g. names of org and
+	// space on Cloud Foundry. Not sent by older platforms.
+	Context *ProvisionContext `json:"context"`
+}
+
+type ProvisionContext struct {
+	Platform         string `json:"platform"`
+	OrganizationGuid string `json:"organization_guid"`
+	SpaceGuid        string `json:"space_guid"`
+	OrganizationName string `json:"organization_name"`
+	SpaceName        string `json:"space_name"`
+	InstanceName     string `json:"instance_name"`
 }